@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	log "github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	assignmentSourceAnnotation = "annotation"
+	assignmentSourceConfigMap  = "configmap"
+)
+
+var (
+	// assignments are the pods assigned by the device plugins, they're used
+	// instead of the pod annotations if they're loaded
+	assignments map[types.UID]gpushare.Assignment
+)
+
+// loadAssignments reads the assigned pods from the ConfigMaps of all the nodes
+func loadAssignments() error {
+	cms, err := clientset.CoreV1().ConfigMaps(gpushare.AssignmentConfigMapNamespace).List(metav1.ListOptions{
+		LabelSelector: gpushare.AssignmentConfigMapLabel + "=true",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get the assignments due to %v", err)
+	}
+
+	assignments = map[types.UID]gpushare.Assignment{}
+	for _, cm := range cms.Items {
+		record := gpushare.AssignmentRecord{}
+		if err = json.Unmarshal([]byte(cm.Data[gpushare.AssignmentConfigMapKey]), &record); err != nil {
+			log.Warningf("Failed to parse the assignments in configmap %s due to %v", cm.Name, err)
+			continue
+		}
+		for _, a := range record.Assignments {
+			assignments[a.UID] = a
+		}
+	}
+
+	return nil
+}
+
+// prepareAssignments loads the assigned pods from the source, the pod annotations are read
+// without loading anything
+func prepareAssignments(source string) error {
+	switch source {
+	case assignmentSourceAnnotation:
+		assignments = nil
+		return nil
+	case assignmentSourceConfigMap:
+		return loadAssignments()
+	default:
+		return fmt.Errorf("unknown assignment source %q", source)
+	}
+}
+
+// isAssigned returns true if the device plugin assigned the GPU to the pod, by the loaded
+// assignments or the assigned flag of the pod
+func isAssigned(pod *v1.Pod) bool {
+	if _, ok := assignments[pod.UID]; ok {
+		return true
+	}
+	return pod.Annotations[gpushare.EnvAssignedFlag] == "true"
+}
@@ -40,6 +40,10 @@ func displayDetails(nodeInfos []*NodeInfo) {
 		fmt.Fprintf(w, "\n")
 		fmt.Fprintf(w, "NAME:\t%s\n", nodeInfo.node.Name)
 		fmt.Fprintf(w, "IPADDRESS:\t%s\n", address)
+		if mixedUnits {
+			// the memory of the node is counted in its own unit, the cluster total in memoryUnit
+			fmt.Fprintf(w, "MEMORY UNIT:\t%s\n", nodeInfo.memoryUnit)
+		}
 		fmt.Fprintf(w, "\n")
 
 		usedGPUMemInNode := 0
@@ -52,16 +56,19 @@ func displayDetails(nodeInfos []*NodeInfo) {
 		if nodeInfo.hasPendingGPUMemory() {
 			buf.WriteString("Pending(Allocated)\t")
 		}
+		if nodeInfo.usage != nil {
+			buf.WriteString("USED\t")
+		}
 		buf.WriteString("\n")
-		fmt.Fprintf(w, buf.String())
+		fmt.Fprint(w, buf.String())
 
 		var buffer bytes.Buffer
 		exists := map[types.UID]bool{}
 		for i, dev := range nodeInfo.devs {
 			usedGPUMemInNode += dev.usedGPUMem
 			for _, pod := range dev.pods {
-				if _,ok := exists[pod.UID]; ok {
-					continue 
+				if _, ok := exists[pod.UID]; ok {
+					continue
 				}
 				buffer.WriteString(fmt.Sprintf("%s\t%s\t", pod.Name, pod.Namespace))
 				count := nodeInfo.gpuCount
@@ -70,10 +77,10 @@ func displayDetails(nodeInfos []*NodeInfo) {
 				}
 
 				for k := 0; k < count; k++ {
-					allocation := GetAllocation(&pod) 
+					allocation := GetAllocation(&pod)
 					if len(allocation) != 0 {
 						buffer.WriteString(fmt.Sprintf("%d\t", allocation[k]))
-						continue 
+						continue
 					}
 					if k == i || (i == -1 && k == nodeInfo.gpuCount) {
 						buffer.WriteString(fmt.Sprintf("%d\t", getGPUMemoryInPod(pod)))
@@ -81,14 +88,17 @@ func displayDetails(nodeInfos []*NodeInfo) {
 						buffer.WriteString("0\t")
 					}
 				}
+				if nodeInfo.usage != nil {
+					buffer.WriteString(fmt.Sprintf("%s\t", formatUsed(nodeInfo.usage.UsedOfPod(pod.UID, -1))))
+				}
 				buffer.WriteString("\n")
-				exists[pod.UID] = true 
+				exists[pod.UID] = true
 			}
 		}
 		if prtLineLen == 0 {
 			prtLineLen = buffer.Len() + 10
 		}
-		fmt.Fprintf(w, buffer.String())
+		fmt.Fprint(w, buffer.String())
 
 		var gpuUsageInNode float64 = 0
 		if totalGPUMemInNode > 0 {
@@ -98,20 +108,26 @@ func displayDetails(nodeInfos []*NodeInfo) {
 		}
 
 		fmt.Fprintf(w, "Allocated :\t%d (%d%%)\t\n", usedGPUMemInNode, int64(gpuUsageInNode))
+		if used, ok := nodeInfo.usedOfNode(); ok {
+			fmt.Fprintf(w, "Used :\t%s (%d%%)\t\n", formatUsed(used), int64(used/float64(totalGPUMemInNode)*100))
+		}
 		fmt.Fprintf(w, "Total :\t%d \t\n", nodeInfo.gpuTotalMemory)
+		if nodeInfo.gpuReservedMemory > 0 {
+			fmt.Fprintf(w, "Reserved :\t%d \t\n", nodeInfo.gpuReservedMemory*nodeInfo.gpuCount)
+		}
 		// fmt.Fprintf(w, "-----------------------------------------------------------------------------------------\n")
 		var prtLine bytes.Buffer
 		for i := 0; i < prtLineLen; i++ {
 			prtLine.WriteString("-")
 		}
 		prtLine.WriteString("\n")
-		fmt.Fprintf(w, prtLine.String())
-		totalGPUMemInCluster += int64(totalGPUMemInNode)
-		usedGPUMemInCluster += int64(usedGPUMemInNode)
+		fmt.Fprint(w, prtLine.String())
+		totalGPUMemInCluster += int64(nodeInfo.inClusterUnit(totalGPUMemInNode))
+		usedGPUMemInCluster += int64(nodeInfo.inClusterUnit(usedGPUMemInNode))
 	}
 	fmt.Fprintf(w, "\n")
 	fmt.Fprintf(w, "\n")
-	fmt.Fprintf(w, "Allocated/Total GPU Memory In Cluster:\t")
+	fmt.Fprintf(w, "Allocated/Total GPU Memory In Cluster(%s):\t", memoryUnit)
 	log.V(2).Infof("gpu: %s, allocated GPU Memory %s", strconv.FormatInt(totalGPUMemInCluster, 10),
 		strconv.FormatInt(usedGPUMemInCluster, 10))
 
@@ -148,6 +164,8 @@ func displaySummary(nodeInfos []*NodeInfo) {
 	)
 
 	hasPendingGPU := hasPendingGPUMemory(nodeInfos)
+	hasReservedGPU := hasReservedGPUMemory(nodeInfos)
+	hasUsage := hasMemoryUsage(nodeInfos)
 
 	maxGPUCount = getMaxGPUCount(nodeInfos)
 
@@ -155,15 +173,22 @@ func displaySummary(nodeInfos []*NodeInfo) {
 	buffer.WriteString("NAME\tIPADDRESS\t")
 	for i := 0; i < maxGPUCount; i++ {
 		buffer.WriteString(fmt.Sprintf("GPU%d(Allocated/Total)\t", i))
+		if hasUsage {
+			buffer.WriteString(fmt.Sprintf("GPU%d(Used)\t", i))
+		}
 	}
 
 	if hasPendingGPU {
 		buffer.WriteString("PENDING(Allocated)\t")
 	}
+
+	if hasReservedGPU {
+		buffer.WriteString("RESERVED\t")
+	}
 	buffer.WriteString(fmt.Sprintf("GPU Memory(%s)\n", memoryUnit))
 
 	// fmt.Fprintf(w, "NAME\tIPADDRESS\tROLE\tGPU(Allocated/Total)\tPENDING(Allocated)\n")
-	fmt.Fprintf(w, buffer.String())
+	fmt.Fprint(w, buffer.String())
 	for _, nodeInfo := range nodeInfos {
 		address := "unknown"
 		if len(nodeInfo.node.Status.Addresses) > 0 {
@@ -187,7 +212,7 @@ func displaySummary(nodeInfos []*NodeInfo) {
 		for i := 0; i < maxGPUCount; i++ {
 			gpuMemInfo := "0/0"
 			if dev, ok := nodeInfo.devs[i]; ok {
-				gpuMemInfo = dev.String()
+				gpuMemInfo = fmt.Sprintf("%d/%d", nodeInfo.inClusterUnit(dev.usedGPUMem), nodeInfo.inClusterUnit(dev.totalGPUMem))
 				usedGPUMemInNode += dev.usedGPUMem
 			}
 			gpuMemInfos = append(gpuMemInfos, gpuMemInfo)
@@ -195,23 +220,32 @@ func displaySummary(nodeInfos []*NodeInfo) {
 
 		// check if there is pending dev
 		if dev, ok := nodeInfo.devs[-1]; ok {
-			pendingGPUMemInfo = fmt.Sprintf("%d", dev.usedGPUMem)
+			pendingGPUMemInfo = fmt.Sprintf("%d", nodeInfo.inClusterUnit(dev.usedGPUMem))
 			usedGPUMemInNode += dev.usedGPUMem
 		}
 
+		// the nodes may count gpu-mem in different units, they're shown in memoryUnit
+		usedGPUMemInNode = nodeInfo.inClusterUnit(usedGPUMemInNode)
+		totalGPUMemInNode = nodeInfo.inClusterUnit(totalGPUMemInNode)
 		nodeGPUMemInfo := fmt.Sprintf("%d/%d", usedGPUMemInNode, totalGPUMemInNode)
 
 		var buf bytes.Buffer
 		buf.WriteString(fmt.Sprintf("%s\t%s\t", nodeInfo.node.Name, address))
 		for i := 0; i < maxGPUCount; i++ {
 			buf.WriteString(fmt.Sprintf("%s\t", gpuMemInfos[i]))
+			if hasUsage {
+				buf.WriteString(fmt.Sprintf("%s\t", nodeInfo.usedOfGPU(i)))
+			}
 		}
 		if hasPendingGPU {
 			buf.WriteString(fmt.Sprintf("%s\t", pendingGPUMemInfo))
 		}
+		if hasReservedGPU {
+			buf.WriteString(fmt.Sprintf("%d\t", nodeInfo.inClusterUnit(nodeInfo.gpuReservedMemory*nodeInfo.gpuCount)))
+		}
 
 		buf.WriteString(fmt.Sprintf("%s\n", nodeGPUMemInfo))
-		fmt.Fprintf(w, buf.String())
+		fmt.Fprint(w, buf.String())
 
 		if prtLineLen == 0 {
 			prtLineLen = buf.Len() + 20
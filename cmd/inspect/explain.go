@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// the subcommand to explain why a gpu-mem pod can't be placed or started
+const explainCommand = "explain"
+
+// the levels of the findings
+const (
+	levelOK   = "OK"
+	levelWarn = "WARN"
+	levelFail = "FAIL"
+)
+
+// maxExplainEvents is the number of the latest warning events of the pod to show
+const maxExplainEvents = 5
+
+type finding struct {
+	level   string
+	message string
+}
+
+// explainPod checks the annotations of the pod, the GPU on its node and the competing pods. The node
+// infos are of the node of the pod if it's scheduled, or of all the gpushare nodes if it's not.
+func explainPod(pod *v1.Pod, nodeInfos []*NodeInfo, events []v1.Event, now time.Time) []finding {
+	findings := []finding{}
+	add := func(level, format string, args ...interface{}) {
+		findings = append(findings, finding{level: level, message: fmt.Sprintf(format, args...)})
+	}
+
+	memory := int(gpushare.GPUMemoryOfPod(pod))
+	if memory <= 0 {
+		add(levelFail, "the pod doesn't request %s in the limits of its containers, so the device plugin ignores it", resourceName)
+		return findings
+	}
+
+	if pod.Spec.NodeName == "" {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == v1.PodScheduled && condition.Status != v1.ConditionTrue {
+				add(levelWarn, "the pod is not scheduled: %s", condition.Message)
+			}
+		}
+		fit := buildClusterFit(nodeInfos, memory)
+		if fit.binpack == nil {
+			largest := "no GPU"
+			if fit.largest != nil {
+				largest = fmt.Sprintf("%d on %s", fit.largest.clusterFree(), fit.largest)
+			}
+			add(levelFail, "no GPU in the cluster has %d %s free, the largest free slot is %s and %d%% of the free memory is in smaller slots",
+				memory, podMemoryUnit(""), largest, fragmentation(fit.unusable, fit.free))
+		} else {
+			add(levelOK, "GPUs could host the pod, e.g. %s (free %d), check the node selector, the tolerations and that kube-scheduler calls the gpushare scheduler extender",
+				fit.binpack, fit.binpack.clusterFree())
+		}
+		return append(findings, explainEvents(events)...)
+	}
+
+	var nodeInfo *NodeInfo
+	for _, info := range nodeInfos {
+		if info.node.Name == pod.Spec.NodeName {
+			nodeInfo = info
+		}
+	}
+	if nodeInfo == nil || nodeInfo.gpuTotalMemory <= 0 {
+		add(levelFail, "node %s doesn't report %s, check that the device plugin is running on it", pod.Spec.NodeName, resourceName)
+		return append(findings, explainEvents(events)...)
+	}
+
+	// the annotations set by the scheduler extender
+	assumeTime, err := gpushare.AssumeTime(pod)
+	if err != nil {
+		add(levelFail, "the annotation %s is invalid: %v", gpushare.EnvResourceAssumeTime, err)
+	} else if assumeTime == 0 {
+		add(levelFail, "the pod has no %s, the gpushare scheduler extender didn't bind it, check that kube-scheduler calls the extender", gpushare.EnvResourceAssumeTime)
+	} else {
+		add(levelOK, "the pod was assumed by the scheduler extender %s ago", now.Sub(time.Unix(0, int64(assumeTime))).Round(time.Second))
+	}
+
+	allocation := GetAllocation(pod)
+	index := -1
+	value, ok := pod.Annotations[gpushare.EnvResourceIndex]
+	if !ok {
+		if len(allocation) == 0 {
+			add(levelFail, "the pod has no %s, the scheduler extender didn't pick a GPU for it", gpushare.EnvResourceIndex)
+		}
+	} else if index, err = strconv.Atoi(value); err != nil || index < 0 {
+		add(levelFail, "the GPU index %q of the pod is invalid", value)
+		index = -1
+	} else if index >= nodeInfo.gpuCount {
+		add(levelFail, "GPU %d doesn't exist on node %s with %d GPUs, the device plugin can't assign it and the containers get NVIDIA_VISIBLE_DEVICES=no-gpu-has-...-to-run",
+			index, nodeInfo.node.Name, nodeInfo.gpuCount)
+	} else {
+		add(levelOK, "the pod is placed on GPU %d of node %s", index, nodeInfo.node.Name)
+	}
+
+	if value, ok := pod.Annotations[gpushareAllocationFlag]; ok {
+		if len(allocation) == 0 {
+			add(levelWarn, "the allocation %s is invalid: %s", gpushareAllocationFlag, value)
+		} else {
+			add(levelOK, "the allocation of the scheduler extender is %s", formatAllocation(allocation))
+		}
+		for i := range allocation {
+			if i < 0 || i >= nodeInfo.gpuCount {
+				add(levelFail, "GPU %d in the allocation doesn't exist on node %s with %d GPUs", i, nodeInfo.node.Name, nodeInfo.gpuCount)
+			}
+		}
+	}
+
+	// the device plugin with --assignment-store=file or configmap keeps the flag false
+	assigned, ok := pod.Annotations[gpushare.EnvAssignedFlag]
+	switch {
+	case isAssigned(pod):
+		add(levelOK, "the device plugin assigned the GPU to the pod")
+	case !ok:
+		add(levelFail, "the pod has no %s, the scheduler extender didn't assume it", gpushare.EnvAssignedFlag)
+	case assigned == gpushare.AssignedFlagExpired:
+		add(levelFail, "the assumed pod expired before the device plugin allocated it, recreate the pod")
+	case assigned == "false":
+		add(levelWarn, "the device plugin hasn't assigned the GPU yet, it does when kubelet allocates %d %s for the containers", memory, resourceName)
+	default:
+		add(levelWarn, "the assigned flag %q is unknown", assigned)
+	}
+
+	// the device plugin matches the assumed pods by the gpu-mem only, oldest first
+	if !isAssigned(pod) {
+		competitors := []string{}
+		for i := range nodeInfo.pods {
+			other := &nodeInfo.pods[i]
+			if other.UID == pod.UID || int(gpushare.GPUMemoryOfPod(other)) != memory {
+				continue
+			}
+			if assumed, _ := gpushare.AssumedPodStatus(other); assumed && !isAssigned(other) {
+				competitors = append(competitors, other.Namespace+"/"+other.Name)
+			}
+		}
+		sort.Strings(competitors)
+		if len(competitors) > 0 {
+			add(levelWarn, "%d other assumed pods on the node request the same %d %s: %s, the device plugin may give them each other's GPU since it matches the pods by the memory size and the assume time",
+				len(competitors), memory, resourceName, strings.Join(competitors, ", "))
+		}
+	}
+
+	if dev, ok := nodeInfo.devs[index]; ok && index >= 0 {
+		if dev.usedGPUMem > dev.totalGPUMem {
+			add(levelFail, "GPU %d is over-committed: %d of %d allocated", index, dev.usedGPUMem, dev.totalGPUMem)
+		} else {
+			add(levelOK, "GPU %d has %d of %d allocated", index, dev.usedGPUMem, dev.totalGPUMem)
+		}
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if waiting := status.State.Waiting; waiting != nil && waiting.Reason != "ContainerCreating" {
+			add(levelWarn, "container %s is waiting: %s %s", status.Name, waiting.Reason, waiting.Message)
+		}
+	}
+
+	return append(findings, explainEvents(events)...)
+}
+
+func explainEvents(events []v1.Event) []finding {
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+	})
+	findings := []finding{}
+	for _, event := range events {
+		if event.Type == v1.EventTypeWarning {
+			findings = append(findings, finding{level: levelWarn, message: fmt.Sprintf("event %s: %s", event.Reason, event.Message)})
+		}
+	}
+	if len(findings) > maxExplainEvents {
+		findings = findings[len(findings)-maxExplainEvents:]
+	}
+	return findings
+}
+
+func formatAllocation(allocation map[int]int) string {
+	indexes := []int{}
+	for index := range allocation {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	parts := []string{}
+	for _, index := range indexes {
+		parts = append(parts, fmt.Sprintf("GPU%d: %d", index, allocation[index]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// displayExplain shows the findings and the diagnosis, which is the first failure or warning
+func displayExplain(out io.Writer, pod *v1.Pod, findings []finding) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	node := pod.Spec.NodeName
+	if node == "" {
+		node = "<none>"
+	}
+	fmt.Fprintf(w, "Pod:\t%s/%s\n", pod.Namespace, pod.Name)
+	fmt.Fprintf(w, "Node:\t%s\n", node)
+	fmt.Fprintf(w, "Phase:\t%s\n", pod.Status.Phase)
+	fmt.Fprintf(w, "GPU Memory:\t%d %s\n\n", gpushare.GPUMemoryOfPod(pod), podMemoryUnit(pod.Spec.NodeName))
+	_ = w.Flush()
+
+	diagnosis := ""
+	for _, level := range []string{levelFail, levelWarn} {
+		for _, f := range findings {
+			if f.level == level && diagnosis == "" {
+				diagnosis = f.message
+			}
+		}
+	}
+	for _, f := range findings {
+		fmt.Fprintf(out, "%-6s %s\n", "["+f.level+"]", f.message)
+	}
+	if diagnosis == "" {
+		diagnosis = "no problem is found"
+	}
+	fmt.Fprintf(out, "\nDiagnosis: %s\n", diagnosis)
+}
+
+// explain gets the pod, its node and the pods on the node, and shows why the pod can't be placed or started.
+// The assigned pods are read from the assignment source as the other commands do.
+func explain(namespace, name, assignmentSource string, out io.Writer) error {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	var nodes []v1.Node
+	var pods []v1.Pod
+	if pod.Spec.NodeName == "" {
+		nodes, err = getAllSharedGPUNode()
+		if err == nil {
+			pods, err = getActivePodsInAllNodes()
+		}
+	} else {
+		nodes, err = getNodes(pod.Spec.NodeName)
+		if err == nil {
+			pods, err = getActivePodsByNode(pod.Spec.NodeName)
+		}
+	}
+	if err == nil {
+		err = prepareAssignments(assignmentSource)
+	}
+	if err != nil {
+		return err
+	}
+	nodeInfos, err := buildAllNodeInfos(pods, nodes)
+	if err != nil {
+		return err
+	}
+
+	events, err := clientset.CoreV1().Events(namespace).List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("involvedObject.uid", string(pod.UID)).String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	displayExplain(out, pod, explainPod(pod, nodeInfos, events.Items, time.Now()))
+	return nil
+}
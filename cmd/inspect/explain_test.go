@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var explainNow = time.Unix(1000, 0)
+
+// newAssumedPod returns a pod on node1 assumed a minute ago with the GPU index and the assigned flag
+func newAssumedPod(name string, gpuMemory int, index, assigned string) v1.Pod {
+	pod := newTestPod("default", name, "node1", gpuMemory, map[string]string{
+		gpushare.EnvResourceAssumeTime: fmt.Sprintf("%d", explainNow.Add(-time.Minute).UnixNano()),
+		envNVGPUID:                     index,
+		gpushare.EnvAssignedFlag:       assigned,
+	})
+	pod.Status.Phase = v1.PodPending
+	return pod
+}
+
+func explainTestPod(t *testing.T, pod v1.Pod, others ...v1.Pod) []finding {
+	memoryUnit = ""
+	assignments = nil
+	nodes := []v1.Node{newTestNode("node1", "10.0.0.1", 2, 32, map[string]string{memoryUnitKey: "GiB"})}
+	nodeInfos, err := buildAllNodeInfos(append(others, pod), nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return explainPod(&pod, nodeInfos, nil, explainNow)
+}
+
+func diagnosis(findings []finding) string {
+	var buf bytes.Buffer
+	displayExplain(&buf, &v1.Pod{}, findings)
+	out := buf.String()
+	return out[strings.Index(out, "Diagnosis: "):]
+}
+
+func TestExplainPod(t *testing.T) {
+	unscheduled := newTestPod("default", "unscheduled", "", 20, nil)
+	unscheduled.Status.Conditions = []v1.PodCondition{{Type: v1.PodScheduled, Status: v1.ConditionFalse, Message: "0/1 nodes are available"}}
+
+	tests := []struct {
+		name              string
+		pod               v1.Pod
+		others            []v1.Pod
+		expectedDiagnosis string
+	}{
+		{
+			name:              "no gpu-mem",
+			pod:               newTestPod("default", "cpu", "node1", 0, nil),
+			expectedDiagnosis: "doesn't request aliyun.com/gpu-mem",
+		},
+		{
+			name:              "assigned",
+			pod:               newAssumedPod("assigned", 4, "1", "true"),
+			expectedDiagnosis: "no problem is found",
+		},
+		{
+			name:              "missing GPU",
+			pod:               newAssumedPod("missing", 4, "2", "false"),
+			expectedDiagnosis: "GPU 2 doesn't exist on node node1 with 2 GPUs",
+		},
+		{
+			name:              "expired",
+			pod:               newAssumedPod("expired", 4, "0", "expired"),
+			expectedDiagnosis: "the assumed pod expired",
+		},
+		{
+			name:              "not assumed",
+			pod:               newTestPod("default", "bound", "node1", 4, nil),
+			expectedDiagnosis: "the gpushare scheduler extender didn't bind it",
+		},
+		{
+			name: "competing",
+			pod:  newAssumedPod("waiting", 4, "0", "false"),
+			others: []v1.Pod{
+				newAssumedPod("other", 4, "1", "false"),
+				newAssumedPod("larger", 8, "1", "false"),
+			},
+			expectedDiagnosis: "hasn't assigned the GPU yet",
+		},
+		{
+			name: "over-committed",
+			pod:  newAssumedPod("big", 10, "0", "true"),
+			others: []v1.Pod{
+				newAssumedPod("running", 10, "0", "true"),
+			},
+			expectedDiagnosis: "GPU 0 is over-committed: 20 of 16 allocated",
+		},
+		{
+			name:              "no GPU in the cluster",
+			pod:               unscheduled,
+			expectedDiagnosis: "no GPU in the cluster has 20 GiB free, the largest free slot is 16 on node1/GPU0",
+		},
+	}
+
+	for _, test := range tests {
+		findings := explainTestPod(t, test.pod, test.others...)
+		if d := diagnosis(findings); !strings.Contains(d, test.expectedDiagnosis) {
+			t.Errorf("%s: expected the diagnosis %q, got %q in %+v", test.name, test.expectedDiagnosis, d, findings)
+		}
+	}
+}
+
+func TestExplainCompetingPods(t *testing.T) {
+	findings := explainTestPod(t, newAssumedPod("waiting", 4, "0", "false"),
+		newAssumedPod("other", 4, "1", "false"),
+		newAssumedPod("larger", 8, "1", "false"),
+		newAssumedPod("assigned", 4, "1", "true"))
+
+	found := false
+	for _, f := range findings {
+		if strings.Contains(f.message, "1 other assumed pods on the node request the same 4") {
+			found = true
+			if !strings.Contains(f.message, "default/other") || strings.Contains(f.message, "larger") || strings.Contains(f.message, "default/assigned") {
+				t.Errorf("expected only default/other to compete, got %q", f.message)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected the competing pods, got %+v", findings)
+	}
+}
+
+// TestExplainAssignments explains the pods assigned by the device plugin with the ConfigMap store,
+// which keeps the assigned flag false
+func TestExplainAssignments(t *testing.T) {
+	memoryUnit = ""
+	pod := newAssumedPod("running", 4, "0", "false")
+	pod.UID = "running"
+	pod.Status.Phase = v1.PodRunning
+	other := newAssumedPod("other", 4, "1", "false")
+	other.UID = "other"
+	nodes := []v1.Node{newTestNode("node1", "10.0.0.1", 2, 32, map[string]string{memoryUnitKey: "GiB"})}
+	assignments = map[types.UID]gpushare.Assignment{
+		pod.UID:   {Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID, Index: 0, GPUMemory: 4},
+		other.UID: {Namespace: other.Namespace, Name: other.Name, UID: other.UID, Index: 1, GPUMemory: 4},
+	}
+	defer func() { assignments = nil }()
+	nodeInfos, err := buildAllNodeInfos([]v1.Pod{pod, other}, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings := explainPod(&pod, nodeInfos, nil, explainNow)
+	if d := diagnosis(findings); !strings.Contains(d, "no problem is found") {
+		t.Errorf("expected no problem of the assigned pod, got %q in %+v", d, findings)
+	}
+}
+
+func TestExplainEvents(t *testing.T) {
+	events := []v1.Event{}
+	for i := 0; i < maxExplainEvents+2; i++ {
+		events = append(events, v1.Event{
+			Type:          v1.EventTypeWarning,
+			Reason:        "GPUSharePreStartFailed",
+			Message:       fmt.Sprintf("attempt %d", i),
+			LastTimestamp: metav1.NewTime(explainNow.Add(time.Duration(-i) * time.Second)),
+		})
+	}
+	events = append(events, v1.Event{Type: v1.EventTypeNormal, Reason: "Scheduled"})
+
+	findings := explainEvents(events)
+	if len(findings) != maxExplainEvents {
+		t.Fatalf("expected the latest %d warnings, got %+v", maxExplainEvents, findings)
+	}
+	if findings[len(findings)-1].message != "event GPUSharePreStartFailed: attempt 0" {
+		t.Errorf("expected the latest event last, got %+v", findings)
+	}
+}
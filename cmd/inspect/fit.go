@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// the subcommand to find the GPUs which could host a pod
+const fitCommand = "fit"
+
+// gpuSlot is the free gpu-mem of a GPU, counted in the memory unit of the node
+type gpuSlot struct {
+	node  string
+	index int
+	free  int
+	total int
+	// pending is the pending gpu-mem of the node, it may be assigned to the GPU
+	pending int
+	// unitMiB is the MiB of the memory unit of the node
+	unitMiB int64
+}
+
+// clusterFree returns the free gpu-mem in memoryUnit to compare the GPUs of different nodes
+func (s *gpuSlot) clusterFree() int {
+	return inClusterUnit(s.free, s.unitMiB)
+}
+
+// fits returns true if the pod fits the GPU even if the pending gpu-mem of the node is
+// assigned to it, and maybe true if it fits only the free gpu-mem
+func (s *gpuSlot) fits(memory int) (fits, maybe bool) {
+	if s.free < memory {
+		return false, false
+	}
+	if s.free-s.pending < memory {
+		return false, true
+	}
+	return true, false
+}
+
+func (s *gpuSlot) String() string {
+	return fmt.Sprintf("%s/GPU%d", s.node, s.index)
+}
+
+// NodeFit is the free gpu-mem of a node for a pod of the memory. The pod is counted in the unit
+// of the node as the device plugin does, free, largest and unusable are in memoryUnit.
+type NodeFit struct {
+	name    string
+	address string
+	slots   []gpuSlot
+	free    int
+	pending int
+	unitMiB int64
+	// largest is the largest free gpu-mem of a GPU
+	largest int
+	// unusable is the free gpu-mem on the GPUs which can't host the pod
+	unusable int
+}
+
+// fragmentation is the percentage of the free gpu-mem which can't host the pod
+func (n *NodeFit) fragmentation() int {
+	return fragmentation(n.unusable, n.free)
+}
+
+func fragmentation(unusable, free int) int {
+	if free <= 0 {
+		return 0
+	}
+	return unusable * 100 / free
+}
+
+// ClusterFit is where a pod of the memory could be placed in the cluster
+type ClusterFit struct {
+	memory int
+	nodes  []*NodeFit
+	// binpack is the GPU with the least free gpu-mem which can host the pod,
+	// spread is the one with the most
+	binpack *gpuSlot
+	spread  *gpuSlot
+	// largest is the GPU with the most free gpu-mem, whether it can host the pod or not
+	largest  *gpuSlot
+	free     int
+	unusable int
+}
+
+// freeGPUMem returns the gpu-mem which is not allocated to the pods on the GPU
+func (d *DeviceInfo) freeGPUMem() int {
+	if free := d.totalGPUMem - d.usedGPUMem; free > 0 {
+		return free
+	}
+	return 0
+}
+
+// buildClusterFit computes the GPUs which could host a pod of the memory. The pending memory
+// is not assigned to any GPU yet, it's subtracted from every GPU of the node for the candidates,
+// the GPUs which fit the pod only without it are not candidates.
+func buildClusterFit(nodeInfos []*NodeInfo, memory int) *ClusterFit {
+	fit := &ClusterFit{memory: memory, nodes: []*NodeFit{}}
+	candidates := []*gpuSlot{}
+
+	for _, nodeInfo := range nodeInfos {
+		if nodeInfo.gpuTotalMemory <= 0 {
+			continue
+		}
+		node := &NodeFit{
+			name:    nodeInfo.node.Name,
+			address: nodeAddress(nodeInfo.node),
+			slots:   []gpuSlot{},
+			unitMiB: nodeInfo.unitMiB,
+		}
+		for idx, dev := range nodeInfo.devs {
+			if idx == -1 {
+				node.pending = dev.usedGPUMem
+				continue
+			}
+			node.slots = append(node.slots, gpuSlot{node: node.name, index: idx, free: dev.freeGPUMem(), total: dev.totalGPUMem, unitMiB: node.unitMiB})
+		}
+		sort.Slice(node.slots, func(i, j int) bool {
+			return node.slots[i].index < node.slots[j].index
+		})
+
+		for i := range node.slots {
+			slot := &node.slots[i]
+			slot.pending = node.pending
+			free := slot.clusterFree()
+			node.free += free
+			if free > node.largest {
+				node.largest = free
+			}
+			if slot.free < memory {
+				node.unusable += free
+			} else if fits, _ := slot.fits(memory); fits {
+				candidates = append(candidates, slot)
+			}
+			if fit.largest == nil || free > fit.largest.clusterFree() {
+				fit.largest = slot
+			}
+		}
+		fit.free += node.free
+		fit.unusable += node.unusable
+		fit.nodes = append(fit.nodes, node)
+	}
+	sort.Slice(fit.nodes, func(i, j int) bool {
+		return fit.nodes[i].name < fit.nodes[j].name
+	})
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].clusterFree() != candidates[j].clusterFree() {
+			return candidates[i].clusterFree() < candidates[j].clusterFree()
+		}
+		return candidates[i].String() < candidates[j].String()
+	})
+	if len(candidates) > 0 {
+		fit.binpack = candidates[0]
+		fit.spread = candidates[len(candidates)-1]
+	}
+
+	return fit
+}
+
+// displayFit shows the free gpu-mem of the GPUs, and where the pod would be placed by binpack and spread
+func displayFit(out io.Writer, fit *ClusterFit) {
+	maxGPUCount := 0
+	hasPending := false
+	for _, node := range fit.nodes {
+		if len(node.slots) > maxGPUCount {
+			maxGPUCount = len(node.slots)
+		}
+		if node.pending > 0 {
+			hasPending = true
+		}
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "NAME\tIPADDRESS\t")
+	for i := 0; i < maxGPUCount; i++ {
+		fmt.Fprintf(w, "GPU%d(Free/Total)\t", i)
+	}
+	if hasPending {
+		fmt.Fprint(w, "PENDING(Allocated)\t")
+	}
+	fmt.Fprintf(w, "FREE\tLARGEST SLOT\tFRAGMENTATION\tFITS %d %s\n", fit.memory, podMemoryUnit(""))
+
+	hasMaybe := false
+	for _, node := range fit.nodes {
+		fits := 0
+		fmt.Fprintf(w, "%s\t%s\t", node.name, node.address)
+		for i := 0; i < maxGPUCount; i++ {
+			if i >= len(node.slots) {
+				fmt.Fprint(w, "-\t")
+				continue
+			}
+			slot := node.slots[i]
+			mark := ""
+			if ok, maybe := slot.fits(fit.memory); ok {
+				mark = "*"
+				fits++
+			} else if maybe {
+				mark = "?"
+				hasMaybe = true
+			}
+			fmt.Fprintf(w, "%d/%d%s\t", slot.clusterFree(), inClusterUnit(slot.total, slot.unitMiB), mark)
+		}
+		if hasPending {
+			fmt.Fprintf(w, "%d\t", inClusterUnit(node.pending, node.unitMiB))
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d%%\t%d GPUs\n", node.free, node.largest, node.fragmentation(), fits)
+	}
+	if hasMaybe {
+		fmt.Fprint(w, "? fits only if the pending gpu-mem of the node is not assigned to the GPU\n")
+	}
+	fmt.Fprint(w, "\n")
+
+	if fit.binpack == nil {
+		fmt.Fprintf(w, "No GPU can host a pod of %d %s\n", fit.memory, podMemoryUnit(""))
+	} else {
+		fmt.Fprintf(w, "Binpack:\t%s (free %d)\n", fit.binpack, fit.binpack.clusterFree())
+		fmt.Fprintf(w, "Spread:\t%s (free %d)\n", fit.spread, fit.spread.clusterFree())
+	}
+	if fit.largest != nil {
+		fmt.Fprintf(w, "Largest schedulable slot:\t%d on %s\n", fit.largest.clusterFree(), fit.largest)
+	}
+	fmt.Fprintf(w, "Fragmentation In Cluster:\t%d%% (%d of %d free can't host a pod of %d)\n",
+		fragmentation(fit.unusable, fit.free), fit.unusable, fit.free, fit.memory)
+	_ = w.Flush()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildClusterFit(t *testing.T) {
+	// node1 has 10 and 13 free on its GPUs and 2 pending, node2 has 15 free
+	tests := []struct {
+		memory                    int
+		expectedBinpack           string
+		expectedSpread            string
+		expectedFragmentation     int
+		expectedNodeUnusable      []int
+		expectedNodeFragmentation []int
+	}{
+		{memory: 6, expectedBinpack: "node1/GPU0", expectedSpread: "node2/GPU0", expectedNodeUnusable: []int{0, 0}, expectedNodeFragmentation: []int{0, 0}},
+		{memory: 11, expectedBinpack: "node1/GPU1", expectedSpread: "node2/GPU0", expectedFragmentation: 26, expectedNodeUnusable: []int{10, 0}, expectedNodeFragmentation: []int{43, 0}},
+		// node1/GPU1 may be taken by the pending gpu-mem
+		{memory: 12, expectedBinpack: "node2/GPU0", expectedSpread: "node2/GPU0", expectedFragmentation: 26, expectedNodeUnusable: []int{10, 0}, expectedNodeFragmentation: []int{43, 0}},
+		{memory: 16, expectedFragmentation: 100, expectedNodeUnusable: []int{23, 15}, expectedNodeFragmentation: []int{100, 100}},
+	}
+
+	for _, test := range tests {
+		fit := buildClusterFit(newTestNodeInfos(t), test.memory)
+		binpack, spread := "", ""
+		if fit.binpack != nil {
+			binpack, spread = fit.binpack.String(), fit.spread.String()
+		}
+		if binpack != test.expectedBinpack || spread != test.expectedSpread {
+			t.Errorf("mem %d: expected binpack %q and spread %q, got %q and %q", test.memory, test.expectedBinpack, test.expectedSpread, binpack, spread)
+		}
+		if fit.largest == nil || fit.largest.String() != "node2/GPU0" || fit.largest.free != 15 {
+			t.Errorf("mem %d: expected the largest slot 15 on node2/GPU0, got %v", test.memory, fit.largest)
+		}
+		if f := fragmentation(fit.unusable, fit.free); f != test.expectedFragmentation || fit.free != 38 {
+			t.Errorf("mem %d: expected fragmentation %d%% of 38, got %d%% of %d", test.memory, test.expectedFragmentation, f, fit.free)
+		}
+		for i, node := range fit.nodes {
+			if node.unusable != test.expectedNodeUnusable[i] || node.fragmentation() != test.expectedNodeFragmentation[i] {
+				t.Errorf("mem %d: expected %s to have %d unusable (%d%%), got %d (%d%%)", test.memory, node.name,
+					test.expectedNodeUnusable[i], test.expectedNodeFragmentation[i], node.unusable, node.fragmentation())
+			}
+		}
+	}
+}
+
+func TestDisplayFit(t *testing.T) {
+	var buf bytes.Buffer
+	displayFit(&buf, buildClusterFit(newTestNodeInfos(t), 12))
+
+	golden := filepath.Join("testdata", "fit.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("the output doesn't match %s, run go test -update if the change is expected\ngot:\n%s", golden, buf.String())
+	}
+}
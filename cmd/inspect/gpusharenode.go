@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	gpusharev1alpha1 "github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	podSourceAPIServer    = "pods"
+	podSourceGPUShareNode = "crd"
+)
+
+var gpuShareNodePath = "/apis/" + gpusharev1alpha1.GroupName + "/" + gpusharev1alpha1.Version + "/" + gpusharev1alpha1.Resource
+
+// getPodsFromGPUShareNodes gets the pods allocated on the GPUs from the GPUShareNodes reported
+// by the device plugins, instead of listing all the pods
+func getPodsFromGPUShareNodes(nodeName string) ([]v1.Pod, error) {
+	restClient := clientset.CoreV1().RESTClient()
+	nodes := []gpusharev1alpha1.GPUShareNode{}
+	if nodeName != "" {
+		data, err := restClient.Get().AbsPath(gpuShareNodePath, nodeName).DoRaw()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get GPUShareNode %s due to %v", nodeName, err)
+		}
+		node := gpusharev1alpha1.GPUShareNode{}
+		if err = json.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	} else {
+		data, err := restClient.Get().AbsPath(gpuShareNodePath).DoRaw()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list GPUShareNodes due to %v", err)
+		}
+		list := gpusharev1alpha1.GPUShareNodeList{}
+		if err = json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		nodes = list.Items
+	}
+
+	pods := []v1.Pod{}
+	for _, node := range nodes {
+		pods = append(pods, podsInGPUShareNode(node)...)
+	}
+
+	return pods, nil
+}
+
+// podsInGPUShareNode builds the pods with the GPU memory and the GPU index which are
+// needed by buildAllNodeInfos, the pending pods have no GPU index
+func podsInGPUShareNode(node gpusharev1alpha1.GPUShareNode) []v1.Pod {
+	pods := []v1.Pod{}
+	for _, gpu := range node.Status.GPUs {
+		for _, p := range gpu.Pods {
+			pod := podOfAllocation(node.Name, p, v1.PodRunning)
+			pod.Annotations = map[string]string{envNVGPUID: fmt.Sprintf("%d", gpu.Index)}
+			pods = append(pods, pod)
+		}
+	}
+	for _, p := range node.Status.PendingPods {
+		pods = append(pods, podOfAllocation(node.Name, p, v1.PodPending))
+	}
+
+	return pods
+}
+
+func podOfAllocation(nodeName string, p gpusharev1alpha1.PodAllocation, phase v1.PodPhase) v1.Pod {
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.Name,
+			Namespace: p.Namespace,
+			UID:       p.UID,
+		},
+		Spec: v1.PodSpec{
+			NodeName: nodeName,
+			Containers: []v1.Container{{
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{
+						resourceName: *resource.NewQuantity(int64(p.GPUMemory), resource.DecimalSI),
+					},
+				},
+			}},
+		},
+		Status: v1.PodStatus{Phase: phase},
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	gpusharev1alpha1 "github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodsInGPUShareNode(t *testing.T) {
+	node := gpusharev1alpha1.GPUShareNode{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status: gpusharev1alpha1.GPUShareNodeStatus{
+			GPUs: []gpusharev1alpha1.GPUStatus{
+				{Index: 0},
+				{Index: 1, Pods: []gpusharev1alpha1.PodAllocation{{Namespace: "default", Name: "running", GPUMemory: 4}}},
+			},
+			PendingPods: []gpusharev1alpha1.PodAllocation{{Namespace: "default", Name: "pending", GPUMemory: 2}},
+		},
+	}
+
+	pods := podsInGPUShareNode(node)
+	if len(pods) != 2 {
+		t.Fatalf("expected 2 pods, got %v", pods)
+	}
+	running, pending := pods[0], pods[1]
+	if running.Name != "running" || running.Spec.NodeName != "node1" || running.Annotations[envNVGPUID] != "1" ||
+		running.Status.Phase != v1.PodRunning || gpuMemoryInPod(running) != 4 {
+		t.Errorf("expected pod running on gpu 1 with 4 gpu-mem, got %+v", running)
+	}
+	if pending.Name != "pending" || pending.Status.Phase != v1.PodPending || gpuMemoryInPod(pending) != 2 {
+		t.Errorf("expected pod pending with 2 gpu-mem, got %+v", pending)
+	}
+	if _, found := pending.Annotations[envNVGPUID]; found {
+		t.Errorf("expected pod pending without gpu index, got %v", pending.Annotations)
+	}
+
+	info := &NodeInfo{pods: pods, devs: map[int]*DeviceInfo{}}
+	info.buildDeviceInfo()
+	if !info.hasPendingGPUMemory() || info.devs[-1].usedGPUMem != 2 {
+		t.Errorf("expected 2 pending gpu-mem, got %v", info.devs)
+	}
+}
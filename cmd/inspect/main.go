@@ -4,7 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
+	log "github.com/golang/glog"
+	"github.com/spf13/pflag"
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -14,6 +17,8 @@ const (
 	gpuCountKey          = "aliyun.accelerator/nvidia_count"
 	cardNameKey          = "aliyun.accelerator/nvidia_name"
 	gpuMemKey            = "aliyun.accelerator/nvidia_mem"
+	memoryUnitKey        = "aliyun.com/gpu-mem-unit"
+	reservedMemoryKey    = "aliyun.com/gpu-mem-reserved"
 	pluginComponentKey   = "component"
 	pluginComponentValue = "gpushare-device-plugin"
 
@@ -23,38 +28,98 @@ const (
 	gpushareAllocationFlag = "scheduler.framework.gpushare.allocation"
 )
 
-func init() {
-	kubeInit()
-	// checkpointInit()
-}
-
 func main() {
 	var nodeName string
 	// nodeName := flag.String("nodeName", "", "nodeName")
-	details := flag.Bool("d", false, "details")
-	flag.Parse()
+	details := pflag.BoolP("details", "d", false, "details")
+	assignmentSource := pflag.String("assignment-source", assignmentSourceAnnotation, "Where to read the GPU assigned to the pods: 'annotation' or 'configmap' written by the device plugin with --assignment-store=configmap")
+	output := pflag.StringP("output", "o", "", "Output format: json, yaml, wide or csv, the text tables are printed if it's not set")
+	podSource := pflag.String("source", podSourceAPIServer, "Where to read the pods on the GPUs: 'pods' lists all the pods, 'crd' reads the GPUShareNodes reported by the device plugins with --gpushare-node")
+	watch := pflag.BoolP("watch", "w", false, "Watch the nodes and pods, and redraw the table with the changes since the last refresh")
+	fitMemory := pflag.Int("mem", 0, "The gpu-mem of the pod to fit, in the memory unit of the nodes, it's required by the fit command")
+	selector := pflag.StringP("selector", "l", "", "Selector (label query) to filter the pods shown, the GPU memory is still counted for all the pods")
+	bindKubeFlags(pflag.CommandLine)
+	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
+	pflag.Parse()
 
-	args := flag.Args()
+	if *podSource != podSourceAPIServer && *podSource != podSourceGPUShareNode {
+		fmt.Printf("Unknown source %q\n", *podSource)
+		os.Exit(1)
+	}
+
+	if !isValidOutput(*output) {
+		fmt.Printf("Unknown output %q\n", *output)
+		os.Exit(1)
+	}
+
+	if *watch && (*podSource != podSourceAPIServer || (*output != "" && *output != outputWide)) {
+		fmt.Println("--watch only supports the pods source and the table outputs")
+		os.Exit(1)
+	}
+
+	if err := kubeInit(); err != nil {
+		fmt.Printf("Failed due to %v", err)
+		os.Exit(1)
+	}
+	filter, err := newPodFilter(*selector)
+	if err != nil {
+		fmt.Printf("Failed due to %v", err)
+		os.Exit(1)
+	}
+
+	args := pflag.Args()
+	command := ""
+	if len(args) > 0 && (args[0] == quotaCommand || args[0] == fitCommand || args[0] == explainCommand) {
+		command, args = args[0], args[1:]
+	}
+	if command == explainCommand {
+		if err = runExplain(args, *assignmentSource); err != nil {
+			fmt.Printf("Failed due to %v", err)
+			os.Exit(1)
+		}
+		return
+	}
 	if len(args) > 0 {
 		nodeName = args[0]
 	}
 
+	display, err := newDisplay(command, *details, *output, *fitMemory)
+	if err != nil {
+		fmt.Printf("Failed due to %v", err)
+		os.Exit(1)
+	}
+
+	if *watch {
+		if err = watchNodes(nodeName, *assignmentSource, filter, display); err != nil {
+			fmt.Printf("Failed due to %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var pods []v1.Pod
 	var nodes []v1.Node
-	var err error
 
 	if nodeName == "" {
 		nodes, err = getAllSharedGPUNode()
-		if err == nil {
+		if err == nil && *podSource == podSourceGPUShareNode {
+			pods, err = getPodsFromGPUShareNodes("")
+		} else if err == nil {
 			pods, err = getActivePodsInAllNodes()
 		}
 	} else {
 		nodes, err = getNodes(nodeName)
-		if err == nil {
+		if err == nil && *podSource == podSourceGPUShareNode {
+			pods, err = getPodsFromGPUShareNodes(nodeName)
+		} else if err == nil {
 			pods, err = getActivePodsByNode(nodeName)
 		}
 	}
 
+	if err == nil {
+		err = prepareAssignments(*assignmentSource)
+	}
+
 	if err != nil {
 		fmt.Printf("Failed due to %v", err)
 		os.Exit(1)
@@ -65,10 +130,64 @@ func main() {
 		fmt.Printf("Failed due to %v", err)
 		os.Exit(1)
 	}
-	if *details {
-		displayDetails(nodeInfos)
-	} else {
-		displaySummary(nodeInfos)
+	filterShownPods(nodeInfos, filter)
+	if err = display(nodeInfos); err != nil {
+		fmt.Printf("Failed due to %v", err)
+		os.Exit(1)
 	}
+}
 
+// newDisplay returns the func to show the node infos for the command and the flags
+func newDisplay(command string, details bool, output string, fitMemory int) (func([]*NodeInfo) error, error) {
+	switch command {
+	case quotaCommand:
+		quotas, err := getNamespaceQuotas()
+		if err != nil {
+			log.Warningf("Failed to get the quotas of the namespaces due to %v", err)
+		}
+		return func(nodeInfos []*NodeInfo) error {
+			displayQuota(os.Stdout, buildNamespaceUsages(nodeInfos, quotas), details)
+			return nil
+		}, nil
+	case fitCommand:
+		if fitMemory <= 0 {
+			return nil, fmt.Errorf("--mem should be set to the gpu-mem of the pod for the fit command")
+		}
+		return func(nodeInfos []*NodeInfo) error {
+			displayFit(os.Stdout, buildClusterFit(nodeInfos, fitMemory))
+			return nil
+		}, nil
+	}
+
+	if output != "" {
+		return func(nodeInfos []*NodeInfo) error {
+			return displayOutput(os.Stdout, nodeInfos, output)
+		}, nil
+	}
+	if details {
+		return func(nodeInfos []*NodeInfo) error {
+			displayDetails(nodeInfos)
+			return nil
+		}, nil
+	}
+	return func(nodeInfos []*NodeInfo) error {
+		displaySummary(nodeInfos)
+		return nil
+	}, nil
+}
+
+// runExplain explains the pod in the args, which is <name> in the namespace of --namespace or <namespace>/<name>
+func runExplain(args []string, assignmentSource string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("explain needs the name of the pod")
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return err
+	}
+	name := args[0]
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+		namespace, name = parts[0], parts[1]
+	}
+	return explain(namespace, name, assignmentSource, os.Stdout)
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
 	log "github.com/golang/glog"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -39,15 +40,22 @@ type NodeInfo struct {
 	devs           map[int]*DeviceInfo
 	gpuCount       int
 	gpuTotalMemory int
-	pluginPod      v1.Pod
+	// gpuReservedMemory is the memory of each GPU reserved for the driver and system daemons
+	gpuReservedMemory int
+	pluginPod         v1.Pod
+	// usage is the GPU memory used by the processes published by the device plugin, it's nil if there is none
+	usage *gpushare.MemoryUsage
+	// memoryUnit is the unit the gpu-mem of the node is counted in, unitMiB is its MiB
+	memoryUnit string
+	unitMiB    int64
 }
 
 // The key function
 func buildAllNodeInfos(allPods []v1.Pod, nodes []v1.Node) ([]*NodeInfo, error) {
 	nodeInfos := buildNodeInfoWithPods(allPods, nodes)
+	setClusterUnit(nodeInfos)
 	for _, info := range nodeInfos {
 		if info.gpuTotalMemory > 0 {
-			setUnit(info.gpuTotalMemory, info.gpuCount)
 			err := info.buildDeviceInfo()
 			if err != nil {
 				log.Warningf("Failed due to %v", err)
@@ -82,6 +90,31 @@ func getTotalGPUMemory(node v1.Node) int {
 	return int(val.Value())
 }
 
+func getReservedGPUMemory(node v1.Node) int {
+	val, ok := node.Annotations[reservedMemoryKey]
+	if !ok {
+		return 0
+	}
+
+	reserved, err := strconv.Atoi(val)
+	if err != nil {
+		log.Warningf("Failed to parse reserved memory %s of node %s due to %v", val, node.Name, err)
+		return 0
+	}
+
+	return reserved
+}
+
+func getMemoryUsage(node v1.Node) *gpushare.MemoryUsage {
+	usage, err := gpushare.MemoryUsageOfNode(&node)
+	if err != nil {
+		log.Warningf("Failed to parse the GPU memory usage of node %s due to %v", node.Name, err)
+		return nil
+	}
+
+	return usage
+}
+
 func getGPUCountInNode(node v1.Node) int {
 	val, ok := node.Status.Allocatable[countName]
 
@@ -106,6 +139,9 @@ func buildNodeInfoWithPods(pods []v1.Pod, nodes []v1.Node) []*NodeInfo {
 			info.pods = []v1.Pod{}
 			info.gpuCount = getGPUCountInNode(node)
 			info.gpuTotalMemory = getTotalGPUMemory(node)
+			info.gpuReservedMemory = getReservedGPUMemory(node)
+			info.usage = getMemoryUsage(node)
+			info.memoryUnit, info.unitMiB = getMemoryUnit(node, info.gpuTotalMemory, info.gpuCount)
 			info.devs = map[int]*DeviceInfo{}
 
 			for i := 0; i < info.gpuCount; i++ {
@@ -173,6 +209,10 @@ func (n *NodeInfo) getDeivceInfo(pod v1.Pod) map[int]int {
 	if len(allocation) != 0 {
 		return allocation
 	}
+	if a, ok := assignments[pod.UID]; ok {
+		allocation[int(a.Index)] = gpuMemoryInPod(pod)
+		return allocation
+	}
 	if len(pod.ObjectMeta.Annotations) > 0 {
 		value, found := pod.ObjectMeta.Annotations[envNVGPUID]
 		if found {
@@ -186,7 +226,7 @@ func (n *NodeInfo) getDeivceInfo(pod v1.Pod) map[int]int {
 				id = -1
 			}
 		} else {
-			log.Warningf("Failed to get dev id %s for pod %s in ns %s",
+			log.Warningf("Failed to get dev id for pod %s in ns %s",
 				pod.Name,
 				pod.Namespace)
 		}
@@ -195,6 +235,16 @@ func (n *NodeInfo) getDeivceInfo(pod v1.Pod) map[int]int {
 	return allocation
 }
 
+func hasReservedGPUMemory(nodeInfos []*NodeInfo) bool {
+	for _, info := range nodeInfos {
+		if info.gpuReservedMemory > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
 func hasPendingGPUMemory(nodeInfos []*NodeInfo) (found bool) {
 	for _, info := range nodeInfos {
 		if info.hasPendingGPUMemory() {
@@ -221,26 +271,94 @@ func isGPUSharingNode(node v1.Node) bool {
 }
 
 var (
+	// memoryUnit is the unit of the memory summed across the nodes, it's MiB if the nodes
+	// count gpu-mem in different units
 	memoryUnit = ""
+	// clusterUnitMiB is the MiB of memoryUnit
+	clusterUnitMiB int64
+	// nodeMemoryUnits is the memory unit of each node, mixedUnits is true if they're different
+	nodeMemoryUnits = map[string]string{}
+	mixedUnits      bool
 )
 
-func setUnit(gpuMemory, gpuCount int) {
-	if memoryUnit != "" {
-		return
+// getMemoryUnit returns the memory unit published by the device plugin on the node, the unit of
+// the old device plugins is guessed by the gpu-mem of a GPU
+func getMemoryUnit(node v1.Node, gpuMemory, gpuCount int) (string, int64) {
+	perGPU := 0
+	if gpuCount > 0 {
+		perGPU = gpuMemory / gpuCount
+	}
+	unitMiB, err := gpushare.MemoryUnitOfNode(&node, int64(perGPU))
+	if err != nil {
+		log.Warningf("Failed to parse the memory unit of node %s due to %v", node.Name, err)
+		return "", 0
+	}
+	if unit := node.Annotations[memoryUnitKey]; unit != "" {
+		return unit, unitMiB
+	}
+	if unitMiB == 1 {
+		return "MiB", unitMiB
 	}
+	return "GiB", unitMiB
+}
 
-	if gpuCount == 0 {
-		return
+// setClusterUnit sets memoryUnit to the unit shared by the GPU nodes, or MiB if they use different units
+func setClusterUnit(nodeInfos []*NodeInfo) {
+	memoryUnit, clusterUnitMiB, mixedUnits = "", 0, false
+	nodeMemoryUnits = map[string]string{}
+	for _, info := range nodeInfos {
+		if info.gpuTotalMemory <= 0 || info.unitMiB == 0 {
+			continue
+		}
+		nodeMemoryUnits[info.node.Name] = info.memoryUnit
+		if memoryUnit == "" {
+			memoryUnit, clusterUnitMiB = info.memoryUnit, info.unitMiB
+		} else if info.unitMiB != clusterUnitMiB || mixedUnits {
+			memoryUnit, clusterUnitMiB, mixedUnits = "MiB", 1, true
+		}
 	}
+}
 
-	gpuMemoryByDev := gpuMemory / gpuCount
+// podMemoryUnit returns the unit of the gpu-mem of a pod on the node, a pod which is not
+// scheduled may be counted in the unit of any node
+func podMemoryUnit(nodeName string) string {
+	if unit, ok := nodeMemoryUnits[nodeName]; ok {
+		return unit
+	}
+	if mixedUnits {
+		return "in the memory unit of the node"
+	}
+	return memoryUnit
+}
+
+// inClusterUnit converts the gpu-mem of the node into memoryUnit to be summed across the nodes
+func (n *NodeInfo) inClusterUnit(mem int) int {
+	return inClusterUnit(mem, n.unitMiB)
+}
 
-	if gpuMemoryByDev > 100 {
-		memoryUnit = "MiB"
-	} else {
-		memoryUnit = "GiB"
+func inClusterUnit(mem int, unitMiB int64) int {
+	if unitMiB == 0 || clusterUnitMiB == 0 || unitMiB == clusterUnitMiB {
+		return mem
 	}
+	return int(int64(mem) * unitMiB / clusterUnitMiB)
 }
+
+// inMiB converts the gpu-mem of the node into MiB, it's kept if the unit of the node is unknown
+func (n *NodeInfo) inMiB(mem int) int {
+	if n.unitMiB == 0 {
+		return mem
+	}
+	return int(int64(mem) * n.unitMiB)
+}
+
+// usedInClusterUnit converts the memory used on the node into memoryUnit
+func (n *NodeInfo) usedInClusterUnit(used float64) float64 {
+	if n.unitMiB == 0 || clusterUnitMiB == 0 {
+		return used
+	}
+	return used * float64(n.unitMiB) / float64(clusterUnitMiB)
+}
+
 func GetAllocation(pod *v1.Pod) map[int]int {
 	podGPUMems := map[int]int{}
 	allocationString := ""
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newTestNode(name, address string, gpuCount, gpuMemory int, annotations map[string]string) v1.Node {
+	return v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{cardNameKey: "Tesla-V100-SXM2-16GB"},
+			Annotations: annotations,
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: address}},
+			Allocatable: v1.ResourceList{
+				resourceName: *resource.NewQuantity(int64(gpuMemory), resource.DecimalSI),
+				countName:    *resource.NewQuantity(int64(gpuCount), resource.DecimalSI),
+			},
+		},
+	}
+}
+
+func newTestPod(namespace, name, nodeName string, gpuMemory int, annotations map[string]string) v1.Pod {
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			UID:         types.UID(namespace + "-" + name),
+			Annotations: annotations,
+		},
+		Spec: v1.PodSpec{
+			NodeName: nodeName,
+			Containers: []v1.Container{{
+				Name: "main",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{resourceName: *resource.NewQuantity(int64(gpuMemory), resource.DecimalSI)},
+				},
+			}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+// testMemoryUsage is the GPU memory used by the pods on node1 published by the device plugin
+const testMemoryUsage = `{"memoryUnit":"GiB","gpus":[` +
+	`{"index":0,"used":5.62,"pods":{"default-single":3.5,"team-a-multi":1.25}},` +
+	`{"index":1,"used":2.1,"pods":{"team-a-multi":2,"default-pending":0.5}}]}`
+
+// newTestNodeInfos returns 2 nodes, the first one has the pods on one GPU, on two GPUs
+// and not assigned yet and publishes the used memory, the second one has no pods
+func newTestNodeInfos(t *testing.T) []*NodeInfo {
+	memoryUnit = ""
+	assignments = nil
+
+	nodes := []v1.Node{
+		newTestNode("node2", "10.0.0.2", 1, 15, map[string]string{memoryUnitKey: "GiB", reservedMemoryKey: "1"}),
+		newTestNode("node1", "10.0.0.1", 2, 32, map[string]string{memoryUnitKey: "GiB", gpushare.NodeAnnotationMemoryUsage: testMemoryUsage}),
+	}
+	pods := []v1.Pod{
+		newTestPod("default", "single", "node1", 4, map[string]string{envNVGPUID: "0"}),
+		newTestPod("team-a", "multi", "node1", 5, map[string]string{gpushareAllocationFlag: `{"0":{"0":2,"1":3}}`}),
+		newTestPod("default", "pending", "node1", 2, nil),
+		newTestPod("default", "cpu", "node1", 0, nil),
+	}
+
+	nodeInfos, err := buildAllNodeInfos(pods, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nodeInfos
+}
+
+func TestMixedMemoryUnits(t *testing.T) {
+	assignments = nil
+	// node1 counts gpu-mem in GiB, node2 in MiB
+	nodes := []v1.Node{
+		newTestNode("node1", "10.0.0.1", 1, 16, map[string]string{memoryUnitKey: "GiB"}),
+		newTestNode("node2", "10.0.0.2", 1, 8192, map[string]string{memoryUnitKey: "MiB"}),
+	}
+	pods := []v1.Pod{
+		newTestPod("default", "gib", "node1", 4, map[string]string{envNVGPUID: "0"}),
+		newTestPod("default", "mib", "node2", 2048, map[string]string{envNVGPUID: "0"}),
+	}
+	nodeInfos, err := buildAllNodeInfos(pods, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memoryUnit != "MiB" || !mixedUnits {
+		t.Errorf("expected the cluster to be counted in MiB, got %q", memoryUnit)
+	}
+	if unit := podMemoryUnit("node1"); unit != "GiB" {
+		t.Errorf("expected the pods on node1 to be counted in GiB, got %q", unit)
+	}
+
+	out := buildClusterOutput(nodeInfos)
+	if out.Total.TotalMemory != 16*1024+8192 || out.Total.UsedMemory != 4*1024+2048 || out.MemoryUnit != "MiB" {
+		t.Errorf("expected the total in MiB, got %+v", out.Total)
+	}
+	if out.Nodes[0].MemoryUnit != "GiB" || out.Nodes[0].TotalMemory != 16 || out.Nodes[1].MemoryUnit != "MiB" {
+		t.Errorf("expected the nodes to be counted in their units, got %+v", out.Nodes)
+	}
+
+	// 4 fits node1 in GiB and node2 in MiB, node1 has more free memory
+	fit := buildClusterFit(nodeInfos, 4)
+	if fit.binpack.String() != "node2/GPU0" || fit.spread.String() != "node1/GPU0" || fit.largest.clusterFree() != 12*1024 {
+		t.Errorf("expected to binpack on node2 and spread on node1, got %s and %s", fit.binpack, fit.spread)
+	}
+
+	// the quota of 3 is 3GiB on node1 and 3MiB on node2
+	usages := buildNamespaceUsages(nodeInfos, map[string]gpushare.MemoryQuota{"default": {Limit: 3}})
+	if len(usages) != 1 || usages[0].allocated != 4*1024+2048 || !usages[0].exceeded() {
+		t.Errorf("expected default to allocate 6144MiB and exceed the quota, got %+v", usages)
+	}
+	usages = buildNamespaceUsages(nodeInfos, map[string]gpushare.MemoryQuota{"default": {Limit: 4096}})
+	if usages[0].exceeded() {
+		t.Errorf("expected default to be within the quota of 4096 in the units of the nodes, got %+v", usages[0])
+	}
+	// 3GiB is the same on every node
+	usages = buildNamespaceUsages(nodeInfos, map[string]gpushare.MemoryQuota{"default": {MiB: 3072}})
+	if !usages[0].exceeded() || usages[0].limits["node1/GPU0"] != 3072 || usages[0].limits["node2/GPU0"] != 3072 {
+		t.Errorf("expected default to exceed the quota of 3GiB on node1, got %+v", usages[0])
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/ghodss/yaml"
+	v1 "k8s.io/api/core/v1"
+)
+
+// The output formats of -o, the text tables are printed if it's not set
+const (
+	outputJSON = "json"
+	outputYAML = "yaml"
+	outputWide = "wide"
+	outputCSV  = "csv"
+)
+
+// outputVersion is the version of the schema of json, yaml and csv, fields are only
+// added in the same version
+const outputVersion = "v1"
+
+// ClusterOutput is the GPU memory of the cluster. The memory of a node is counted in the MemoryUnit
+// of the node since the nodes may use different units, MemoryUnit of the cluster is the unit of
+// Total, which is always MiB.
+type ClusterOutput struct {
+	Version    string       `json:"version"`
+	MemoryUnit string       `json:"memoryUnit"`
+	Nodes      []NodeOutput `json:"nodes"`
+	Total      TotalOutput  `json:"total"`
+}
+
+// totalMemoryUnit is the unit of the memory of all the nodes
+const totalMemoryUnit = "MiB"
+
+// TotalOutput is the GPU memory of all the nodes in MiB
+type TotalOutput struct {
+	TotalMemory   int `json:"totalMemory"`
+	UsedMemory    int `json:"usedMemory"`
+	PendingMemory int `json:"pendingMemory"`
+}
+
+// NodeOutput is the GPU memory of a node counted in MemoryUnit, UsedMemory includes PendingMemory.
+// UsedMemory is the allocated gpu-mem, ActualUsedMemory is the memory used by the processes
+// published by the device plugin, it's not set if the device plugin doesn't publish it.
+type NodeOutput struct {
+	Name           string `json:"name"`
+	Address        string `json:"address"`
+	Model          string `json:"model,omitempty"`
+	MemoryUnit     string `json:"memoryUnit"`
+	GPUCount       int    `json:"gpuCount"`
+	TotalMemory    int    `json:"totalMemory"`
+	UsedMemory     int    `json:"usedMemory"`
+	PendingMemory  int    `json:"pendingMemory"`
+	ReservedMemory int    `json:"reservedMemory"`
+	// ActualUsedMemory is not a whole number of the memory unit
+	ActualUsedMemory *float64    `json:"actualUsedMemory,omitempty"`
+	GPUs             []GPUOutput `json:"gpus"`
+	// PendingPods are the pods on the node which are not assigned to any GPU yet
+	PendingPods []PodOutput `json:"pendingPods"`
+}
+
+// GPUOutput is the GPU memory of a GPU
+type GPUOutput struct {
+	Index            int         `json:"index"`
+	TotalMemory      int         `json:"totalMemory"`
+	UsedMemory       int         `json:"usedMemory"`
+	ActualUsedMemory *float64    `json:"actualUsedMemory,omitempty"`
+	Pods             []PodOutput `json:"pods"`
+}
+
+// PodOutput is the GPU memory allocated to a pod on a GPU
+type PodOutput struct {
+	Namespace        string   `json:"namespace"`
+	Name             string   `json:"name"`
+	UsedMemory       int      `json:"usedMemory"`
+	ActualUsedMemory *float64 `json:"actualUsedMemory,omitempty"`
+}
+
+func isValidOutput(output string) bool {
+	switch output {
+	case "", outputJSON, outputYAML, outputWide, outputCSV:
+		return true
+	}
+	return false
+}
+
+// buildClusterOutput converts the node infos into the output, the nodes, GPUs and pods are sorted
+func buildClusterOutput(nodeInfos []*NodeInfo) ClusterOutput {
+	out := ClusterOutput{
+		Version:    outputVersion,
+		MemoryUnit: totalMemoryUnit,
+		Nodes:      []NodeOutput{},
+	}
+
+	for _, nodeInfo := range nodeInfos {
+		if nodeInfo.gpuTotalMemory <= 0 {
+			continue
+		}
+		node := NodeOutput{
+			Name:           nodeInfo.node.Name,
+			Address:        nodeAddress(nodeInfo.node),
+			Model:          nodeInfo.node.Labels[cardNameKey],
+			MemoryUnit:     nodeInfo.memoryUnit,
+			GPUCount:       nodeInfo.gpuCount,
+			TotalMemory:    nodeInfo.gpuTotalMemory,
+			ReservedMemory: nodeInfo.gpuReservedMemory * nodeInfo.gpuCount,
+			GPUs:           []GPUOutput{},
+			PendingPods:    []PodOutput{},
+		}
+
+		if used, ok := nodeInfo.usedOfNode(); ok {
+			node.ActualUsedMemory = &used
+		}
+
+		for idx, dev := range nodeInfo.devs {
+			pods := []PodOutput{}
+			for _, pod := range dev.pods {
+				podOutput := PodOutput{
+					Namespace:  pod.Namespace,
+					Name:       pod.Name,
+					UsedMemory: nodeInfo.getDeivceInfo(pod)[idx],
+				}
+				if nodeInfo.usage != nil {
+					// the pending pods are not assigned yet, their processes may be on any GPU
+					used := nodeInfo.usage.UsedOfPod(pod.UID, idx)
+					podOutput.ActualUsedMemory = &used
+				}
+				pods = append(pods, podOutput)
+			}
+			sort.Slice(pods, func(i, j int) bool {
+				if pods[i].Namespace != pods[j].Namespace {
+					return pods[i].Namespace < pods[j].Namespace
+				}
+				return pods[i].Name < pods[j].Name
+			})
+
+			node.UsedMemory += dev.usedGPUMem
+			if idx == -1 {
+				node.PendingMemory = dev.usedGPUMem
+				node.PendingPods = pods
+				continue
+			}
+			gpu := GPUOutput{
+				Index:       idx,
+				TotalMemory: dev.totalGPUMem,
+				UsedMemory:  dev.usedGPUMem,
+				Pods:        pods,
+			}
+			if nodeInfo.usage != nil {
+				if used, ok := nodeInfo.usage.UsedOfGPU(idx); ok {
+					gpu.ActualUsedMemory = &used
+				}
+			}
+			node.GPUs = append(node.GPUs, gpu)
+		}
+		sort.Slice(node.GPUs, func(i, j int) bool {
+			return node.GPUs[i].Index < node.GPUs[j].Index
+		})
+
+		out.Nodes = append(out.Nodes, node)
+		// the nodes may count gpu-mem in different units
+		out.Total.TotalMemory += nodeInfo.inMiB(node.TotalMemory)
+		out.Total.UsedMemory += nodeInfo.inMiB(node.UsedMemory)
+		out.Total.PendingMemory += nodeInfo.inMiB(node.PendingMemory)
+	}
+	sort.Slice(out.Nodes, func(i, j int) bool {
+		return out.Nodes[i].Name < out.Nodes[j].Name
+	})
+
+	return out
+}
+
+func nodeAddress(node v1.Node) string {
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == v1.NodeInternalIP {
+			return addr.Address
+		}
+	}
+	return "unknown"
+}
+
+// displayOutput writes the node infos in the output format
+func displayOutput(w io.Writer, nodeInfos []*NodeInfo, output string) error {
+	out := buildClusterOutput(nodeInfos)
+	switch output {
+	case outputJSON:
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case outputYAML:
+		data, err := yaml.Marshal(out)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case outputCSV:
+		return displayCSV(w, out)
+	case outputWide:
+		return displayWide(w, out)
+	}
+	return fmt.Errorf("unknown output %q", output)
+}
+
+// displayCSV writes a row for every pod on every GPU, and a row without the pod for the
+// GPUs without any pod. The memory is counted in the unit of the node, the GPU of the pending
+// pods is "pending", the actual used memory is empty if it's not published.
+func displayCSV(w io.Writer, out ClusterOutput) error {
+	writer := csv.NewWriter(w)
+	rows := [][]string{{"version", "memoryUnit", "node", "address", "gpu", "gpuTotalMemory", "gpuUsedMemory", "namespace", "pod", "podUsedMemory", "gpuActualUsedMemory", "podActualUsedMemory"}}
+	row := func(node NodeOutput, gpu string, total, used int, actualUsed *float64, pod *PodOutput) []string {
+		r := []string{out.Version, node.MemoryUnit, node.Name, node.Address, gpu, strconv.Itoa(total), strconv.Itoa(used), "", "", "", formatActualUsed(actualUsed), ""}
+		if pod != nil {
+			r[7], r[8], r[9], r[11] = pod.Namespace, pod.Name, strconv.Itoa(pod.UsedMemory), formatActualUsed(pod.ActualUsedMemory)
+		}
+		return r
+	}
+
+	for _, node := range out.Nodes {
+		for _, gpu := range node.GPUs {
+			if len(gpu.Pods) == 0 {
+				rows = append(rows, row(node, strconv.Itoa(gpu.Index), gpu.TotalMemory, gpu.UsedMemory, gpu.ActualUsedMemory, nil))
+			}
+			for i := range gpu.Pods {
+				rows = append(rows, row(node, strconv.Itoa(gpu.Index), gpu.TotalMemory, gpu.UsedMemory, gpu.ActualUsedMemory, &gpu.Pods[i]))
+			}
+		}
+		for i := range node.PendingPods {
+			rows = append(rows, row(node, "pending", 0, node.PendingMemory, nil, &node.PendingPods[i]))
+		}
+	}
+
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+func formatActualUsed(used *float64) string {
+	if used == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*used, 'f', -1, 64)
+}
+
+// displayWide writes the summary with the model, the GPU count and the number of pods of the nodes
+func displayWide(w io.Writer, out ClusterOutput) error {
+	maxGPUCount := 0
+	hasPending := false
+	hasUsage := false
+	for _, node := range out.Nodes {
+		if len(node.GPUs) > maxGPUCount {
+			maxGPUCount = len(node.GPUs)
+		}
+		if node.PendingMemory > 0 {
+			hasPending = true
+		}
+		if node.ActualUsedMemory != nil {
+			hasUsage = true
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "NAME\tIPADDRESS\tMODEL\tGPUS\tPODS\tUNIT\t")
+	for i := 0; i < maxGPUCount; i++ {
+		fmt.Fprintf(tw, "GPU%d(Allocated/Total)\t", i)
+		if hasUsage {
+			fmt.Fprintf(tw, "GPU%d(Used)\t", i)
+		}
+	}
+	if hasPending {
+		fmt.Fprint(tw, "PENDING(Allocated)\t")
+	}
+	if hasUsage {
+		fmt.Fprint(tw, "USED\t")
+	}
+	fmt.Fprint(tw, "GPU Memory\n")
+
+	for _, node := range out.Nodes {
+		pods := map[string]bool{}
+		for _, gpu := range node.GPUs {
+			for _, pod := range gpu.Pods {
+				pods[pod.Namespace+"/"+pod.Name] = true
+			}
+		}
+		for _, pod := range node.PendingPods {
+			pods[pod.Namespace+"/"+pod.Name] = true
+		}
+		model := node.Model
+		if model == "" {
+			model = "unknown"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t", node.Name, node.Address, model, node.GPUCount, len(pods), node.MemoryUnit)
+		for i := 0; i < maxGPUCount; i++ {
+			if i < len(node.GPUs) {
+				fmt.Fprintf(tw, "%d/%d\t", node.GPUs[i].UsedMemory, node.GPUs[i].TotalMemory)
+			} else {
+				fmt.Fprint(tw, "0/0\t")
+			}
+			if hasUsage {
+				used := "-"
+				if i < len(node.GPUs) && node.GPUs[i].ActualUsedMemory != nil {
+					used = formatUsed(*node.GPUs[i].ActualUsedMemory)
+				}
+				fmt.Fprintf(tw, "%s\t", used)
+			}
+		}
+		if hasPending {
+			fmt.Fprintf(tw, "%d\t", node.PendingMemory)
+		}
+		if hasUsage {
+			used := "-"
+			if node.ActualUsedMemory != nil {
+				used = formatUsed(*node.ActualUsedMemory)
+			}
+			fmt.Fprintf(tw, "%s\t", used)
+		}
+		fmt.Fprintf(tw, "%d/%d\n", node.UsedMemory, node.TotalMemory)
+	}
+
+	usage := 0
+	if out.Total.TotalMemory > 0 {
+		usage = out.Total.UsedMemory * 100 / out.Total.TotalMemory
+	}
+	fmt.Fprintf(tw, "\nAllocated/Total GPU Memory In Cluster(%s):\t%d/%d (%d%%)\n", out.MemoryUnit, out.Total.UsedMemory, out.Total.TotalMemory, usage)
+	return tw.Flush()
+}
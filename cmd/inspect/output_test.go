@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestDisplayOutput(t *testing.T) {
+	for _, output := range []string{outputJSON, outputYAML, outputCSV, outputWide} {
+		var buf bytes.Buffer
+		if err := displayOutput(&buf, newTestNodeInfos(t), output); err != nil {
+			t.Fatalf("%s: %v", output, err)
+		}
+
+		golden := filepath.Join("testdata", "cluster."+output+".golden")
+		if *update {
+			if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("%s: the output doesn't match %s, run go test -update if the change is expected\ngot:\n%s", output, golden, buf.String())
+		}
+	}
+}
+
+func TestDisplayOutputUnknown(t *testing.T) {
+	if isValidOutput("xml") {
+		t.Errorf("expected xml to be invalid")
+	}
+	var buf bytes.Buffer
+	if err := displayOutput(&buf, newTestNodeInfos(t), "xml"); err == nil {
+		t.Errorf("expected an error for the unknown output")
+	}
+}
@@ -2,12 +2,9 @@ package main
 
 import (
 	"fmt"
-	"os"
-	"path"
 	"time"
 
-	log "github.com/golang/glog"
-
+	"github.com/spf13/pflag"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
@@ -24,24 +21,70 @@ var (
 	retries      = 5
 )
 
-func kubeInit() {
-
-	kubeconfigFile := os.Getenv("KUBECONFIG")
-	if kubeconfigFile == "" {
-		kubeconfigFile = path.Join(os.Getenv("HOME"), "/.kube/config")
-	}
-	if _, err := os.Stat(kubeconfigFile); err != nil {
-		log.Fatalf("kubeconfig %s failed to find due to %v, please set KUBECONFIG env", kubeconfigFile, err)
-	}
+// bindKubeFlags binds the kubectl flags of the kubeconfig, e.g. --kubeconfig, --context, --namespace,
+// --as and --request-timeout. The in-cluster config is used if no kubeconfig is found.
+func bindKubeFlags(flags *pflag.FlagSet) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	flags.StringVar(&loadingRules.ExplicitPath, clientcmd.RecommendedConfigPathFlag, "", "Path to the kubeconfig file to use for CLI requests")
+	overrides := &clientcmd.ConfigOverrides{}
+	clientcmd.BindOverrideFlags(overrides, flags, clientcmd.RecommendedConfigOverrideFlags(""))
+	clientConfig = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+}
 
+func kubeInit() error {
 	var err error
-	restConfig, err = clientcmd.BuildConfigFromFlags("", kubeconfigFile)
+	restConfig, err = clientConfig.ClientConfig()
 	if err != nil {
-		log.Fatalf("Failed due to %v", err)
+		return fmt.Errorf("failed to load the kubeconfig due to %v", err)
 	}
 	clientset, err = kubernetes.NewForConfig(restConfig)
+	return err
+}
+
+// podFilter filters the pods shown, the GPU memory is still counted for all the pods
+type podFilter struct {
+	// namespace is empty for all the namespaces
+	namespace string
+	selector  labels.Selector
+}
+
+func newPodFilter(selector string) (*podFilter, error) {
+	filter := &podFilter{selector: labels.Everything()}
+	namespace, overridden, err := clientConfig.Namespace()
 	if err != nil {
-		log.Fatalf("Failed due to %v", err)
+		return nil, err
+	}
+	// show the pods in all the namespaces unless --namespace is set
+	if overridden {
+		filter.namespace = namespace
+	}
+	if selector != "" {
+		if filter.selector, err = labels.Parse(selector); err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", selector, err)
+		}
+	}
+	return filter, nil
+}
+
+func (f *podFilter) match(pod v1.Pod) bool {
+	if f.namespace != "" && pod.Namespace != f.namespace {
+		return false
+	}
+	return f.selector.Matches(labels.Set(pod.Labels))
+}
+
+// filterShownPods removes the pods which don't match the filter from the GPUs of the nodes
+func filterShownPods(nodeInfos []*NodeInfo, filter *podFilter) {
+	for _, nodeInfo := range nodeInfos {
+		for _, dev := range nodeInfo.devs {
+			pods := []v1.Pod{}
+			for _, pod := range dev.pods {
+				if filter.match(pod) {
+					pods = append(pods, pod)
+				}
+			}
+			dev.pods = pods
+		}
 	}
 }
 
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestFilterShownPods(t *testing.T) {
+	nodeInfos := newTestNodeInfos(t)
+	filterShownPods(nodeInfos, &podFilter{namespace: "team-a", selector: labels.Everything()})
+
+	out := buildClusterOutput(nodeInfos)
+	node := out.Nodes[0]
+	if len(node.GPUs[0].Pods) != 1 || node.GPUs[0].Pods[0].Name != "multi" || len(node.PendingPods) != 0 {
+		t.Errorf("expected only the pods in team-a to be shown, got %+v", node)
+	}
+	// the memory is still counted for the pods which are not shown
+	if node.GPUs[0].UsedMemory != 6 || node.PendingMemory != 2 {
+		t.Errorf("expected the memory of all the pods, got %+v", node)
+	}
+
+	nodeInfos = newTestNodeInfos(t)
+	selector, err := labels.Parse("app=none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filterShownPods(nodeInfos, &podFilter{selector: selector})
+	for _, gpu := range buildClusterOutput(nodeInfos).Nodes[0].GPUs {
+		if len(gpu.Pods) != 0 {
+			t.Errorf("expected no pods to match the selector, got %+v", gpu.Pods)
+		}
+	}
+}
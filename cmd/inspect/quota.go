@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	log "github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// the subcommand to show the gpu-mem used by each namespace
+const quotaCommand = "quota"
+
+// NamespaceUsage is the gpu-mem allocated to the pods of a namespace
+type NamespaceUsage struct {
+	namespace string
+	pods      map[types.UID]bool
+	allocated int
+	pending   int
+	// gpus is the gpu-mem allocated on each GPU, keyed by <node>/GPU<index>
+	gpus map[string]int
+	// quota is the quota on any single GPU, nil if it's not set
+	quota *gpushare.MemoryQuota
+	// limits is the quota on each GPU in memoryUnit, since a plain quota is counted in the unit of the node
+	limits map[string]int
+}
+
+// exceeded returns true if the gpu-mem allocated on any GPU is more than the quota
+func (u *NamespaceUsage) exceeded() bool {
+	for gpu, mem := range u.gpus {
+		if limit, ok := u.limits[gpu]; ok && mem > limit {
+			return true
+		}
+	}
+	return false
+}
+
+// maxOnGPU returns the most gpu-mem allocated on a single GPU and the GPU
+func (u *NamespaceUsage) maxOnGPU() (max int, gpu string) {
+	for _, key := range sortedGPUs(u.gpus) {
+		if u.gpus[key] > max {
+			max = u.gpus[key]
+			gpu = key
+		}
+	}
+	return max, gpu
+}
+
+func sortedGPUs(gpus map[string]int) []string {
+	keys := []string{}
+	for k := range gpus {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// getNamespaceQuotas returns the quota on any single GPU of the namespaces
+func getNamespaceQuotas() (map[string]gpushare.MemoryQuota, error) {
+	namespaces, err := clientset.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces due to %v", err)
+	}
+
+	quotas := map[string]gpushare.MemoryQuota{}
+	for _, ns := range namespaces.Items {
+		// the quota is enforced by the device plugin, and gpushare-webhook with --enforce-namespace-quota
+		quota, ok, err := gpushare.MaxMemoryPerGPUOfNamespace(&ns)
+		if err != nil {
+			log.Warningf("%v", err)
+			continue
+		}
+		if ok {
+			quotas[ns.Name] = quota
+		}
+	}
+	return quotas, nil
+}
+
+// buildNamespaceUsages aggregates the gpu-mem of the pods on the GPUs by namespace
+func buildNamespaceUsages(nodeInfos []*NodeInfo, quotas map[string]gpushare.MemoryQuota) []*NamespaceUsage {
+	usages := map[string]*NamespaceUsage{}
+	for _, nodeInfo := range nodeInfos {
+		for idx, dev := range nodeInfo.devs {
+			for _, pod := range dev.pods {
+				usage, ok := usages[pod.Namespace]
+				if !ok {
+					usage = &NamespaceUsage{
+						namespace: pod.Namespace,
+						pods:      map[types.UID]bool{},
+						gpus:      map[string]int{},
+						limits:    map[string]int{},
+					}
+					if quota, found := quotas[pod.Namespace]; found {
+						usage.quota = &quota
+					}
+					usages[pod.Namespace] = usage
+				}
+				usage.pods[pod.UID] = true
+
+				// the nodes may count gpu-mem in different units, they're summed in memoryUnit
+				mem := nodeInfo.inClusterUnit(nodeInfo.getDeivceInfo(pod)[idx])
+				usage.allocated += mem
+				if idx == -1 {
+					usage.pending += mem
+					continue
+				}
+				gpu := fmt.Sprintf("%s/GPU%d", nodeInfo.node.Name, idx)
+				usage.gpus[gpu] += mem
+				if usage.quota != nil {
+					usage.limits[gpu] = nodeInfo.inClusterUnit(int(usage.quota.OnNode(nodeInfo.unitMiB)))
+				}
+			}
+		}
+	}
+
+	list := []*NamespaceUsage{}
+	for _, usage := range usages {
+		list = append(list, usage)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].namespace < list[j].namespace
+	})
+	return list
+}
+
+// displayQuota shows the gpu-mem used by each namespace, and the GPUs of every namespace in details
+func displayQuota(out io.Writer, usages []*NamespaceUsage, details bool) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAMESPACE\tPODS\tALLOCATED\tPENDING\tMAX ON ONE GPU\tLIMIT PER GPU\tGPU Memory(%s)\n", memoryUnit)
+	for _, usage := range usages {
+		max, gpu := usage.maxOnGPU()
+		limit := "-"
+		if usage.quota != nil {
+			limit = usage.quota.String()
+		}
+		maxInfo := strconv.Itoa(max)
+		if gpu != "" {
+			maxInfo = fmt.Sprintf("%d (%s)", max, gpu)
+		}
+		if usage.exceeded() {
+			maxInfo += " EXCEEDED"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%s\t\n", usage.namespace, len(usage.pods), usage.allocated, usage.pending, maxInfo, limit)
+	}
+
+	if details {
+		var buf bytes.Buffer
+		buf.WriteString("\nNAMESPACE\tGPU\tALLOCATED\t\n")
+		for _, usage := range usages {
+			for _, gpu := range sortedGPUs(usage.gpus) {
+				buf.WriteString(fmt.Sprintf("%s\t%s\t%d\t\n", usage.namespace, gpu, usage.gpus[gpu]))
+			}
+		}
+		fmt.Fprint(w, buf.String())
+	}
+
+	_ = w.Flush()
+}
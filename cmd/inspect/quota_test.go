@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+)
+
+func TestBuildNamespaceUsages(t *testing.T) {
+	usages := buildNamespaceUsages(newTestNodeInfos(t), map[string]gpushare.MemoryQuota{"team-a": {Limit: 2}})
+	if len(usages) != 2 {
+		t.Fatalf("expected 2 namespaces, got %d", len(usages))
+	}
+
+	defaultUsage, teamA := usages[0], usages[1]
+	if defaultUsage.namespace != "default" || len(defaultUsage.pods) != 2 || defaultUsage.allocated != 6 ||
+		defaultUsage.pending != 2 || defaultUsage.quota != nil {
+		t.Errorf("unexpected usage of default: %+v", defaultUsage)
+	}
+	if max, gpu := teamA.maxOnGPU(); max != 3 || gpu != "node1/GPU1" {
+		t.Errorf("expected team-a to use 3 on node1/GPU1 at most, got %d on %s", max, gpu)
+	}
+
+	var buf bytes.Buffer
+	displayQuota(&buf, usages, true)
+	if !strings.Contains(buf.String(), "3 (node1/GPU1) EXCEEDED") {
+		t.Errorf("expected team-a to exceed the quota, got:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "team-a     node1/GPU0  2") {
+		t.Errorf("expected the details of team-a, got:\n%s", buf.String())
+	}
+}
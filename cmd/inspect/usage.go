@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// hasMemoryUsage tells if any node publishes the GPU memory used by the processes, the USED columns
+// are shown only if it does
+func hasMemoryUsage(nodeInfos []*NodeInfo) bool {
+	for _, nodeInfo := range nodeInfos {
+		if nodeInfo.usage != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// usedOfGPU returns the GPU memory used on the GPU of the index in the memory unit of the cluster,
+// or "-" if it's not published
+func (n *NodeInfo) usedOfGPU(idx int) string {
+	if n.usage == nil {
+		return "-"
+	}
+	used, ok := n.usage.UsedOfGPU(idx)
+	if !ok {
+		return "-"
+	}
+	return formatUsed(n.usedInClusterUnit(used))
+}
+
+// usedOfNode returns the GPU memory used on all the GPUs of the node
+func (n *NodeInfo) usedOfNode() (float64, bool) {
+	if n.usage == nil {
+		return 0, false
+	}
+	var used float64
+	for _, gpu := range n.usage.GPUs {
+		used += gpu.Used
+	}
+	// the usage keeps 2 decimals
+	return math.Round(used*100) / 100, true
+}
+
+// formatUsed keeps one decimal of the used memory, since it's not a whole number of the memory unit
+func formatUsed(used float64) string {
+	return strconv.FormatFloat(math.Round(used*10)/10, 'f', -1, 64)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	nodeInfos := newTestNodeInfos(t)
+	if !hasMemoryUsage(nodeInfos) {
+		t.Fatalf("expected node1 to publish the used memory")
+	}
+
+	for _, nodeInfo := range nodeInfos {
+		switch nodeInfo.node.Name {
+		case "node1":
+			if used := nodeInfo.usedOfGPU(0); used != "5.6" {
+				t.Errorf("expected 5.6 used on GPU0, got %s", used)
+			}
+			if used := nodeInfo.usedOfGPU(2); used != "-" {
+				t.Errorf("expected no usage of the missing GPU, got %s", used)
+			}
+			if used := nodeInfo.usage.UsedOfPod("team-a-multi", -1); used != 3.25 {
+				t.Errorf("expected team-a/multi to use 3.25 on the GPUs, got %v", used)
+			}
+		case "node2":
+			if used := nodeInfo.usedOfGPU(0); used != "-" {
+				t.Errorf("expected no usage on node2, got %s", used)
+			}
+		}
+	}
+
+	invalid := newTestNode("node3", "10.0.0.3", 1, 16, map[string]string{gpushare.NodeAnnotationMemoryUsage: "{"})
+	if usage := getMemoryUsage(invalid); usage != nil {
+		t.Errorf("expected the invalid usage to be ignored, got %+v", usage)
+	}
+	if hasMemoryUsage([]*NodeInfo{{node: v1.Node{}}}) {
+		t.Errorf("expected no usage")
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	log "github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// watchRefreshInterval is the shortest interval between the redraws
+	watchRefreshInterval = time.Second
+
+	clearScreen = "\033[H\033[2J"
+	colorGreen  = "\033[32m"
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// podPlacement is where the gpu-mem of a pod is allocated, gpu is <node>/GPU<index> or <node>/pending
+type podPlacement struct {
+	namespace string
+	name      string
+	gpu       string
+	memory    int
+}
+
+// snapshot is the allocation of the GPUs at a refresh
+type snapshot struct {
+	pending map[string]int
+	pods    map[types.UID][]podPlacement
+}
+
+func takeSnapshot(nodeInfos []*NodeInfo) snapshot {
+	s := snapshot{
+		pending: map[string]int{},
+		pods:    map[types.UID][]podPlacement{},
+	}
+	for _, nodeInfo := range nodeInfos {
+		for idx, dev := range nodeInfo.devs {
+			gpu := fmt.Sprintf("%s/GPU%d", nodeInfo.node.Name, idx)
+			if idx == -1 {
+				gpu = nodeInfo.node.Name + "/pending"
+				s.pending[nodeInfo.node.Name] = dev.usedGPUMem
+			}
+			for _, pod := range dev.pods {
+				s.pods[pod.UID] = append(s.pods[pod.UID], podPlacement{
+					namespace: pod.Namespace,
+					name:      pod.Name,
+					gpu:       gpu,
+					memory:    nodeInfo.getDeivceInfo(pod)[idx],
+				})
+			}
+		}
+	}
+	return s
+}
+
+// change is a difference between two snapshots
+type change struct {
+	color   string
+	message string
+}
+
+// diffSnapshots returns the new pods, the freed memory and the changes of the pending memory
+func diffSnapshots(prev, cur snapshot) []change {
+	changes := []change{}
+	for uid, placements := range cur.pods {
+		old, found := prev.pods[uid]
+		if found && samePlacements(old, placements) {
+			continue
+		}
+		for _, p := range placements {
+			changes = append(changes, change{colorGreen, fmt.Sprintf("+ %s/%s uses %d on %s", p.namespace, p.name, p.memory, p.gpu)})
+		}
+		for _, p := range old {
+			changes = append(changes, change{colorRed, fmt.Sprintf("- %s/%s freed %d on %s", p.namespace, p.name, p.memory, p.gpu)})
+		}
+	}
+	for uid, placements := range prev.pods {
+		if _, found := cur.pods[uid]; found {
+			continue
+		}
+		for _, p := range placements {
+			changes = append(changes, change{colorRed, fmt.Sprintf("- %s/%s freed %d on %s", p.namespace, p.name, p.memory, p.gpu)})
+		}
+	}
+
+	nodes := map[string]bool{}
+	for node := range prev.pending {
+		nodes[node] = true
+	}
+	for node := range cur.pending {
+		nodes[node] = true
+	}
+	for node := range nodes {
+		if before, after := prev.pending[node], cur.pending[node]; before != after {
+			changes = append(changes, change{colorYellow, fmt.Sprintf("~ pending on %s: %d -> %d", node, before, after)})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].message < changes[j].message
+	})
+	return changes
+}
+
+func samePlacements(a, b []podPlacement) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].gpu < a[j].gpu })
+	sort.Slice(b, func(i, j int) bool { return b[i].gpu < b[j].gpu })
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func displayChanges(w io.Writer, changes []change, at time.Time) {
+	fmt.Fprintf(w, "\nChanges since the last refresh (%s):\n", at.Format("15:04:05"))
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for _, c := range changes {
+		fmt.Fprintf(w, "  %s%s%s\n", c.color, c.message, colorReset)
+	}
+}
+
+// watcher redraws the table when the nodes or the pods change
+type watcher struct {
+	nodeName   string
+	nodeLister func() ([]*v1.Node, error)
+	podLister  func() ([]*v1.Pod, error)
+	// prepare is called before every redraw, e.g. to reload the assignments
+	prepare func() error
+	filter  *podFilter
+	display func(nodeInfos []*NodeInfo) error
+	changed chan struct{}
+	// start starts the informers
+	start  func(stop <-chan struct{})
+	synced []cache.InformerSynced
+}
+
+func newWatcher(nodeName string, filter *podFilter, prepare func() error, display func([]*NodeInfo) error) *watcher {
+	w := &watcher{
+		nodeName: nodeName,
+		filter:   filter,
+		prepare:  prepare,
+		display:  display,
+		changed:  make(chan struct{}, 1),
+	}
+
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	nodeInformer := factory.Core().V1().Nodes()
+	podFactory := factory
+	if nodeName != "" {
+		podFactory = informers.NewFilteredSharedInformerFactory(clientset, 0, v1.NamespaceAll, func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		})
+	}
+	podInformer := podFactory.Core().V1().Pods()
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { w.notify() },
+		UpdateFunc: func(oldObj, newObj interface{}) { w.notify() },
+		DeleteFunc: func(obj interface{}) { w.notify() },
+	}
+	nodeInformer.Informer().AddEventHandler(handler)
+	podInformer.Informer().AddEventHandler(handler)
+	w.nodeLister = func() ([]*v1.Node, error) { return nodeInformer.Lister().List(labels.Everything()) }
+	w.podLister = func() ([]*v1.Pod, error) { return podInformer.Lister().List(labels.Everything()) }
+	w.synced = []cache.InformerSynced{nodeInformer.Informer().HasSynced, podInformer.Informer().HasSynced}
+	w.start = func(stop <-chan struct{}) {
+		factory.Start(stop)
+		podFactory.Start(stop)
+	}
+
+	return w
+}
+
+func (w *watcher) notify() {
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// nodeInfos builds the node infos from the caches of the informers
+func (w *watcher) nodeInfos() ([]*NodeInfo, error) {
+	allNodes, err := w.nodeLister()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []v1.Node{}
+	for _, node := range allNodes {
+		if (w.nodeName == "" && isGPUSharingNode(*node)) || node.Name == w.nodeName {
+			nodes = append(nodes, *node)
+		}
+	}
+	if w.nodeName != "" && len(nodes) == 0 {
+		return nil, fmt.Errorf("node %s is not found", w.nodeName)
+	}
+
+	allPods, err := w.podLister()
+	if err != nil {
+		return nil, err
+	}
+	pods := []v1.Pod{}
+	for _, pod := range allPods {
+		pods = append(pods, *pod)
+	}
+
+	nodeInfos, err := buildAllNodeInfos(filterActivePods(pods), nodes)
+	if err != nil {
+		return nil, err
+	}
+	filterShownPods(nodeInfos, w.filter)
+	return nodeInfos, nil
+}
+
+// refresh redraws the table, and returns the snapshot to compare with at the next refresh
+func (w *watcher) refresh(out io.Writer, prev *snapshot) (*snapshot, error) {
+	if err := w.prepare(); err != nil {
+		return prev, err
+	}
+	nodeInfos, err := w.nodeInfos()
+	if err != nil {
+		return prev, err
+	}
+
+	cur := takeSnapshot(nodeInfos)
+	fmt.Fprint(out, clearScreen)
+	if err = w.display(nodeInfos); err != nil {
+		return prev, err
+	}
+	if prev != nil {
+		displayChanges(out, diffSnapshots(*prev, cur), time.Now())
+	}
+	return &cur, nil
+}
+
+// run starts the informers, and redraws the table at most once every watchRefreshInterval until stop is closed
+func (w *watcher) run(stop <-chan struct{}) error {
+	w.start(stop)
+	if !cache.WaitForCacheSync(stop, w.synced...) {
+		return fmt.Errorf("failed to sync the nodes and pods")
+	}
+
+	var prev *snapshot
+	w.notify()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-w.changed:
+		}
+		cur, err := w.refresh(os.Stdout, prev)
+		if err != nil {
+			log.Warningf("Failed to refresh due to %v", err)
+		}
+		prev = cur
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(watchRefreshInterval):
+		}
+	}
+}
+
+// watchNodes redraws the node infos with the display func until it's interrupted
+func watchNodes(nodeName, assignmentSource string, filter *podFilter, display func([]*NodeInfo) error) error {
+	prepare := func() error { return nil }
+	switch assignmentSource {
+	case assignmentSourceAnnotation:
+	case assignmentSourceConfigMap:
+		prepare = loadAssignments
+	default:
+		return fmt.Errorf("unknown assignment source %q", assignmentSource)
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	return newWatcher(nodeName, filter, prepare, display).run(stop)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	prev := takeSnapshot(newTestNodeInfos(t))
+
+	// single is deleted, pending is assigned to GPU1, and a new pod is pending
+	memoryUnit = ""
+	nodes := []v1.Node{newTestNode("node1", "10.0.0.1", 2, 32, map[string]string{memoryUnitKey: "GiB"})}
+	pods := []v1.Pod{
+		newTestPod("team-a", "multi", "node1", 5, map[string]string{gpushareAllocationFlag: `{"0":{"0":2,"1":3}}`}),
+		newTestPod("default", "pending", "node1", 2, map[string]string{envNVGPUID: "1"}),
+		newTestPod("default", "new", "node1", 3, nil),
+	}
+	nodeInfos, err := buildAllNodeInfos(pods, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []string{}
+	for _, c := range diffSnapshots(prev, takeSnapshot(nodeInfos)) {
+		messages = append(messages, c.message)
+	}
+	expected := []string{
+		"+ default/new uses 3 on node1/pending",
+		"+ default/pending uses 2 on node1/GPU1",
+		"- default/pending freed 2 on node1/pending",
+		"- default/single freed 4 on node1/GPU0",
+		"~ pending on node1: 2 -> 3",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected changes:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(messages, "\n"))
+	}
+
+	if changes := diffSnapshots(prev, takeSnapshot(newTestNodeInfos(t))); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
+
+func TestWatcherRefresh(t *testing.T) {
+	nodes := []v1.Node{newTestNode("node1", "10.0.0.1", 2, 32, map[string]string{memoryUnitKey: "GiB"})}
+	pods := []v1.Pod{newTestPod("default", "single", "node1", 4, map[string]string{envNVGPUID: "0"})}
+
+	prepared := 0
+	displayed := 0
+	w := &watcher{
+		nodeLister: func() ([]*v1.Node, error) { return []*v1.Node{&nodes[0]}, nil },
+		podLister: func() ([]*v1.Pod, error) {
+			list := []*v1.Pod{}
+			for i := range pods {
+				list = append(list, &pods[i])
+			}
+			return list, nil
+		},
+		prepare: func() error { prepared++; return nil },
+		filter:  &podFilter{selector: labels.Everything()},
+		display: func(nodeInfos []*NodeInfo) error { displayed++; return nil },
+	}
+
+	var buf bytes.Buffer
+	prev, err := w.refresh(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "Changes") {
+		t.Errorf("expected no changes at the first refresh, got %q", buf.String())
+	}
+
+	pods[0].Status.Phase = v1.PodSucceeded
+	buf.Reset()
+	if _, err = w.refresh(&buf, prev); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "- default/single freed 4 on node1/GPU0") {
+		t.Errorf("expected the finished pod to free the memory, got %q", buf.String())
+	}
+	if prepared != 2 || displayed != 2 {
+		t.Errorf("expected to prepare and display twice, got %d and %d", prepared, displayed)
+	}
+
+	w.nodeName = "node2"
+	if _, err = w.refresh(&buf, prev); err == nil {
+		t.Errorf("expected an error for the missing node")
+	}
+}
@@ -1,78 +1,115 @@
 package main
 
 import (
+	"encoding/base64"
 	"flag"
-	"fmt"
-	"io/ioutil"
-	"time"
+	"os"
 
 	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/gpu/nvidia"
-	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/kubelet/client"
 	log "github.com/golang/glog"
-	"k8s.io/client-go/rest"
 )
 
 var (
 	mps              = flag.Bool("mps", false, "Enable or Disable MPS")
 	healthCheck      = flag.Bool("health-check", false, "Enable or disable Health check")
-	memoryUnit       = flag.String("memory-unit", "GiB", "Set memoryUnit of the GPU Memroy, support 'GiB' and 'MiB'")
+	memoryUnit       = flag.String("memory-unit", "GiB", "Set memoryUnit of the GPU Memroy, support 'GiB', 'MiB' and a multiple of them such as '256MiB'")
+	reservedMemory   = flag.String("reserved-memory", "", "Reserve GPU Memory of each GPU for the driver and system daemons, e.g. '512MiB' or '5%', it can be overridden by the node label or annotation aliyun.com/gpu-mem-reserve, where a percentage is written as '5pct' in a label")
 	queryFromKubelet = flag.Bool("query-kubelet", false, "Query pending pods from kubelet instead of kube-apiserver")
-	kubeletAddress   = flag.String("kubelet-address", "0.0.0.0", "Kubelet IP Address")
+	kubeletAddress   = flag.String("kubelet-address", defaultKubeletAddress(), "Kubelet IP Address, it's the NODE_IP env by default")
 	kubeletPort      = flag.Uint("kubelet-port", 10250, "Kubelet listened Port")
 	clientCert       = flag.String("client-cert", "", "Kubelet TLS client certificate")
 	clientKey        = flag.String("client-key", "", "Kubelet TLS client key")
 	token            = flag.String("token", "", "Kubelet client bearer token")
+	tokenFile        = flag.String("token-file", "", "Kubelet client bearer token file, it's read again when the token is rotated, the service account token is used if no credential is set")
 	timeout          = flag.Int("timeout", 10, "Kubelet client http timeout duration")
+	kubeletCAFile    = flag.String("kubelet-ca-file", "", "CA file to verify the serving certificate of kubelet, the system roots are used if neither the CA file nor data is set")
+	kubeletCAData    = flag.String("kubelet-ca-data", "", "Base64 encoded PEM of the CA to verify the serving certificate of kubelet, it supersedes --kubelet-ca-file")
+	kubeletServer    = flag.String("kubelet-server-name", "", "Name to verify the serving certificate of kubelet against, --kubelet-address is used if it's empty")
+	kubeletInsecure  = flag.Bool("kubelet-insecure-skip-tls-verify", false, "Don't verify the serving certificate of kubelet, the connection is insecure. Without --kubelet-ca-file, --kubelet-ca-data and --kubelet-server-name, --query-kubelet doesn't verify kubelet either, which is deprecated")
+	cdi              = flag.Bool("cdi", false, "Write the CDI spec of the GPUs with the driver files in --host-root and request them by CDI in Allocate, it requires containerd or CRI-O with CDI enabled")
+	cdiSpecDir       = flag.String("cdi-spec-dir", nvidia.DefaultCDISpecDir, "Directory of the CDI spec")
+	deviceSpecs      = flag.Bool("device-specs", false, "Return the device nodes and the driver mounts in Allocate, so the containers work without the nvidia container runtime")
+	hostRoot         = flag.String("host-root", "/", "Where the root of the host is mounted, it's used to discover the driver files")
+	assumedPodTTL    = flag.Int("assumed-pod-ttl", 0, "Expire the pods which are assumed but not allocated in the seconds and delete the expired pods of the controllers to be recreated, 0 disables it")
+	assignmentStore  = flag.String("assignment-store", nvidia.AssignmentStoreAnnotation, "Where the assigned state of the pods is kept: 'annotation' patches the pods in Allocate, 'file' records them in a file on the node and 'configmap' records them in a ConfigMap of the node in batch. With 'file' and 'configmap', ALIYUN_COM_GPU_MEM_ASSIGNED of the pods stays false in kube-apiserver, so the scheduler extender sees them as assumed but not allocated")
+	assignmentFile   = flag.String("assignment-file", nvidia.DefaultAssignmentFile, "File of the assigned pods used by the file assignment store")
+	gpuShareNode     = flag.Bool("gpushare-node", false, "Report the GPUs and their pods in the GPUShareNode of the node, it requires the CRD in device-plugin-crd.yaml")
+	memoryUsage      = flag.Int("memory-usage-interval", 0, "Publish the GPU memory used by the pods in the node annotation aliyun.com/gpu-mem-usage every the seconds, 0 disables it, it requires hostPID or the host /proc mounted at --proc-root")
+	procRoot         = flag.String("proc-root", nvidia.DefaultProcRoot, "Where the proc filesystem of the host is, it's used to map the GPU processes to the pods")
+	enforceAction    = flag.String("enforce-action", "", "Apply the action to the containers which use more GPU memory than their gpu-mem: 'event', 'annotate' or 'kill', it's disabled if empty, it requires the same access to the host processes as --memory-usage-interval")
+	enforceDryRun    = flag.Bool("enforce-dry-run", true, "Only log the containers which use more GPU memory than their gpu-mem and the action, set it to false to apply the action")
+	enforceInterval  = flag.Int("enforce-interval", 10, "Seconds between sampling the GPU processes by the enforcer")
+	enforceTolerance = flag.String("enforce-tolerance", "", "GPU memory a container may use over its gpu-mem, e.g. '256MiB' or '10%' of the gpu-mem")
+	metricsAddress   = flag.String("metrics-address", "", "Serve the metrics on the address at /debug/vars, e.g. ':9445', it's disabled if empty")
+	configFile       = flag.String("config", "", "Path of the config file, e.g. mounted from a ConfigMap, the flags are used as its defaults")
 )
 
-func buildKubeletClient() *client.KubeletClient {
-	if *clientCert == "" && *clientKey == "" && *token == "" {
-		tokenByte, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
-		if err != nil {
-			panic(fmt.Errorf("in cluster mode, find token failed, error: %v", err))
-		}
-		tokenStr := string(tokenByte)
-		token = &tokenStr
+// defaultKubeletAddress is the node IP set by the DaemonSet, which the serving certificate of kubelet can match
+func defaultKubeletAddress() string {
+	if ip := os.Getenv("NODE_IP"); ip != "" {
+		return ip
 	}
-	kubeletClient, err := client.NewKubeletClient(&client.KubeletClientConfig{
-		Address: *kubeletAddress,
-		Port:    *kubeletPort,
-		TLSClientConfig: rest.TLSClientConfig{
-			Insecure:   true,
-			ServerName: "gpushare-device-plugin",
-			CertFile:   *clientCert,
-			KeyFile:    *clientKey,
-		},
-		BearerToken: *token,
-		HTTPTimeout: time.Duration(*timeout) * time.Second,
-	})
-	if err != nil {
-		panic(err)
-	}
-	return kubeletClient
+	return "0.0.0.0"
 }
 
 func main() {
 	flag.Parse()
 	log.V(1).Infoln("Start gpushare device plugin")
 
-	kubeletClient := buildKubeletClient()
-	ngm := nvidia.NewSharedGPUManager(*mps, *healthCheck, *queryFromKubelet, translatememoryUnits(*memoryUnit), kubeletClient)
-	err := ngm.Run()
-	if err != nil {
-		log.Fatalf("Failed due to %v", err)
+	if *metricsAddress != "" {
+		nvidia.ServeMetrics(*metricsAddress)
 	}
-}
 
-func translatememoryUnits(value string) nvidia.MemoryUnit {
-	memoryUnit := nvidia.MemoryUnit(value)
-	switch memoryUnit {
-	case nvidia.MiBPrefix:
-	case nvidia.GiBPrefix:
-	default:
-		log.Warningf("Unsupported memory unit: %s, use memoryUnit Gi as default", value)
-		memoryUnit = nvidia.GiBPrefix
+	caData, err := base64.StdEncoding.DecodeString(*kubeletCAData)
+	if err != nil {
+		log.Fatalf("Invalid --kubelet-ca-data due to %v", err)
 	}
 
-	return memoryUnit
+	driver := nvidia.DefaultDriverConfig()
+	driver.HostRoot = *hostRoot
+
+	ngm, err := nvidia.NewSharedGPUManager(nvidia.Config{
+		Version:             nvidia.ConfigVersion,
+		MPS:                 *mps,
+		HealthCheck:         *healthCheck,
+		MemoryUnit:          *memoryUnit,
+		ReservedMemory:      *reservedMemory,
+		QueryKubelet:        *queryFromKubelet,
+		CDI:                 *cdi,
+		CDISpecDir:          *cdiSpecDir,
+		DeviceSpecs:         *deviceSpecs,
+		Driver:              driver,
+		AssumedPodTTL:       *assumedPodTTL,
+		AssignmentStore:     *assignmentStore,
+		AssignmentFile:      *assignmentFile,
+		GPUShareNode:        *gpuShareNode,
+		MemoryUsageInterval: *memoryUsage,
+		ProcRoot:            *procRoot,
+		Enforcer: nvidia.EnforcerConfig{
+			Action:    *enforceAction,
+			DryRun:    *enforceDryRun,
+			Interval:  *enforceInterval,
+			Tolerance: *enforceTolerance,
+		},
+		Kubelet: nvidia.KubeletConfig{
+			Address:               *kubeletAddress,
+			Port:                  *kubeletPort,
+			ClientCert:            *clientCert,
+			ClientKey:             *clientKey,
+			Token:                 *token,
+			TokenFile:             *tokenFile,
+			Timeout:               *timeout,
+			CAFile:                *kubeletCAFile,
+			CAData:                caData,
+			ServerName:            *kubeletServer,
+			InsecureSkipTLSVerify: *kubeletInsecure,
+		},
+	}, *configFile)
+	if err != nil {
+		log.Fatalf("Failed due to %v", err)
+	}
+	err = ngm.Run()
+	if err != nil {
+		log.Fatalf("Failed due to %v", err)
+	}
 }
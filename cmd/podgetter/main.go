@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/kubelet/client"
-	"io/ioutil"
 	"k8s.io/client-go/rest"
+	"os"
 	"time"
 )
 
@@ -13,45 +15,78 @@ var (
 	clientCert string
 	clientKey  string
 	token      string
+	tokenFile  string
 	timeout    int
+	caFile     string
+	serverName string
+	insecure   bool
+	path       string
 )
 
 func main() {
 	flag.StringVar(&clientCert, "client-cert", "", "")
 	flag.StringVar(&clientKey, "client-key", "", "")
 	flag.StringVar(&token, "token", "", "")
+	flag.StringVar(&tokenFile, "token-file", "", "Bearer token file, the service account token is used if no credential is set")
 	flag.IntVar(&timeout, "timeout", 10, "")
+	flag.StringVar(&caFile, "ca-file", "", "CA file to verify the serving certificate of kubelet")
+	flag.StringVar(&serverName, "server-name", "", "Name to verify the serving certificate of kubelet against")
+	flag.BoolVar(&insecure, "insecure-skip-tls-verify", false, "Don't verify the serving certificate of kubelet")
+	flag.StringVar(&path, "path", "/pods", "API of kubelet to get, one of /pods, /stats/summary, /healthz and /configz")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Gets the API of kubelet at 127.0.0.1:10250. Its serving certificate is verified by the system roots")
+		fmt.Fprintln(os.Stderr, "against 127.0.0.1 by default, which fails with the certificate of kubelet. Set --ca-file and")
+		fmt.Fprintln(os.Stderr, "--server-name to the node name, or --insecure-skip-tls-verify.")
+		flag.PrintDefaults()
+	}
 
 	flag.Parse()
 
-	if clientCert == "" && clientKey == "" && token == "" {
-		tokenByte, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
-		if err != nil {
-			panic(fmt.Errorf("in cluster mode, find token failed, error: %v", err))
-		}
-		token = string(tokenByte)
+	if clientCert == "" && clientKey == "" && token == "" && tokenFile == "" {
+		tokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
 	}
 
 	c, err := client.NewKubeletClient(&client.KubeletClientConfig{
 		Address: "127.0.0.1",
 		Port:    10250,
 		TLSClientConfig: rest.TLSClientConfig{
-			Insecure:   true,
-			ServerName: "kubelet",
+			Insecure:   insecure,
+			ServerName: serverName,
+			CAFile:     caFile,
 			CertFile:   clientCert,
 			KeyFile:    clientKey,
 		},
-		BearerToken: token,
-		HTTPTimeout: time.Duration(timeout) * time.Second,
+		BearerToken:     token,
+		BearerTokenFile: tokenFile,
+		HTTPTimeout:     time.Duration(timeout) * time.Second,
 	})
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	podsList, err := c.GetNodeRunningPods()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	var result interface{}
+	switch path {
+	case "/pods":
+		result, err = c.GetPods(ctx)
+	case "/stats/summary":
+		result, err = c.GetStatsSummary(ctx)
+	case "/healthz":
+		if err = c.Healthz(ctx); err == nil {
+			result = "ok"
+		}
+	case "/configz":
+		result, err = c.GetConfigz(ctx)
+	default:
+		err = fmt.Errorf("unknown path %s", path)
+	}
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	fmt.Println(podsList)
+	out, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(out))
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The types of admission.k8s.io/v1beta1, k8s.io/api/admission is not vendored
+
+// AdmissionReview describes an admission review request/response.
+type AdmissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Request         *AdmissionRequest  `json:"request,omitempty"`
+	Response        *AdmissionResponse `json:"response,omitempty"`
+}
+
+// AdmissionRequest describes the admission.Attributes for the admission request.
+type AdmissionRequest struct {
+	UID       types.UID                   `json:"uid"`
+	Kind      metav1.GroupVersionKind     `json:"kind"`
+	Resource  metav1.GroupVersionResource `json:"resource"`
+	Name      string                      `json:"name,omitempty"`
+	Namespace string                      `json:"namespace,omitempty"`
+	Operation string                      `json:"operation"`
+	Object    runtime.RawExtension        `json:"object,omitempty"`
+}
+
+// AdmissionResponse describes an admission response.
+type AdmissionResponse struct {
+	UID       types.UID      `json:"uid"`
+	Allowed   bool           `json:"allowed"`
+	Result    *metav1.Status `json:"status,omitempty"`
+	Patch     []byte         `json:"patch,omitempty"`
+	PatchType *string        `json:"patchType,omitempty"`
+}
+
+var podResource = metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// admitFunc reviews the pod of the request
+type admitFunc func(pod *v1.Pod) *AdmissionResponse
+
+// review decodes the pod of the request, and reviews it with the admit func
+func review(ar *AdmissionReview, admit admitFunc) *AdmissionReview {
+	response := &AdmissionResponse{Allowed: true}
+	if ar.Request == nil {
+		return &AdmissionReview{TypeMeta: ar.TypeMeta, Response: response}
+	}
+
+	if ar.Request.Resource == podResource {
+		pod := &v1.Pod{}
+		if err := json.Unmarshal(ar.Request.Object.Raw, pod); err != nil {
+			response = denied(fmt.Sprintf("failed to decode the pod: %v", err))
+		} else {
+			if pod.Namespace == "" {
+				pod.Namespace = ar.Request.Namespace
+			}
+			response = admit(pod)
+		}
+	}
+	response.UID = ar.Request.UID
+
+	return &AdmissionReview{TypeMeta: ar.TypeMeta, Response: response}
+}
+
+func allowed() *AdmissionResponse {
+	return &AdmissionResponse{Allowed: true}
+}
+
+func denied(message string) *AdmissionResponse {
+	return &AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: message,
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		},
+	}
+}
+
+// serve handles the admission review requests with the admit func
+func serve(admit admitFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			http.Error(w, fmt.Sprintf("unsupported content type %q", contentType), http.StatusUnsupportedMediaType)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ar := &AdmissionReview{}
+		if err = json.Unmarshal(body, ar); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode the admission review: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := json.Marshal(review(ar, admit))
+		if err != nil {
+			log.Warningf("Failed to encode the admission review due to %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/golang/glog"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	port         = flag.Int("port", 8443, "Port of the webhook server")
+	tlsCertFile  = flag.String("tls-cert-file", "/etc/gpushare-webhook/tls.crt", "TLS certificate of the webhook server")
+	tlsKeyFile   = flag.String("tls-key-file", "/etc/gpushare-webhook/tls.key", "TLS key of the webhook server")
+	checkNodes   = flag.Bool("check-gpu-memory", true, "Reject the pods requesting more GPU memory than any GPU in the cluster")
+	enforceQuota = flag.Bool("enforce-namespace-quota", false, "Reject the pods exceeding the gpu-mem quota of the namespace on any single GPU, which is set by the annotation aliyun.com/gpu-mem-max-per-gpu of the namespace")
+	mutateFile   = flag.String("mutate-config", "", "The defaults injected into the gpu-mem pods, only the node affinity on gpushare=true is injected if it's not set")
+)
+
+func kubeClient() (*kubernetes.Clientset, error) {
+	kubeconfigFile := os.Getenv("KUBECONFIG")
+	var config *rest.Config
+	var err error
+	if _, err = os.Stat(kubeconfigFile); err != nil {
+		config, err = rest.InClusterConfig()
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfigFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+func main() {
+	flag.Parse()
+	log.V(1).Infoln("Start gpushare webhook")
+
+	v := &validator{}
+	m := &mutator{config: defaultMutateConfig()}
+	if *mutateFile != "" {
+		config, err := loadMutateConfig(*mutateFile)
+		if err != nil {
+			log.Fatalf("Failed due to %v", err)
+		}
+		m.config = config
+	}
+	if *checkNodes || *enforceQuota {
+		clientset, err := kubeClient()
+		if err != nil {
+			log.Fatalf("Failed due to %v", err)
+		}
+		factory := informers.NewSharedInformerFactory(clientset, 10*time.Minute)
+		nodeInformer := factory.Core().V1().Nodes()
+		synced := []cache.InformerSynced{nodeInformer.Informer().HasSynced}
+		if *checkNodes {
+			v.nodeLister = nodeInformer.Lister()
+			m.nodeLister = nodeInformer.Lister()
+		}
+		if *enforceQuota {
+			namespaceInformer := factory.Core().V1().Namespaces()
+			podInformer := factory.Core().V1().Pods()
+			v.quota = &quotaChecker{
+				namespaceLister: namespaceInformer.Lister(),
+				podLister:       podInformer.Lister(),
+				nodeLister:      nodeInformer.Lister(),
+			}
+			synced = append(synced, namespaceInformer.Informer().HasSynced, podInformer.Informer().HasSynced)
+		}
+		stop := make(chan struct{})
+		factory.Start(stop)
+		if !cache.WaitForCacheSync(stop, synced...) {
+			log.Fatalln("Failed to sync the informers")
+		}
+	}
+
+	http.HandleFunc("/validate", serve(v.admit))
+	http.HandleFunc("/mutate", serve(m.admit))
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	address := fmt.Sprintf(":%d", *port)
+	log.Infof("Serving on %s", address)
+	if err := http.ListenAndServeTLS(address, *tlsCertFile, *tlsKeyFile, nil); err != nil {
+		log.Fatalf("Failed due to %v", err)
+	}
+}
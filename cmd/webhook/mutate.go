@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	"github.com/ghodss/yaml"
+	log "github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// The placeholders in the env values, they're replaced with the GPU memory requested by the container
+const (
+	placeholderFraction = "{fraction}"
+	placeholderPercent  = "{percent}"
+	placeholderMemory   = "{memory}"
+)
+
+var jsonPatchType = "JSONPatch"
+
+// mutateConfig is the defaults injected into the gpu-mem pods
+type mutateConfig struct {
+	// SchedulerName replaces the default scheduler of the pod, e.g. the scheduler with the gpushare extender
+	SchedulerName string `json:"schedulerName,omitempty"`
+	// NodeSelector is added to the required node affinity of the pod
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations are added to the pod if the pod doesn't have them
+	Tolerations []v1.Toleration `json:"tolerations,omitempty"`
+	// Envs are added to the containers requesting gpu-mem if they're not set, the values may contain
+	// {fraction} (e.g. 0.25 for TF_GPU_MEMORY_FRACTION), {percent} (e.g. 25 for
+	// CUDA_MPS_ACTIVE_THREAD_PERCENTAGE) and {memory} (the gpu-mem of the container)
+	Envs map[string]string `json:"envs,omitempty"`
+	// GPUMemory is the gpu-mem of one GPU to compute {fraction} and {percent}. The webhook doesn't know
+	// the GPU the pod is scheduled to, so the largest GPU in the cluster is used if it's 0, and the
+	// fraction is smaller than the actual one on a smaller GPU. Set it if the GPUs are not the same.
+	// The gpu-mem counts of the nodes are in their own memory units, so they should be the same too.
+	GPUMemory int64 `json:"gpuMemory,omitempty"`
+}
+
+// defaultMutateConfig schedules the pods on the nodes labeled by device-plugin-ds.yaml
+func defaultMutateConfig() mutateConfig {
+	return mutateConfig{
+		NodeSelector: map[string]string{"gpushare": "true"},
+	}
+}
+
+func loadMutateConfig(file string) (mutateConfig, error) {
+	config := defaultMutateConfig()
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+
+	return config, nil
+}
+
+// mutator injects the defaults into the gpu-mem pods
+type mutator struct {
+	config mutateConfig
+	// nodeLister lists the nodes to find the largest GPU, the fraction envs are skipped if
+	// it's nil and GPUMemory isn't set
+	nodeLister corelisters.NodeLister
+}
+
+// patchOperation is a JSON patch operation
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func (m *mutator) admit(pod *v1.Pod) *AdmissionResponse {
+	if !requestsGPUMemory(pod) {
+		return allowed()
+	}
+
+	gpuMemory := m.config.GPUMemory
+	if gpuMemory == 0 && m.nodeLister != nil {
+		nodes, err := m.nodeLister.List(labels.Everything())
+		if err != nil {
+			log.Warningf("Failed to list nodes due to %v", err)
+		} else {
+			gpuMemory = largestGPU(gpuSizesOfNodes(nodes)).gpuMemory
+		}
+	}
+
+	patch := m.patch(pod, gpuMemory)
+	if len(patch) == 0 {
+		return allowed()
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return denied(fmt.Sprintf("failed to encode the patch: %v", err))
+	}
+	log.V(4).Infof("Patch pod %s in ns %s: %s", podName(pod), pod.Namespace, data)
+
+	response := allowed()
+	response.Patch = data
+	response.PatchType = &jsonPatchType
+	return response
+}
+
+// patch returns the JSON patch which injects the defaults into the pod
+func (m *mutator) patch(pod *v1.Pod, gpuMemory int64) []patchOperation {
+	patch := []patchOperation{}
+
+	if m.config.SchedulerName != "" && (pod.Spec.SchedulerName == "" || pod.Spec.SchedulerName == v1.DefaultSchedulerName) {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/schedulerName", Value: m.config.SchedulerName})
+	}
+
+	if affinity := withNodeSelector(pod.Spec.Affinity, m.config.NodeSelector); !reflect.DeepEqual(affinity, pod.Spec.Affinity) {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/affinity", Value: affinity})
+	}
+
+	tolerations := append([]v1.Toleration{}, pod.Spec.Tolerations...)
+	for _, toleration := range m.config.Tolerations {
+		if !hasToleration(tolerations, toleration) {
+			tolerations = append(tolerations, toleration)
+		}
+	}
+	if len(tolerations) != len(pod.Spec.Tolerations) {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/tolerations", Value: tolerations})
+	}
+
+	for i, c := range pod.Spec.Containers {
+		limit, ok := c.Resources.Limits[gpushare.ResourceName]
+		if !ok || limit.Value() <= 0 {
+			continue
+		}
+		env := append([]v1.EnvVar{}, c.Env...)
+		for _, name := range sortedKeys(m.config.Envs) {
+			if hasEnv(env, name) {
+				continue
+			}
+			value, ok := envValue(m.config.Envs[name], limit.Value(), gpuMemory)
+			if !ok {
+				log.Warningf("Skip env %s of container %s in pod %s since the GPU memory is unknown", name, c.Name, podName(pod))
+				continue
+			}
+			env = append(env, v1.EnvVar{Name: name, Value: value})
+		}
+		if len(env) != len(c.Env) {
+			patch = append(patch, patchOperation{Op: "add", Path: fmt.Sprintf("/spec/containers/%d/env", i), Value: env})
+		}
+	}
+
+	return patch
+}
+
+// withNodeSelector returns the affinity which requires the node labels in addition,
+// the labels are added to every node selector term since the terms are ORed
+func withNodeSelector(affinity *v1.Affinity, nodeSelector map[string]string) *v1.Affinity {
+	if len(nodeSelector) == 0 {
+		return affinity
+	}
+
+	requirements := []v1.NodeSelectorRequirement{}
+	for _, key := range sortedKeys(nodeSelector) {
+		requirements = append(requirements, v1.NodeSelectorRequirement{
+			Key:      key,
+			Operator: v1.NodeSelectorOpIn,
+			Values:   []string{nodeSelector[key]},
+		})
+	}
+
+	if affinity == nil {
+		affinity = &v1.Affinity{}
+	} else {
+		affinity = affinity.DeepCopy()
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &v1.NodeAffinity{}
+	}
+	required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil || len(required.NodeSelectorTerms) == 0 {
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{{MatchExpressions: requirements}},
+		}
+		return affinity
+	}
+
+	for i := range required.NodeSelectorTerms {
+		term := &required.NodeSelectorTerms[i]
+		for _, r := range requirements {
+			if !hasRequirement(term.MatchExpressions, r.Key) {
+				term.MatchExpressions = append(term.MatchExpressions, r)
+			}
+		}
+	}
+	return affinity
+}
+
+func hasRequirement(requirements []v1.NodeSelectorRequirement, key string) bool {
+	for _, r := range requirements {
+		if r.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func hasToleration(tolerations []v1.Toleration, toleration v1.Toleration) bool {
+	for _, t := range tolerations {
+		if t.MatchToleration(&toleration) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEnv(env []v1.EnvVar, name string) bool {
+	for _, e := range env {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// envValue replaces the placeholders with the GPU memory of the container, the fraction is
+// rounded down so the container never takes more than requested unless it's less than 0.01
+func envValue(template string, memory, gpuMemory int64) (string, bool) {
+	if !strings.Contains(template, placeholderFraction) && !strings.Contains(template, placeholderPercent) {
+		return strings.Replace(template, placeholderMemory, strconv.FormatInt(memory, 10), -1), true
+	}
+	if gpuMemory <= 0 {
+		return "", false
+	}
+
+	fraction := math.Min(float64(memory)/float64(gpuMemory), 1)
+	// 0 means no limit to TF_GPU_MEMORY_FRACTION and CUDA_MPS_ACTIVE_THREAD_PERCENTAGE,
+	// so they're at least 0.01 and 1
+	percent := int64(math.Max(math.Floor(fraction*100), 1))
+	value := strings.NewReplacer(
+		placeholderFraction, strconv.FormatFloat(float64(percent)/100, 'f', 2, 64),
+		placeholderPercent, strconv.FormatInt(percent, 10),
+		placeholderMemory, strconv.FormatInt(memory, 10),
+	).Replace(template)
+	return value, true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
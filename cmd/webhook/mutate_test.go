@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// rawPatchOperation keeps the value to decode it into the type of the path
+type rawPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// mutate posts the review in the file, and returns the patch of the response by path
+func mutate(t *testing.T, server *httptest.Server, file string) map[string]json.RawMessage {
+	resp, err := http.Post(server.URL, "application/json", bytes.NewReader(readReview(t, file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	ar := &AdmissionReview{}
+	if err = json.NewDecoder(resp.Body).Decode(ar); err != nil {
+		t.Fatalf("%s: failed to decode the response: %v", file, err)
+	}
+	if ar.Response == nil || !ar.Response.Allowed {
+		t.Fatalf("%s: expected the pod to be allowed, got %+v", file, ar.Response)
+	}
+
+	patch := map[string]json.RawMessage{}
+	if len(ar.Response.Patch) == 0 {
+		return patch
+	}
+	if ar.Response.PatchType == nil || *ar.Response.PatchType != "JSONPatch" {
+		t.Errorf("%s: expected patch type JSONPatch, got %v", file, ar.Response.PatchType)
+	}
+	operations := []rawPatchOperation{}
+	if err = json.Unmarshal(ar.Response.Patch, &operations); err != nil {
+		t.Fatalf("%s: failed to decode the patch: %v", file, err)
+	}
+	for _, op := range operations {
+		if op.Op != "add" {
+			t.Errorf("%s: expected op add, got %s", file, op.Op)
+		}
+		patch[op.Path] = op.Value
+	}
+	return patch
+}
+
+func decodeValue(t *testing.T, data json.RawMessage, v interface{}) {
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestMutator(t *testing.T) *mutator {
+	config, err := loadMutateConfig(filepath.Join("testdata", "mutate-config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the largest GPU has 15 GiB
+	return &mutator{config: config, nodeLister: newNodeLister(t, map[string][2]int64{
+		"node1": {30, 2},
+		"node2": {28, 4},
+	})}
+}
+
+func TestMutate(t *testing.T) {
+	server := httptest.NewServer(serve(newTestMutator(t).admit))
+	defer server.Close()
+
+	patch := mutate(t, server, "valid.json")
+	if len(patch) != 5 {
+		t.Errorf("expected 5 operations, got %v", patch)
+	}
+
+	schedulerName := ""
+	decodeValue(t, patch["/spec/schedulerName"], &schedulerName)
+	if schedulerName != "gpushare-scheduler" {
+		t.Errorf("expected scheduler gpushare-scheduler, got %q", schedulerName)
+	}
+
+	affinity := &v1.Affinity{}
+	decodeValue(t, patch["/spec/affinity"], affinity)
+	expectedAffinity := withNodeSelector(nil, map[string]string{"gpushare": "true"})
+	if !reflect.DeepEqual(affinity, expectedAffinity) {
+		t.Errorf("expected affinity %+v, got %+v", expectedAffinity, affinity)
+	}
+
+	tolerations := []v1.Toleration{}
+	decodeValue(t, patch["/spec/tolerations"], &tolerations)
+	if len(tolerations) != 1 || tolerations[0].Key != "gpushare" || tolerations[0].Effect != v1.TaintEffectNoSchedule {
+		t.Errorf("expected the gpushare toleration, got %+v", tolerations)
+	}
+
+	expectedEnvs := map[string][]v1.EnvVar{
+		"/spec/containers/0/env": {
+			{Name: "CUDA_MPS_ACTIVE_THREAD_PERCENTAGE", Value: "26"},
+			{Name: "PYTORCH_CUDA_ALLOC_CONF", Value: "max_split_size_mb:128"},
+			{Name: "TF_GPU_MEMORY_FRACTION", Value: "0.26"},
+		},
+		"/spec/containers/1/env": {
+			{Name: "CUDA_MPS_ACTIVE_THREAD_PERCENTAGE", Value: "13"},
+			{Name: "PYTORCH_CUDA_ALLOC_CONF", Value: "max_split_size_mb:128"},
+			{Name: "TF_GPU_MEMORY_FRACTION", Value: "0.13"},
+		},
+	}
+	for path, expected := range expectedEnvs {
+		env := []v1.EnvVar{}
+		decodeValue(t, patch[path], &env)
+		if !reflect.DeepEqual(env, expected) {
+			t.Errorf("%s: expected %+v, got %+v", path, expected, env)
+		}
+	}
+}
+
+func TestMutateKeepsPodSettings(t *testing.T) {
+	server := httptest.NewServer(serve(newTestMutator(t).admit))
+	defer server.Close()
+
+	patch := mutate(t, server, "customized.json")
+	for _, path := range []string{"/spec/schedulerName", "/spec/tolerations"} {
+		if _, ok := patch[path]; ok {
+			t.Errorf("expected %s not to be patched", path)
+		}
+	}
+
+	affinity := &v1.Affinity{}
+	decodeValue(t, patch["/spec/affinity"], affinity)
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 2 || len(terms[0].MatchExpressions) != 2 || len(terms[1].MatchExpressions) != 1 {
+		t.Errorf("expected gpushare=true to be added to the terms without it, got %+v", terms)
+	}
+
+	env := []v1.EnvVar{}
+	decodeValue(t, patch["/spec/containers/0/env"], &env)
+	expected := []v1.EnvVar{
+		{Name: "TF_GPU_MEMORY_FRACTION", Value: "0.5"},
+		{Name: "CUDA_MPS_ACTIVE_THREAD_PERCENTAGE", Value: "26"},
+		{Name: "PYTORCH_CUDA_ALLOC_CONF", Value: "max_split_size_mb:128"},
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("expected %+v, got %+v", expected, env)
+	}
+}
+
+func TestMutateSkipsPods(t *testing.T) {
+	server := httptest.NewServer(serve(newTestMutator(t).admit))
+	defer server.Close()
+
+	if patch := mutate(t, server, "no-gpu-mem.json"); len(patch) != 0 {
+		t.Errorf("expected the pod without gpu-mem not to be patched, got %v", patch)
+	}
+}
+
+func TestEnvValue(t *testing.T) {
+	tests := []struct {
+		template      string
+		memory        int64
+		gpuMemory     int64
+		expected      string
+		expectedFound bool
+	}{
+		{template: "{fraction}", memory: 4, gpuMemory: 16, expected: "0.25", expectedFound: true},
+		{template: "{percent}", memory: 1, gpuMemory: 300, expected: "1", expectedFound: true},
+		{template: "{fraction}", memory: 20, gpuMemory: 16, expected: "1.00", expectedFound: true},
+		// 0.00 would be no limit
+		{template: "{fraction}", memory: 1, gpuMemory: 300, expected: "0.01", expectedFound: true},
+		{template: "{fraction}", memory: 3, gpuMemory: 16, expected: "0.18", expectedFound: true},
+		{template: "{memory}GiB", memory: 4, expected: "4GiB", expectedFound: true},
+		{template: "{fraction}", memory: 4},
+	}
+
+	for _, test := range tests {
+		value, found := envValue(test.template, test.memory, test.gpuMemory)
+		if value != test.expected || found != test.expectedFound {
+			t.Errorf("%s with %d/%d: expected %q %v, got %q %v", test.template, test.memory, test.gpuMemory,
+				test.expected, test.expectedFound, value, found)
+		}
+	}
+}
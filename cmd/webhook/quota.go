@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	log "github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// gpu is one GPU in the cluster
+type gpu struct {
+	node  string
+	index int
+}
+
+// quotaChecker rejects the gpu-mem pods which can't fit in the quota of the namespace on any GPU,
+// the quota is set by the annotation aliyun.com/gpu-mem-max-per-gpu of the namespace.
+// The pods are checked when they're created, it's an early rejection: the GPU isn't known yet,
+// so the device plugin enforces the quota on the GPU the pod is assigned to before it starts.
+type quotaChecker struct {
+	namespaceLister corelisters.NamespaceLister
+	podLister       corelisters.PodLister
+	nodeLister      corelisters.NodeLister
+}
+
+// check returns the reason why the pod exceeds the quota, or empty if it doesn't
+func (q *quotaChecker) check(pod *v1.Pod) string {
+	ns, err := q.namespaceLister.Get(pod.Namespace)
+	if errors.IsNotFound(err) {
+		return ""
+	} else if err != nil {
+		log.Warningf("Failed to get ns %s due to %v, skip checking the quota", pod.Namespace, err)
+		return ""
+	}
+	quota, ok := maxMemoryPerGPU(ns)
+	if !ok {
+		return ""
+	}
+
+	var request int64
+	for _, c := range pod.Spec.Containers {
+		if mem, ok := c.Resources.Limits[gpushare.ResourceName]; ok {
+			request += mem.Value()
+		}
+	}
+
+	pods, err := q.podLister.Pods(ns.Name).List(labels.Everything())
+	if err != nil {
+		log.Warningf("Failed to list pods in ns %s due to %v, skip checking the quota", ns.Name, err)
+		return ""
+	}
+	nodes, err := q.nodeLister.List(labels.Everything())
+	if err != nil {
+		log.Warningf("Failed to list nodes due to %v, skip checking the quota", err)
+		return ""
+	}
+
+	// the gpu-mem of the pod and the plain quota are counted in the memory unit of each node
+	units := unitsOfNodes(nodes)
+	used := namespaceUsage(pods)
+	fits := false
+	for _, gpu := range gpusOfNodes(nodes) {
+		limit := quota.OnNode(units[gpu.node])
+		if request > limit {
+			continue
+		}
+		fits = true
+		if used[gpu]+request <= limit {
+			return ""
+		}
+	}
+	if !fits {
+		return fmt.Sprintf("the pod requests %d %s, but ns %s may use at most %s on any single GPU", request, gpushare.ResourceName, ns.Name, quota)
+	}
+	return fmt.Sprintf("the pod requests %d %s, but ns %s has used up its quota %s on every GPU", request, gpushare.ResourceName, ns.Name, quota)
+}
+
+// maxMemoryPerGPU returns the quota of the namespace on any single GPU
+func maxMemoryPerGPU(ns *v1.Namespace) (gpushare.MemoryQuota, bool) {
+	quota, ok, err := gpushare.MaxMemoryPerGPUOfNamespace(ns)
+	if err != nil {
+		log.Warningf("%v, ignore it", err)
+	}
+	return quota, ok
+}
+
+// namespaceUsage returns the gpu-mem used by the pods on each GPU, the pods which are not
+// assigned to any GPU yet are skipped
+func namespaceUsage(pods []*v1.Pod) map[gpu]int64 {
+	used := map[gpu]int64{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed ||
+			pod.DeletionTimestamp != nil {
+			continue
+		}
+		for index, mem := range podAllocation(pod) {
+			used[gpu{node: pod.Spec.NodeName, index: index}] += mem
+		}
+	}
+	return used
+}
+
+// podAllocation returns the gpu-mem of the pod on each GPU from the annotations set by the scheduler extender
+func podAllocation(pod *v1.Pod) map[int]int64 {
+	allocation := map[int]int64{}
+	if value, ok := pod.Annotations[gpushare.PodAnnotationAllocation]; ok {
+		containers := map[int]map[string]int64{}
+		if err := json.Unmarshal([]byte(value), &containers); err == nil {
+			for _, gpus := range containers {
+				for id, mem := range gpus {
+					if index, err := strconv.Atoi(id); err == nil {
+						allocation[index] += mem
+					}
+				}
+			}
+			return allocation
+		}
+	}
+
+	index, err := strconv.Atoi(pod.Annotations[gpushare.EnvResourceIndex])
+	if err != nil || index < 0 {
+		return allocation
+	}
+	for _, c := range pod.Spec.Containers {
+		if mem, ok := c.Resources.Limits[gpushare.ResourceName]; ok {
+			allocation[index] += mem.Value()
+		}
+	}
+	return allocation
+}
+
+// unitsOfNodes returns the MiB of the memory unit of each gpushare node
+func unitsOfNodes(nodes []*v1.Node) map[string]int64 {
+	units := map[string]int64{}
+	for _, node := range nodes {
+		mem, ok := node.Status.Allocatable[gpushare.ResourceName]
+		count, found := node.Status.Allocatable[gpushare.ResourceCount]
+		if !ok || !found || count.Value() <= 0 {
+			continue
+		}
+		unit, err := gpushare.MemoryUnitOfNode(node, mem.Value()/count.Value())
+		if err != nil {
+			log.Warningf("Skip node %s due to %v", node.Name, err)
+			continue
+		}
+		units[node.Name] = unit
+	}
+	return units
+}
+
+// gpusOfNodes returns the GPUs of the gpushare nodes
+func gpusOfNodes(nodes []*v1.Node) []gpu {
+	gpus := []gpu{}
+	for _, node := range nodes {
+		count, ok := node.Status.Allocatable[gpushare.ResourceCount]
+		if !ok {
+			continue
+		}
+		for i := 0; i < int(count.Value()); i++ {
+			gpus = append(gpus, gpu{node: node.Name, index: i})
+		}
+	}
+	return gpus
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newQuotaPod(name, nodeName string, mem int64, annotations map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a", Annotations: annotations},
+		Spec: v1.PodSpec{
+			NodeName: nodeName,
+			Containers: []v1.Container{{
+				Name: "main",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{gpushare.ResourceName: *resource.NewQuantity(mem, resource.DecimalSI)},
+				},
+			}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+func newQuotaChecker(t *testing.T, quota string, nodes map[string][2]int64, pods ...*v1.Pod) *quotaChecker {
+	namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, ns := range []*v1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Annotations: map[string]string{gpushare.NamespaceAnnotationMaxMemoryPerGPU: quota}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+	} {
+		if err := namespaces.Add(ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+	podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range pods {
+		if err := podIndexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &quotaChecker{
+		namespaceLister: corelisters.NewNamespaceLister(namespaces),
+		podLister:       corelisters.NewPodLister(podIndexer),
+		nodeLister:      newNodeLister(t, nodes),
+	}
+}
+
+func TestQuotaCheck(t *testing.T) {
+	tests := []struct {
+		name            string
+		quota           string
+		nodes           map[string][2]int64
+		pods            []*v1.Pod
+		pod             *v1.Pod
+		expectedMessage string
+	}{
+		{
+			name: "no quota",
+			pod:  &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "new", Namespace: "team-b"}},
+		},
+		{
+			name:            "larger than the quota",
+			pod:             newQuotaPod("new", "", 9, nil),
+			expectedMessage: "ns team-a may use at most 8 on any single GPU",
+		},
+		{
+			name: "fits in the second GPU",
+			pods: []*v1.Pod{
+				newQuotaPod("gpu0", "node1", 6, map[string]string{gpushare.EnvResourceIndex: "0"}),
+			},
+			pod: newQuotaPod("new", "", 4, nil),
+		},
+		{
+			name: "used up on every GPU",
+			pods: []*v1.Pod{
+				newQuotaPod("gpu0", "node1", 6, map[string]string{gpushare.EnvResourceIndex: "0"}),
+				newQuotaPod("gpu1", "node1", 5, map[string]string{gpushare.PodAnnotationAllocation: `{"0":{"1":5}}`}),
+			},
+			pod:             newQuotaPod("new", "", 4, nil),
+			expectedMessage: "ns team-a has used up its quota 8 on every GPU",
+		},
+		{
+			name: "finished and pending pods are not counted",
+			pods: []*v1.Pod{
+				func() *v1.Pod {
+					pod := newQuotaPod("done", "node1", 8, map[string]string{gpushare.EnvResourceIndex: "0"})
+					pod.Status.Phase = v1.PodSucceeded
+					return pod
+				}(),
+				newQuotaPod("pending", "", 8, nil),
+				newQuotaPod("gpu1", "node1", 8, map[string]string{gpushare.EnvResourceIndex: "1"}),
+			},
+			pod: newQuotaPod("new", "", 8, nil),
+		},
+		{
+			name:  "quota with the unit",
+			quota: "8GiB",
+			pods: []*v1.Pod{
+				newQuotaPod("gpu0", "node1", 6, map[string]string{gpushare.EnvResourceIndex: "0"}),
+				newQuotaPod("gpu1", "node1", 5, map[string]string{gpushare.EnvResourceIndex: "1"}),
+			},
+			pod:             newQuotaPod("new", "", 4, nil),
+			expectedMessage: "ns team-a has used up its quota 8192MiB on every GPU",
+		},
+		{
+			// the pod and the plain quota are counted in the same unit on every node
+			name:            "plain quota in the unit of each node",
+			nodes:           map[string][2]int64{"node1": {30, 2}, "node2": {15360, 1}},
+			pod:             newQuotaPod("new", "", 9, nil),
+			expectedMessage: "ns team-a may use at most 8 on any single GPU",
+		},
+		{
+			name:  "quota with the unit on the nodes in MiB",
+			quota: "8GiB",
+			nodes: map[string][2]int64{"node1": {30, 2}, "node2": {15360, 1}},
+			pods: []*v1.Pod{
+				newQuotaPod("gpu0", "node1", 6, map[string]string{gpushare.EnvResourceIndex: "0"}),
+				newQuotaPod("gpu1", "node1", 5, map[string]string{gpushare.EnvResourceIndex: "1"}),
+			},
+			pod: newQuotaPod("new", "", 4096, nil),
+		},
+	}
+
+	for _, test := range tests {
+		if test.quota == "" {
+			test.quota = "8"
+		}
+		if test.nodes == nil {
+			// one node with 2 GPUs of 15 GiB
+			test.nodes = map[string][2]int64{"node1": {30, 2}}
+		}
+		reason := newQuotaChecker(t, test.quota, test.nodes, test.pods...).check(test.pod)
+		if test.expectedMessage == "" && reason != "" {
+			t.Errorf("%s: expected the pod to fit, got %q", test.name, reason)
+		}
+		if !strings.Contains(reason, test.expectedMessage) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expectedMessage, reason)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	log "github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// validator rejects the gpu-mem pods which the device plugin can't serve
+type validator struct {
+	// nodeLister lists the nodes to find the largest GPU, the check is skipped if it's nil
+	nodeLister corelisters.NodeLister
+	// quota checks the gpu-mem quota of the namespaces, it's skipped if it's nil
+	quota *quotaChecker
+}
+
+func (v *validator) admit(pod *v1.Pod) *AdmissionResponse {
+	if !requestsGPUMemory(pod) {
+		return allowed()
+	}
+
+	var gpus []gpuSize
+	if v.nodeLister != nil {
+		nodes, err := v.nodeLister.List(labels.Everything())
+		if err != nil {
+			log.Warningf("Failed to list nodes due to %v, skip checking the GPU memory", err)
+		} else {
+			gpus = gpuSizesOfNodes(nodes)
+		}
+	}
+
+	if errs := validatePod(pod, gpus); len(errs) > 0 {
+		log.Infof("Denied pod %s in ns %s: %s", podName(pod), pod.Namespace, strings.Join(errs, "; "))
+		return denied(strings.Join(errs, "; "))
+	}
+	if v.quota != nil {
+		if reason := v.quota.check(pod); reason != "" {
+			log.Infof("Denied pod %s in ns %s: %s", podName(pod), pod.Namespace, reason)
+			return denied(reason)
+		}
+	}
+
+	return allowed()
+}
+
+// validatePod returns the reasons why the device plugin can't serve the pod, the GPU memory
+// is not checked if there are no GPUs
+func validatePod(pod *v1.Pod, gpus []gpuSize) []string {
+	errs := []string{}
+
+	hasNvidiaGPU := false
+	for _, c := range pod.Spec.InitContainers {
+		if _, ok := c.Resources.Limits[gpushare.NvidiaGPUResourceName]; ok {
+			hasNvidiaGPU = true
+		}
+		if _, ok := c.Resources.Limits[gpushare.ResourceName]; ok {
+			errs = append(errs, fmt.Sprintf("init container %s: %s is not supported in init containers", c.Name, gpushare.ResourceName))
+		} else if _, ok := c.Resources.Requests[gpushare.ResourceName]; ok {
+			errs = append(errs, fmt.Sprintf("init container %s: %s is not supported in init containers", c.Name, gpushare.ResourceName))
+		}
+	}
+
+	var total int64
+	for _, c := range pod.Spec.Containers {
+		limit, hasLimit := c.Resources.Limits[gpushare.ResourceName]
+		request, hasRequest := c.Resources.Requests[gpushare.ResourceName]
+		if hasRequest && !hasLimit {
+			errs = append(errs, fmt.Sprintf("container %s: %s is set in requests only, set it in limits", c.Name, gpushare.ResourceName))
+		}
+		if hasRequest && hasLimit && request.Cmp(limit) != 0 {
+			errs = append(errs, fmt.Sprintf("container %s: the request %s of %s should equal the limit %s", c.Name, request.String(), gpushare.ResourceName, limit.String()))
+		}
+		if hasLimit {
+			total += limit.Value()
+		}
+		if _, ok := c.Resources.Limits[gpushare.NvidiaGPUResourceName]; ok {
+			hasNvidiaGPU = true
+		}
+	}
+
+	if hasNvidiaGPU {
+		errs = append(errs, fmt.Sprintf("%s and %s can't be requested by the same pod", gpushare.ResourceName, gpushare.NvidiaGPUResourceName))
+	}
+	// all the containers of the pod share one GPU
+	if len(gpus) > 0 && !fitsAnyGPU(total, gpus) {
+		largest := largestGPU(gpus)
+		errs = append(errs, fmt.Sprintf("the pod requests %d %s in total, which is larger than any GPU in the cluster (the largest GPU has %dMiB, %d %s)",
+			total, gpushare.ResourceName, largest.mib, largest.gpuMemory, gpushare.ResourceName))
+	}
+
+	return errs
+}
+
+// requestsGPUMemory returns true if any container of the pod requests gpu-mem
+func requestsGPUMemory(pod *v1.Pod) bool {
+	containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		if _, ok := c.Resources.Limits[gpushare.ResourceName]; ok {
+			return true
+		}
+		if _, ok := c.Resources.Requests[gpushare.ResourceName]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// gpuSize is the memory of one GPU of a node, in the gpu-mem of the node and in MiB, unitMiB
+// is the MiB of the memory unit of the node
+type gpuSize struct {
+	gpuMemory int64
+	mib       int64
+	unitMiB   int64
+}
+
+// gpuSizesOfNodes returns the size of one GPU of each node, the gpu-mem of the nodes may be
+// counted in different memory units, so it's normalized to MiB by the unit of the node
+func gpuSizesOfNodes(nodes []*v1.Node) []gpuSize {
+	gpus := []gpuSize{}
+	for _, node := range nodes {
+		mem, ok := node.Status.Allocatable[gpushare.ResourceName]
+		if !ok {
+			continue
+		}
+		count, ok := node.Status.Allocatable[gpushare.ResourceCount]
+		if !ok || count.Value() <= 0 {
+			continue
+		}
+		perGPU := mem.Value() / count.Value()
+		unit, err := gpushare.MemoryUnitOfNode(node, perGPU)
+		if err != nil {
+			log.Warningf("Skip node %s due to %v", node.Name, err)
+			continue
+		}
+		gpus = append(gpus, gpuSize{gpuMemory: perGPU, mib: perGPU * unit, unitMiB: unit})
+	}
+
+	return gpus
+}
+
+// largestGPU returns the GPU with the most MiB
+func largestGPU(gpus []gpuSize) gpuSize {
+	largest := gpuSize{}
+	for _, gpu := range gpus {
+		if gpu.mib > largest.mib {
+			largest = gpu
+		}
+	}
+	return largest
+}
+
+// fitsAnyGPU returns true if the gpu-mem fits one GPU of any node. The gpu-mem of the pod is
+// counted in the memory unit of the node it's scheduled to, so it's converted to MiB by the
+// unit of each node and compared with the MiB of the GPU.
+func fitsAnyGPU(gpuMemory int64, gpus []gpuSize) bool {
+	for _, gpu := range gpus {
+		if gpuMemory*gpu.unitMiB <= gpu.mib {
+			return true
+		}
+	}
+	return false
+}
+
+// podName returns the generate name if the pod isn't named yet
+func podName(pod *v1.Pod) string {
+	if pod.Name == "" {
+		return pod.GenerateName
+	}
+	return pod.Name
+}
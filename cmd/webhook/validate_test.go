@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newNodeLister returns the lister of the nodes with the GPU memory and count
+func newNodeLister(t *testing.T, gpus map[string][2]int64) corelisters.NodeLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, gpu := range gpus {
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Allocatable: v1.ResourceList{
+					gpushare.ResourceName:  *resource.NewQuantity(gpu[0], resource.DecimalSI),
+					gpushare.ResourceCount: *resource.NewQuantity(gpu[1], resource.DecimalSI),
+				},
+			},
+		}
+		if err := indexer.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return corelisters.NewNodeLister(indexer)
+}
+
+func readReview(t *testing.T, file string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestValidate(t *testing.T) {
+	// the largest GPU has 15 GiB
+	v := &validator{nodeLister: newNodeLister(t, map[string][2]int64{
+		"node1": {30, 2},
+		"node2": {28, 4},
+	})}
+	server := httptest.NewServer(serve(v.admit))
+	defer server.Close()
+
+	tests := []struct {
+		file            string
+		expectedAllowed bool
+		expectedMessage string
+	}{
+		{file: "valid.json", expectedAllowed: true},
+		{file: "no-gpu-mem.json", expectedAllowed: true},
+		{file: "requests-only.json", expectedMessage: "container main: aliyun.com/gpu-mem is set in requests only"},
+		{file: "init-container.json", expectedMessage: "init container init: aliyun.com/gpu-mem is not supported in init containers"},
+		{file: "too-large.json", expectedMessage: "the pod requests 16 aliyun.com/gpu-mem in total, which is larger than any GPU in the cluster (the largest GPU has 15360MiB, 15 aliyun.com/gpu-mem)"},
+		{file: "nvidia-gpu.json", expectedMessage: "aliyun.com/gpu-mem and nvidia.com/gpu can't be requested by the same pod"},
+	}
+
+	for _, test := range tests {
+		resp, err := http.Post(server.URL, "application/json", bytes.NewReader(readReview(t, test.file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ar := &AdmissionReview{}
+		err = json.NewDecoder(resp.Body).Decode(ar)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: failed to decode the response: %v", test.file, err)
+		}
+
+		if ar.Response == nil {
+			t.Fatalf("%s: no response", test.file)
+		}
+		if ar.Response.UID != "705ab4f5-6393-11e8-b7cc-42010a800002" {
+			t.Errorf("%s: expected the uid of the request, got %s", test.file, ar.Response.UID)
+		}
+		if ar.Response.Allowed != test.expectedAllowed {
+			t.Errorf("%s: expected allowed %v, got %v", test.file, test.expectedAllowed, ar.Response.Allowed)
+		}
+		if test.expectedMessage != "" {
+			if ar.Response.Result == nil || !strings.Contains(ar.Response.Result.Message, test.expectedMessage) {
+				t.Errorf("%s: expected message %q, got %+v", test.file, test.expectedMessage, ar.Response.Result)
+			}
+		}
+	}
+}
+
+func TestValidateWithoutNodes(t *testing.T) {
+	v := &validator{}
+	ar := &AdmissionReview{}
+	if err := json.Unmarshal(readReview(t, "too-large.json"), ar); err != nil {
+		t.Fatal(err)
+	}
+	if resp := review(ar, v.admit).Response; !resp.Allowed {
+		t.Errorf("expected the GPU memory not to be checked without the nodes, got %+v", resp.Result)
+	}
+}
+
+func TestServeRejectsContentType(t *testing.T) {
+	server := httptest.NewServer(serve((&validator{}).admit))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "text/plain", bytes.NewReader(readReview(t, "valid.json")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status %d, got %d", http.StatusUnsupportedMediaType, resp.StatusCode)
+	}
+}
+
+func TestGPUSizesOfNodes(t *testing.T) {
+	node := func(name, unit string, mem, count int64) *v1.Node {
+		n := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Allocatable: v1.ResourceList{
+					gpushare.ResourceName:  *resource.NewQuantity(mem, resource.DecimalSI),
+					gpushare.ResourceCount: *resource.NewQuantity(count, resource.DecimalSI),
+				},
+			},
+		}
+		if unit != "" {
+			n.Annotations = map[string]string{gpushare.NodeAnnotationMemoryUnit: unit}
+		}
+		return n
+	}
+	nodes := []*v1.Node{
+		// 15 GiB guessed by the gpu-mem without the unit
+		node("gib", "", 30, 2),
+		// 16 GiB in 256MiB
+		node("256mib", "256MiB", 128, 2),
+		// 15 GiB guessed as MiB
+		node("mib", "", 15360, 1),
+		node("invalid", "2TiB", 16, 1),
+	}
+
+	gpus := gpuSizesOfNodes(nodes)
+	expected := []gpuSize{{gpuMemory: 15, mib: 15360, unitMiB: 1024}, {gpuMemory: 64, mib: 16384, unitMiB: 256}, {gpuMemory: 15360, mib: 15360, unitMiB: 1}}
+	if !reflect.DeepEqual(gpus, expected) {
+		t.Fatalf("expected GPUs %v, got %v", expected, gpus)
+	}
+	if largest := largestGPU(gpus); largest != expected[1] {
+		t.Errorf("expected the largest GPU of 256MiB, got %v", largest)
+	}
+	// the pod is counted in the unit of the node it's scheduled to
+	if !fitsAnyGPU(64, gpus) || fitsAnyGPU(15361, gpus) {
+		t.Errorf("expected 64 gpu-mem to fit and 15361 not")
+	}
+}
+
+func TestValidateNvidiaGPUInInitContainers(t *testing.T) {
+	pod := &v1.Pod{Spec: v1.PodSpec{
+		InitContainers: []v1.Container{{
+			Name: "init",
+			Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{gpushare.NvidiaGPUResourceName: *resource.NewQuantity(1, resource.DecimalSI)},
+			},
+		}},
+		Containers: []v1.Container{{
+			Name: "main",
+			Resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{gpushare.ResourceName: *resource.NewQuantity(2, resource.DecimalSI)},
+			},
+		}},
+	}}
+
+	errs := validatePod(pod, nil)
+	if len(errs) != 1 || !strings.Contains(errs[0], "can't be requested by the same pod") {
+		t.Errorf("expected nvidia.com/gpu in the init container to be rejected, got %v", errs)
+	}
+}
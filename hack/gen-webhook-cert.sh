@@ -0,0 +1,49 @@
+#!/usr/bin/env bash
+# Generates the serving certificate of gpushare-webhook signed by a self-signed CA, stores it
+# in the secret gpushare-webhook-tls and sets the caBundle of the webhook configurations.
+# Run it after applying gpushare-webhook.yaml, and again before the certificate expires.
+# Use cert-manager instead if it's installed, see gpushare-webhook.yaml.
+set -o errexit
+set -o nounset
+set -o pipefail
+
+SERVICE=gpushare-webhook
+NAMESPACE=kube-system
+DAYS=${DAYS:-3650}
+
+tmpdir=$(mktemp -d)
+trap 'rm -rf "${tmpdir}"' EXIT
+
+openssl req -x509 -newkey rsa:2048 -nodes -days "${DAYS}" \
+  -keyout "${tmpdir}/ca.key" -out "${tmpdir}/ca.crt" -subj "/CN=${SERVICE}-ca"
+
+cat > "${tmpdir}/csr.conf" <<CONF
+[req]
+distinguished_name = req_distinguished_name
+req_extensions = v3_req
+[req_distinguished_name]
+[v3_req]
+basicConstraints = CA:FALSE
+keyUsage = digitalSignature, keyEncipherment
+extendedKeyUsage = serverAuth
+subjectAltName = DNS:${SERVICE},DNS:${SERVICE}.${NAMESPACE},DNS:${SERVICE}.${NAMESPACE}.svc
+CONF
+
+openssl req -newkey rsa:2048 -nodes -keyout "${tmpdir}/tls.key" -out "${tmpdir}/tls.csr" \
+  -subj "/CN=${SERVICE}.${NAMESPACE}.svc" -config "${tmpdir}/csr.conf"
+openssl x509 -req -in "${tmpdir}/tls.csr" -CA "${tmpdir}/ca.crt" -CAkey "${tmpdir}/ca.key" \
+  -CAcreateserial -days "${DAYS}" -extensions v3_req -extfile "${tmpdir}/csr.conf" -out "${tmpdir}/tls.crt"
+
+kubectl -n "${NAMESPACE}" delete secret "${SERVICE}-tls" --ignore-not-found
+kubectl -n "${NAMESPACE}" create secret tls "${SERVICE}-tls" \
+  --cert="${tmpdir}/tls.crt" --key="${tmpdir}/tls.key"
+
+ca_bundle=$(base64 < "${tmpdir}/ca.crt" | tr -d '\n')
+for kind in validatingwebhookconfiguration mutatingwebhookconfiguration; do
+  kubectl patch "${kind}" "${SERVICE}" --type=json \
+    -p "[{\"op\": \"replace\", \"path\": \"/webhooks/0/clientConfig/caBundle\", \"value\": \"${ca_bundle}\"}]"
+done
+
+# the webhook reads the certificate on start
+kubectl -n "${NAMESPACE}" rollout restart deployment "${SERVICE}" 2>/dev/null ||
+  kubectl -n "${NAMESPACE}" delete pod -l component="${SERVICE}"
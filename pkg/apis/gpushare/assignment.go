@@ -0,0 +1,36 @@
+package gpushare
+
+import (
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The ConfigMaps of the assigned pods written by the device plugin with --assignment-store=configmap,
+// one for each node
+const (
+	// AssignmentConfigMapLabel selects the ConfigMaps of the assigned pods of all the nodes
+	AssignmentConfigMapLabel = "gpushare.aliyun.com/assignments"
+	// AssignmentConfigMapKey is the key of the AssignmentRecord in the ConfigMap
+	AssignmentConfigMapKey = "assignments.json"
+	// AssignmentConfigMapPrefix is the prefix of the ConfigMap name, followed by the node name
+	AssignmentConfigMapPrefix    = "gpushare-assignments-"
+	AssignmentConfigMapNamespace = "kube-system"
+	AssignmentRecordVersion      = "v1"
+)
+
+// Assignment is the GPU assigned to the pod in Allocate
+type Assignment struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	UID       types.UID `json:"uid"`
+	Index     uint      `json:"index"`
+	// GPUMemory is the GPU memory requested by the pod in the memory unit
+	GPUMemory  uint  `json:"gpuMemory"`
+	AssignTime int64 `json:"assignTime"`
+}
+
+// AssignmentRecord is the content of the assignment file and ConfigMap
+type AssignmentRecord struct {
+	Version     string       `json:"version"`
+	Node        string       `json:"node"`
+	Assignments []Assignment `json:"assignments"`
+}
@@ -0,0 +1,33 @@
+// Package gpushare holds the names shared by the device plugin, the webhook and the tools,
+// and the helpers to read them from the pods
+package gpushare
+
+const (
+	// ResourceName is the GPU memory resource, it's counted in the memory unit of the node
+	ResourceName = "aliyun.com/gpu-mem"
+	// ResourceCount is the number of the GPUs on the node
+	ResourceCount = "aliyun.com/gpu-count"
+	// NvidiaGPUResourceName is the whole GPU resource of the nvidia device plugin
+	NvidiaGPUResourceName = "nvidia.com/gpu"
+
+	// The annotations of the pod set by the scheduler extender and the device plugin
+	EnvResourceIndex      = "ALIYUN_COM_GPU_MEM_IDX"
+	EnvAssignedFlag       = "ALIYUN_COM_GPU_MEM_ASSIGNED"
+	EnvResourceAssumeTime = "ALIYUN_COM_GPU_MEM_ASSUME_TIME"
+	// PodAnnotationAllocation is the gpu-mem of each container on each GPU set by the scheduler extender,
+	// in the form of {"<container index>": {"<gpu index>": <gpu-mem>}}
+	PodAnnotationAllocation = "scheduler.framework.gpushare.allocation"
+
+	// AssignedFlagExpired marks the assumed pod which is not allocated in time,
+	// so it's no longer a candidate of Allocate
+	AssignedFlagExpired = "expired"
+
+	// NodeAnnotationMemoryUnit is the memory unit of the node published by the device plugin
+	NodeAnnotationMemoryUnit = "aliyun.com/gpu-mem-unit"
+	// NodeAnnotationMemoryUsage is the JSON of the MemoryUsage of the node published by the device plugin
+	NodeAnnotationMemoryUsage = "aliyun.com/gpu-mem-usage"
+
+	// NamespaceAnnotationMaxMemoryPerGPU caps the gpu-mem the pods of the namespace may use on any single GPU,
+	// it's a plain number in the memory unit of each node, or a number with GiB or MiB
+	NamespaceAnnotationMaxMemoryPerGPU = "aliyun.com/gpu-mem-max-per-gpu"
+)
@@ -0,0 +1,53 @@
+package gpushare
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// MemoryQuota is the gpu-mem the pods of a namespace may use on any single GPU. A plain number
+// such as "4" is counted in the memory unit of each node, so it's 4GiB on the nodes in GiB and
+// 4MiB on the nodes in MiB. A number with the unit such as "4GiB" or "512MiB" is the same on
+// every node.
+type MemoryQuota struct {
+	// Limit is the plain number, it's 0 if the quota is set with the unit
+	Limit int64
+	// MiB is the quota set with the unit in MiB
+	MiB int64
+}
+
+// OnNode returns the quota in the gpu-mem of the node with the memory unit of unitMiB
+func (q MemoryQuota) OnNode(unitMiB int64) int64 {
+	if q.MiB == 0 || unitMiB <= 0 {
+		return q.Limit
+	}
+	return q.MiB / unitMiB
+}
+
+func (q MemoryQuota) String() string {
+	if q.MiB == 0 {
+		return strconv.FormatInt(q.Limit, 10)
+	}
+	return fmt.Sprintf("%dMiB", q.MiB)
+}
+
+// MaxMemoryPerGPUOfNamespace returns the gpu-mem the pods of the namespace may use on any single GPU,
+// ok is false if NamespaceAnnotationMaxMemoryPerGPU isn't set
+func MaxMemoryPerGPUOfNamespace(ns *v1.Namespace) (quota MemoryQuota, ok bool, err error) {
+	value, ok := ns.Annotations[NamespaceAnnotationMaxMemoryPerGPU]
+	if !ok {
+		return MemoryQuota{}, false, nil
+	}
+	if strings.HasSuffix(value, "GiB") || strings.HasSuffix(value, "MiB") {
+		quota.MiB, err = MemoryUnitInMiB(value)
+	} else {
+		quota.Limit, err = strconv.ParseInt(value, 10, 64)
+	}
+	if err != nil || quota.Limit < 0 {
+		return MemoryQuota{}, false, fmt.Errorf("invalid %s %q of ns %s", NamespaceAnnotationMaxMemoryPerGPU, value, ns.Name)
+	}
+	return quota, true, nil
+}
@@ -0,0 +1,57 @@
+package gpushare
+
+import (
+	"fmt"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// GPUMemoryOfPod returns the gpu-mem requested by the containers of the pod
+func GPUMemoryOfPod(pod *v1.Pod) uint {
+	var total uint
+	for _, container := range pod.Spec.Containers {
+		if val, ok := container.Resources.Limits[ResourceName]; ok {
+			total += uint(val.Value())
+		}
+	}
+	return total
+}
+
+// AssumeTime returns the time in nanoseconds when the scheduler extender assumed the pod,
+// it's 0 if the pod is not assumed
+func AssumeTime(pod *v1.Pod) (uint64, error) {
+	value, ok := pod.Annotations[EnvResourceAssumeTime]
+	if !ok {
+		return 0, nil
+	}
+	assumeTime, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid assume time %q: %v", value, err)
+	}
+	return assumeTime, nil
+}
+
+// AssumedPodStatus tells if the pod is assumed by the scheduler extender but not assigned by
+// the device plugin yet, which makes it a candidate of Allocate, and the reason if it's not
+func AssumedPodStatus(pod *v1.Pod) (assumed bool, reason string) {
+	// 1. Check if it's for GPU share
+	if GPUMemoryOfPod(pod) <= 0 {
+		return false, "it doesn't request " + ResourceName
+	}
+
+	// 2. Check if it already has assume time
+	if _, ok := pod.Annotations[EnvResourceAssumeTime]; !ok {
+		return false, "it has no assume time"
+	}
+
+	// 3. Check if it has been assigned already
+	assigned, ok := pod.Annotations[EnvAssignedFlag]
+	if !ok {
+		return false, "it has no assigned flag"
+	}
+	if assigned != "false" {
+		return false, fmt.Sprintf("its assigned flag is %s", assigned)
+	}
+	return true, "it's assumed but not assigned"
+}
@@ -0,0 +1,47 @@
+package gpushare
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// MemoryUnitInMiB returns the MiB of the memory unit published in NodeAnnotationMemoryUnit,
+// e.g. 1024 for GiB and 256 for 256MiB. Besides GiB and MiB, it accepts a multiple of them such
+// as 128MiB or 2GiB. It's the only parser of the memory units, the device plugin uses it too.
+func MemoryUnitInMiB(unit string) (int64, error) {
+	var number string
+	var size int64
+	switch {
+	case strings.HasSuffix(unit, "GiB"):
+		number, size = strings.TrimSuffix(unit, "GiB"), 1024
+	case strings.HasSuffix(unit, "MiB"):
+		number, size = strings.TrimSuffix(unit, "MiB"), 1
+	default:
+		return 0, fmt.Errorf("unsupported memory unit %q, it should end with GiB or MiB", unit)
+	}
+	if number == "" {
+		return size, nil
+	}
+
+	n, err := strconv.ParseUint(number, 10, 32)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("unsupported memory unit %q, the block size should be a positive integer", unit)
+	}
+	return int64(n) * size, nil
+}
+
+// MemoryUnitOfNode returns the MiB of the memory unit of the node. The nodes of the old device
+// plugins don't publish it, they're guessed by the gpu-mem of one GPU as kubectl-inspect-gpushare
+// does: MiB if it's more than 100, otherwise GiB.
+func MemoryUnitOfNode(node *v1.Node, gpuMemoryPerGPU int64) (int64, error) {
+	if unit, ok := node.Annotations[NodeAnnotationMemoryUnit]; ok && unit != "" {
+		return MemoryUnitInMiB(unit)
+	}
+	if gpuMemoryPerGPU > 100 {
+		return 1, nil
+	}
+	return 1024, nil
+}
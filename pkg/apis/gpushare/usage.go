@@ -0,0 +1,60 @@
+package gpushare
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// MemoryUsage is the GPU memory used by the processes on a node, published by the device plugin
+// in the node annotation NodeAnnotationMemoryUsage. The memory is counted in MemoryUnit, unlike
+// the allocated gpu-mem it's not a whole number of the memory unit.
+type MemoryUsage struct {
+	MemoryUnit string           `json:"memoryUnit"`
+	GPUs       []GPUMemoryUsage `json:"gpus"`
+}
+
+// GPUMemoryUsage is the GPU memory used on a GPU
+type GPUMemoryUsage struct {
+	Index int `json:"index"`
+	// Used is the memory used by all the processes on the GPU, including the ones outside of the pods
+	Used float64 `json:"used"`
+	// Pods is the memory used by the processes of the pods, keyed by the pod UID
+	Pods map[types.UID]float64 `json:"pods,omitempty"`
+}
+
+// MemoryUsageOfNode returns the GPU memory usage published on the node, it's nil if there is none
+func MemoryUsageOfNode(node *v1.Node) (*MemoryUsage, error) {
+	value, ok := node.Annotations[NodeAnnotationMemoryUsage]
+	if !ok {
+		return nil, nil
+	}
+	usage := &MemoryUsage{}
+	if err := json.Unmarshal([]byte(value), usage); err != nil {
+		return nil, fmt.Errorf("invalid GPU memory usage %q: %v", value, err)
+	}
+	return usage, nil
+}
+
+// UsedOfGPU returns the memory used on the GPU of the index
+func (u *MemoryUsage) UsedOfGPU(index int) (float64, bool) {
+	for _, gpu := range u.GPUs {
+		if gpu.Index == index {
+			return gpu.Used, true
+		}
+	}
+	return 0, false
+}
+
+// UsedOfPod returns the memory used by the pod on the GPU of the index, or on all the GPUs if the index is -1
+func (u *MemoryUsage) UsedOfPod(uid types.UID, index int) float64 {
+	var used float64
+	for _, gpu := range u.GPUs {
+		if index == -1 || gpu.Index == index {
+			used += gpu.Pods[uid]
+		}
+	}
+	return used
+}
@@ -0,0 +1,63 @@
+// Package v1alpha1 is the GPUShareNode API reported by the device plugin on each node
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	GroupName = "gpushare.aliyun.com"
+	Version   = "v1alpha1"
+	Kind      = "GPUShareNode"
+	// Resource is the plural name of GPUShareNode, it's cluster scoped and named after the node
+	Resource = "gpusharenodes"
+)
+
+// GPUShareNode reports the GPUs of the node and the pods allocated on them
+type GPUShareNode struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status GPUShareNodeStatus `json:"status,omitempty"`
+}
+
+// GPUShareNodeStatus is the status of the GPUs on the node
+type GPUShareNodeStatus struct {
+	// MemoryUnit is the unit of the GPU memory, e.g. GiB
+	MemoryUnit string `json:"memoryUnit"`
+	// GPUs are ordered by the index
+	GPUs []GPUStatus `json:"gpus"`
+	// PendingPods are bound to the node but not assigned to any GPU yet
+	PendingPods []PodAllocation `json:"pendingPods,omitempty"`
+	UpdateTime  metav1.Time     `json:"updateTime"`
+}
+
+// GPUStatus is the allocation of one GPU
+type GPUStatus struct {
+	Index uint   `json:"index"`
+	UUID  string `json:"uuid"`
+	Model string `json:"model"`
+	// TotalMemory is the allocatable GPU memory, the reserved memory is excluded
+	TotalMemory uint `json:"totalMemory"`
+	// AllocatedMemory is the GPU memory requested by the assigned pods
+	AllocatedMemory uint            `json:"allocatedMemory"`
+	Healthy         bool            `json:"healthy"`
+	Pods            []PodAllocation `json:"pods,omitempty"`
+}
+
+// PodAllocation is the GPU memory allocated to the pod
+type PodAllocation struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	UID       types.UID `json:"uid"`
+	GPUMemory uint      `json:"gpuMemory"`
+}
+
+// GPUShareNodeList is the list of GPUShareNode
+type GPUShareNodeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []GPUShareNode `json:"items"`
+}
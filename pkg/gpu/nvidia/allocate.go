@@ -16,21 +16,17 @@ var (
 	lastAllocateTime time.Time
 )
 
-// create docker client
-func init() {
-	kubeInit()
-}
-
-func buildErrResponse(reqs *pluginapi.AllocateRequest, podReqGPU uint) *pluginapi.AllocateResponse {
+func buildErrResponse(reqs *pluginapi.AllocateRequest, podReqGPU uint, unit MemoryUnit) *pluginapi.AllocateResponse {
 	responses := pluginapi.AllocateResponse{}
 	for _, req := range reqs.ContainerRequests {
 		response := pluginapi.ContainerAllocateResponse{
 			Envs: map[string]string{
-				envNVGPU:               fmt.Sprintf("no-gpu-has-%d%s-to-run", podReqGPU, metric),
+				envNVGPU:               fmt.Sprintf("no-gpu-has-%s-to-run", unit.Quantity(podReqGPU)),
 				EnvResourceIndex:       fmt.Sprintf("-1"),
 				EnvResourceByPod:       fmt.Sprintf("%d", podReqGPU),
 				EnvResourceByContainer: fmt.Sprintf("%d", uint(len(req.DevicesIDs))),
 				EnvResourceByDev:       fmt.Sprintf("%d", getGPUMemory()),
+				EnvResourceUnit:        string(unit),
 			},
 		}
 		responses.ContainerResponses = append(responses.ContainerResponses, &response)
@@ -38,35 +34,22 @@ func buildErrResponse(reqs *pluginapi.AllocateRequest, podReqGPU uint) *pluginap
 	return &responses
 }
 
-// Allocate which return list of devices.
-func (m *NvidiaDevicePlugin) Allocate(ctx context.Context,
-	reqs *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
-	responses := pluginapi.AllocateResponse{}
-
-	log.Infoln("----Allocating GPU for gpu mem is started----")
-	var (
-		podReqGPU uint
-		found     bool
-		assumePod *v1.Pod
-	)
-
-	// podReqGPU = uint(0)
-	for _, req := range reqs.ContainerRequests {
-		podReqGPU += uint(len(req.DevicesIDs))
-	}
-	log.Infof("RequestPodGPUs: %d", podReqGPU)
+// podClaim is the pod picked by Allocate. The pod is claimed before it's assigned, so the other
+// Allocates never pick it while the store is updated or the pods are listed before it.
+type podClaim struct {
+	// assignTime is zero until the pod is assigned
+	assignTime time.Time
+}
 
-	m.Lock()
-	defer m.Unlock()
-	log.Infoln("checking...")
-	pods, err := getCandidatePods(m.queryKubelet, m.kubeletClient)
-	if err != nil {
-		log.Infof("invalid allocation requst: Failed to find candidate pods due to %v", err)
-		return buildErrResponse(reqs, podReqGPU), nil
-	}
+// claimPod picks the pod requesting podReqGPU and the GPU of it, and claims the pod. The assumed pod
+// is assigned to the GPU picked by the scheduler extender, and on the node with only one GPU, the pod
+// which isn't assumed is assigned to the GPU. The pods are listed at the time, it's called with the lock held.
+func (m *NvidiaDevicePlugin) claimPod(pods []v1.Pod, podReqGPU uint, listed time.Time) (*v1.Pod, uint, error) {
+	m.pruneClaims(pods, listed)
 
+	candidates := candidatePods(pods)
 	if log.V(4) {
-		for _, pod := range pods {
+		for _, pod := range candidates {
 			log.Infof("Pod %s in ns %s request GPU Memory %d with timestamp %v",
 				pod.Name,
 				pod.Namespace,
@@ -75,120 +58,159 @@ func (m *NvidiaDevicePlugin) Allocate(ctx context.Context,
 		}
 	}
 
-	for _, pod := range pods {
-		if getGPUMemoryFromPodResource(pod) == podReqGPU {
-			log.Infof("Found Assumed GPU shared Pod %s in ns %s with GPU Memory %d",
-				pod.Name,
-				pod.Namespace,
-				podReqGPU)
-			assumePod = pod
-			found = true
-			break
+	for _, pod := range candidates {
+		if getGPUMemoryFromPodResource(pod) != podReqGPU || m.store.IsAssigned(pod) || m.claims[pod.UID] != nil {
+			continue
 		}
-	}
+		log.Infof("Found Assumed GPU shared Pod %s in ns %s with GPU Memory %d",
+			pod.Name,
+			pod.Namespace,
+			podReqGPU)
 
-	if found {
-		id := getGPUIDFromPodAnnotation(assumePod)
+		id := getGPUIDFromPodAnnotation(pod)
 		if id < 0 {
-			log.Warningf("Failed to get the dev ", assumePod)
+			return nil, 0, fmt.Errorf("failed to get the dev for pod %s in ns %s", pod.Name, pod.Namespace)
 		}
-
-		candidateDevID := ""
-		if id >= 0 {
-			ok := false
-			candidateDevID, ok = m.GetDeviceNameByIndex(uint(id))
-			if !ok {
-				log.Warningf("Failed to find the dev for pod %v because it's not able to find dev with index %d",
-					assumePod,
-					id)
-				id = -1
-			}
+		if _, ok := m.GetDeviceNameByIndex(uint(id)); !ok {
+			return nil, 0, fmt.Errorf("failed to find the dev with index %d for pod %s in ns %s", id, pod.Name, pod.Namespace)
 		}
+		m.claims[pod.UID] = &podClaim{}
+		return pod, uint(id), nil
+	}
 
-		if id < 0 {
-			return buildErrResponse(reqs, podReqGPU), nil
+	if len(m.devNameMap) == 1 {
+		var devIndex uint
+		for _, index := range m.devNameMap {
+			devIndex = index
 		}
-		log.Infof("gpu index %v,uuid: %v", id, candidateDevID)
-		// 1. Create container requests
-		for _, req := range reqs.ContainerRequests {
-			reqGPU := uint(len(req.DevicesIDs))
-			response := pluginapi.ContainerAllocateResponse{
-				Envs: map[string]string{
-					envNVGPU:               fmt.Sprintf("%v", id),
-					EnvResourceIndex:       fmt.Sprintf("%d", id),
-					EnvResourceByPod:       fmt.Sprintf("%d", podReqGPU),
-					EnvResourceByContainer: fmt.Sprintf("%d", reqGPU),
-					EnvResourceByDev:       fmt.Sprintf("%d", getGPUMemory()),
-				},
-			}
-			if m.disableCGPUIsolation {
-				response.Envs["CGPU_DISABLE"] = "true"
+		for _, pod := range unassumedPods(pods) {
+			if getGPUMemoryFromPodResource(pod) != podReqGPU || m.store.IsAssigned(pod) || m.claims[pod.UID] != nil {
+				continue
 			}
-			responses.ContainerResponses = append(responses.ContainerResponses, &response)
+			log.Infof("this node has only one gpu device, assign pod %s in ns %s which isn't assumed to the device %d",
+				pod.Name,
+				pod.Namespace,
+				devIndex)
+			m.claims[pod.UID] = &podClaim{}
+			return pod, devIndex, nil
 		}
+	}
+
+	return nil, 0, fmt.Errorf("request GPU memory %d can't be satisfied", podReqGPU)
+}
+
+// releaseClaim releases the claim of the pod if it failed to be assigned, or records the assign time.
+// It's called with the lock held.
+func (m *NvidiaDevicePlugin) releaseClaim(uid types.UID, assigned bool) {
+	if !assigned {
+		delete(m.claims, uid)
+		return
+	}
+	if c, ok := m.claims[uid]; ok {
+		c.assignTime = time.Now()
+	}
+}
 
-		// 2. Update Pod spec
-		patchedAnnotationBytes, err := patchPodAnnotationSpecAssigned()
-		if err != nil {
-			return buildErrResponse(reqs, podReqGPU), nil
+// pruneClaims removes the claims of the assigned pods which the pods listed after the assignment
+// show as assigned or gone, it's called with the lock held
+func (m *NvidiaDevicePlugin) pruneClaims(pods []v1.Pod, listed time.Time) {
+	unassigned := map[types.UID]bool{}
+	for i := range pods {
+		if !m.store.IsAssigned(&pods[i]) {
+			unassigned[pods[i].UID] = true
 		}
-		_, err = clientset.CoreV1().Pods(assumePod.Namespace).Patch(assumePod.Name, types.StrategicMergePatchType, patchedAnnotationBytes)
-		if err != nil {
-			// the object has been modified; please apply your changes to the latest version and try again
-			if err.Error() == OptimisticLockErrorMsg {
-				// retry
-				_, err = clientset.CoreV1().Pods(assumePod.Namespace).Patch(assumePod.Name, types.StrategicMergePatchType, patchedAnnotationBytes)
-				if err != nil {
-					log.Warningf("Failed due to %v", err)
-					return buildErrResponse(reqs, podReqGPU), nil
-				}
-			} else {
-				log.Warningf("Failed due to %v", err)
-				return buildErrResponse(reqs, podReqGPU), nil
-			}
+	}
+	for uid, c := range m.claims {
+		if c.assignTime.IsZero() || c.assignTime.After(listed) || unassigned[uid] {
+			continue
 		}
+		delete(m.claims, uid)
+	}
+}
 
-	} else if len(m.devNameMap) == 1 {
-		var devName string
-		var devIndex uint
-		for d, index := range m.devNameMap {
-			devName = d
-			devIndex = index
-			break
+// Allocate which return list of devices. The pod is picked with the lock held, and it's assigned
+// after the lock is released, so the other Allocates aren't blocked by updating the store.
+func (m *NvidiaDevicePlugin) Allocate(ctx context.Context,
+	reqs *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
+	responses := pluginapi.AllocateResponse{}
+
+	log.Infoln("----Allocating GPU for gpu mem is started----")
+	var podReqGPU uint
+
+	// podReqGPU = uint(0)
+	for _, req := range reqs.ContainerRequests {
+		podReqGPU += uint(len(req.DevicesIDs))
+	}
+	log.Infof("RequestPodGPUs: %d", podReqGPU)
+
+	log.Infoln("checking...")
+	listed := time.Now()
+	pods, err := getPendingPodsInNode(ctx, m.queryKubelet, m.kubeletClient)
+	if err != nil {
+		log.Infof("invalid allocation requst: Failed to find candidate pods due to %v", err)
+		return buildErrResponse(reqs, podReqGPU, m.unit), nil
+	}
+
+	m.Lock()
+	assumePod, id, err := m.claimPod(pods, podReqGPU, listed)
+	m.Unlock()
+	if err != nil {
+		log.Warningf("invalid allocation requst: %v", err)
+		return buildErrResponse(reqs, podReqGPU, m.unit), nil
+	}
+
+	// 1. Update Pod spec
+	err = m.store.Assign(ctx, assumePod, id)
+
+	m.Lock()
+	defer m.Unlock()
+	m.releaseClaim(assumePod.UID, err == nil)
+	if err != nil {
+		log.Warningf("Failed due to %v", err)
+		return buildErrResponse(reqs, podReqGPU, m.unit), nil
+	}
+
+	candidateDevID, _ := m.GetDeviceNameByIndex(id)
+	log.Infof("gpu index %v,uuid: %v", id, candidateDevID)
+	// 2. Create container requests
+	for _, req := range reqs.ContainerRequests {
+		reqGPU := uint(len(req.DevicesIDs))
+		response := pluginapi.ContainerAllocateResponse{
+			Envs: map[string]string{
+				envNVGPU:               fmt.Sprintf("%v", id),
+				EnvResourceIndex:       fmt.Sprintf("%d", id),
+				EnvResourceByPod:       fmt.Sprintf("%d", podReqGPU),
+				EnvResourceByContainer: fmt.Sprintf("%d", reqGPU),
+				EnvResourceByDev:       fmt.Sprintf("%d", getGPUMemory()),
+				EnvResourceUnit:        string(m.unit),
+			},
 		}
-		log.Infof("this node has only one gpu device,skip to search pod and directly specify the device  %v(%v) for container", devIndex, devName)
-		for _, req := range reqs.ContainerRequests {
-			reqGPU := uint(len(req.DevicesIDs))
-			response := pluginapi.ContainerAllocateResponse{
-				Envs: map[string]string{
-					envNVGPU:               devName,
-					EnvResourceIndex:       fmt.Sprintf("%d", devIndex),
-					EnvResourceByPod:       fmt.Sprintf("%d", podReqGPU),
-					EnvResourceByContainer: fmt.Sprintf("%d", reqGPU),
-					EnvResourceByDev:       fmt.Sprintf("%d", getGPUMemory()),
-				},
-			}
-			if m.disableCGPUIsolation {
-				response.Envs["CGPU_DISABLE"] = "true"
-			}
-			responses.ContainerResponses = append(responses.ContainerResponses, &response)
+		if m.settings.disableCGPUIsolation {
+			response.Envs["CGPU_DISABLE"] = "true"
 		}
-		log.Infof("get allocated GPUs info %v", responses)
-		return &responses, nil
+		if m.cdi {
+			response.Annotations = cdiAnnotations(id)
+		}
+		if m.deviceSpecs != nil {
+			response.Devices = gpuDeviceSpecs(id, m.deviceSpecs)
+			response.Mounts = m.driverMounts
+		}
+		responses.ContainerResponses = append(responses.ContainerResponses, &response)
 
-	} else {
-		log.Warningf("invalid allocation requst: request GPU memory %d can't be satisfied.",
-			podReqGPU)
-		// return &responses, fmt.Errorf("invalid allocation requst: request GPU memory %d can't be satisfied", reqGPU)
-		return buildErrResponse(reqs, podReqGPU), nil
+		// the allocation is recorded only if the pod is assigned, or PreStartContainer
+		// would verify the container against the GPU which isn't given to it
+		m.recordAllocation(req.DevicesIDs, &allocation{
+			namespace: assumePod.Namespace,
+			name:      assumePod.Name,
+			uid:       assumePod.UID,
+			devIndex:  id,
+			podReqGPU: podReqGPU,
+		})
 	}
+	m.notifyNodeStatus()
 
-	podName := ""
-	if assumePod != nil {
-		podName = assumePod.Name
-	}
-	log.Infof("pod %v, new allocated GPUs info %v", podName, &responses)
-	log.Infof("----Allocating GPU for gpu mem for %v is ended----", podName)
+	log.Infof("pod %v, new allocated GPUs info %v", assumePod.Name, &responses)
+	log.Infof("----Allocating GPU for gpu mem for %v is ended----", assumePod.Name)
 	// // Add this to make sure the container is created at least
 	// currentTime := time.Now()
 
@@ -0,0 +1,80 @@
+package nvidia
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestClaimPod(t *testing.T) {
+	assumed := func(idx, assumeTime string) map[string]string {
+		return map[string]string{EnvAssignedFlag: "false", EnvResourceAssumeTime: assumeTime, EnvResourceIndex: idx}
+	}
+	pods := []v1.Pod{
+		newGPUSharePod("p1", 4, assumed("1", "1"), v1.PodPending),
+		newGPUSharePod("p2", 4, assumed("0", "2"), v1.PodPending),
+		newGPUSharePod("p3", 2, assumed("0", "3"), v1.PodPending),
+	}
+	m := &NvidiaDevicePlugin{
+		devNameMap: map[string]uint{"GPU-a": 0, "GPU-b": 1},
+		store:      annotationStore{},
+		claims:     map[types.UID]*podClaim{},
+	}
+
+	pod, id, err := m.claimPod(pods, 4, time.Now())
+	if err != nil || pod.Name != "p1" || id != 1 {
+		t.Fatalf("expected p1 on gpu 1, got %v on gpu %d: %v", pod, id, err)
+	}
+	// p1 is being assigned, so it's never picked again
+	pod, id, err = m.claimPod(pods, 4, time.Now())
+	if err != nil || pod.Name != "p2" || id != 0 {
+		t.Fatalf("expected p2 on gpu 0, got %v on gpu %d: %v", pod, id, err)
+	}
+	if _, _, err = m.claimPod(pods, 4, time.Now()); err == nil {
+		t.Fatalf("expected no pod to be picked")
+	}
+
+	// p1 failed to be assigned
+	m.releaseClaim("p1", false)
+	if pod, _, err = m.claimPod(pods, 4, time.Now()); err != nil || pod.Name != "p1" {
+		t.Fatalf("expected p1 to be picked again, got %v: %v", pod, err)
+	}
+
+	// the pods listed before the assignment still show p1 as unassigned
+	listed := time.Now()
+	m.releaseClaim("p1", true)
+	m.pruneClaims(pods, listed)
+	if _, ok := m.claims["p1"]; !ok {
+		t.Fatalf("expected the claim of p1 to be kept")
+	}
+	pods[0].Annotations[EnvAssignedFlag] = "true"
+	m.pruneClaims(pods, time.Now())
+	if _, ok := m.claims["p1"]; ok {
+		t.Errorf("expected the claim of assigned p1 to be removed")
+	}
+	if _, ok := m.claims["p2"]; !ok {
+		t.Errorf("expected the claim of p2 being assigned to be kept")
+	}
+}
+
+func TestClaimPodOnSingleGPU(t *testing.T) {
+	pods := []v1.Pod{
+		newGPUSharePod("running", 4, nil, v1.PodRunning),
+		newGPUSharePod("pending", 4, nil, v1.PodPending),
+	}
+	m := &NvidiaDevicePlugin{
+		devNameMap: map[string]uint{"GPU-a": 0},
+		store:      annotationStore{},
+		claims:     map[types.UID]*podClaim{},
+	}
+
+	pod, id, err := m.claimPod(pods, 4, time.Now())
+	if err != nil || pod.Name != "pending" || id != 0 {
+		t.Fatalf("expected the pending pod on gpu 0, got %v on gpu %d: %v", pod, id, err)
+	}
+	if _, _, err = m.claimPod(pods, 4, time.Now()); err == nil {
+		t.Errorf("expected the running pod not to be picked")
+	}
+}
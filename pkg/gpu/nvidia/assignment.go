@@ -0,0 +1,317 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	log "github.com/golang/glog"
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// The stores of the assigned state of the pods
+const (
+	// AssignmentStoreAnnotation patches ALIYUN_COM_GPU_MEM_ASSIGNED=true on the pod in Allocate
+	AssignmentStoreAnnotation = "annotation"
+	// AssignmentStoreFile records the assigned pods in a file on the node. The assigned flag of
+	// the pods stays false in kube-apiserver, so the scheduler extender sees them as assumed.
+	AssignmentStoreFile = "file"
+	// AssignmentStoreConfigMap records the assigned pods in a ConfigMap of the node, it's updated in
+	// batch. The scheduler extender sees the pods as assumed as with the file store.
+	AssignmentStoreConfigMap = "configmap"
+
+	DefaultAssignmentFile = "/var/lib/gpushare/assignments.json"
+
+	assignmentFlushInterval = time.Second
+)
+
+// assignmentStore keeps the assigned state of the pods, so the pod is no longer a candidate of Allocate
+type assignmentStore interface {
+	// Assign records the pod is assigned to the GPU
+	Assign(ctx context.Context, pod *v1.Pod, devIndex uint) error
+	// IsAssigned returns true if the pod is assigned
+	IsAssigned(pod *v1.Pod) bool
+	// Prune removes the records of the pods which are not active any more, the pods are
+	// listed at the time, so the records made after it are kept
+	Prune(pods []v1.Pod, listed time.Time)
+	// Run flushes the records until the stop channel is closed
+	Run(stop <-chan struct{})
+}
+
+// newAssignmentStore creates the store of the kind, the records are loaded from the file or ConfigMap
+func newAssignmentStore(kind, file string) (assignmentStore, error) {
+	switch kind {
+	case AssignmentStoreAnnotation, "":
+		return annotationStore{}, nil
+	case AssignmentStoreFile:
+		s := &recordStore{
+			assignments: map[types.UID]*gpushare.Assignment{},
+			load:        func() ([]byte, error) { return readAssignmentFile(file) },
+			save:        func(data []byte) error { return writeAssignmentFile(file, data) },
+		}
+		return s, s.loadRecord()
+	case AssignmentStoreConfigMap:
+		s := &recordStore{
+			assignments: map[types.UID]*gpushare.Assignment{},
+			batched:     true,
+			load:        loadAssignmentConfigMap,
+			save:        saveAssignmentConfigMap,
+		}
+		return s, s.loadRecord()
+	default:
+		return nil, fmt.Errorf("unknown assignment store %q", kind)
+	}
+}
+
+func isAssignedByAnnotation(pod *v1.Pod) bool {
+	return pod.Annotations[EnvAssignedFlag] == "true"
+}
+
+// annotationStore patches the assigned flag on the pod
+type annotationStore struct{}
+
+func (annotationStore) Assign(ctx context.Context, pod *v1.Pod, devIndex uint) error {
+	patch, err := patchPodAnnotationSpecAssigned(devIndex)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, clientTimeout)
+	defer cancel()
+	return retryOnTransientError(ctx, patchBackoff, func() error {
+		_, err := clientset.CoreV1().Pods(pod.Namespace).Patch(pod.Name, types.StrategicMergePatchType, patch)
+		return err
+	})
+}
+
+func (annotationStore) IsAssigned(pod *v1.Pod) bool {
+	return isAssignedByAnnotation(pod)
+}
+
+func (annotationStore) Prune(pods []v1.Pod, listed time.Time) {}
+
+func (annotationStore) Run(stop <-chan struct{}) {}
+
+// recordStore keeps the assigned pods in memory, and saves them into the file on every
+// assignment, or into the ConfigMap in batch. The pods assigned by annotation are
+// also assigned, so the store can be switched on the fly.
+type recordStore struct {
+	sync.Mutex
+	assignments map[types.UID]*gpushare.Assignment
+	// batched saves the records periodically in Run instead of on every assignment
+	batched bool
+	dirty   bool
+	load    func() ([]byte, error)
+	save    func(data []byte) error
+}
+
+func (s *recordStore) Assign(ctx context.Context, pod *v1.Pod, devIndex uint) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.assignments[pod.UID] = &gpushare.Assignment{
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        pod.UID,
+		Index:      devIndex,
+		GPUMemory:  getGPUMemoryFromPodResource(pod),
+		AssignTime: time.Now().UnixNano(),
+	}
+	s.dirty = true
+	if s.batched {
+		return nil
+	}
+	return s.flushLocked()
+}
+
+func (s *recordStore) IsAssigned(pod *v1.Pod) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.assignments[pod.UID]
+	return ok || isAssignedByAnnotation(pod)
+}
+
+func (s *recordStore) Prune(pods []v1.Pod, listed time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	active := map[types.UID]bool{}
+	for _, pod := range pods {
+		active[pod.UID] = true
+	}
+	for uid, a := range s.assignments {
+		if !active[uid] && a.AssignTime < listed.UnixNano() {
+			log.V(4).Infof("Remove the assignment of pod %s in ns %s which is not active", a.Name, a.Namespace)
+			delete(s.assignments, uid)
+			s.dirty = true
+		}
+	}
+	if s.batched {
+		return
+	}
+	if err := s.flushLocked(); err != nil {
+		log.Warningf("Failed to save the assignments due to %v", err)
+	}
+}
+
+func (s *recordStore) Run(stop <-chan struct{}) {
+	if !s.batched {
+		return
+	}
+
+	flush := func() {
+		s.Lock()
+		defer s.Unlock()
+		if err := s.flushLocked(); err != nil {
+			log.Warningf("Failed to save the assignments due to %v", err)
+		}
+	}
+	wait.Until(flush, assignmentFlushInterval, stop)
+	// save the assignments made before stopped
+	flush()
+}
+
+// flushLocked saves the records if they're changed, it's called with the lock held
+func (s *recordStore) flushLocked() error {
+	if !s.dirty {
+		return nil
+	}
+
+	record := gpushare.AssignmentRecord{
+		Version:     gpushare.AssignmentRecordVersion,
+		Node:        nodeName,
+		Assignments: []gpushare.Assignment{},
+	}
+	for _, a := range s.assignments {
+		record.Assignments = append(record.Assignments, *a)
+	}
+	sort.Slice(record.Assignments, func(i, j int) bool {
+		return record.Assignments[i].AssignTime < record.Assignments[j].AssignTime
+	})
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err = s.save(data); err != nil {
+		return err
+	}
+
+	s.dirty = false
+	return nil
+}
+
+func (s *recordStore) loadRecord() error {
+	data, err := s.load()
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	record := gpushare.AssignmentRecord{}
+	if err = json.Unmarshal(data, &record); err != nil {
+		return fmt.Errorf("failed to parse the assignments: %v", err)
+	}
+	if record.Version != gpushare.AssignmentRecordVersion {
+		return fmt.Errorf("unsupported version %q of the assignments", record.Version)
+	}
+	for i := range record.Assignments {
+		a := record.Assignments[i]
+		s.assignments[a.UID] = &a
+	}
+	log.Infof("Loaded %d assignments", len(s.assignments))
+
+	return nil
+}
+
+// readAssignmentFile returns empty if the file doesn't exist
+func readAssignmentFile(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// writeAssignmentFile writes the file atomically
+func writeAssignmentFile(file string, data []byte) error {
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".assignments")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+// loadAssignmentConfigMap returns empty if the ConfigMap of the node doesn't exist
+func loadAssignmentConfigMap() ([]byte, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(gpushare.AssignmentConfigMapNamespace).Get(gpushare.AssignmentConfigMapPrefix+nodeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(cm.Data[gpushare.AssignmentConfigMapKey]), nil
+}
+
+// saveAssignmentConfigMap creates or updates the ConfigMap of the node
+func saveAssignmentConfigMap(data []byte) error {
+	name := gpushare.AssignmentConfigMapPrefix + nodeName
+	return retryPatch(func() error {
+		cm, err := clientset.CoreV1().ConfigMaps(gpushare.AssignmentConfigMapNamespace).Get(name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = clientset.CoreV1().ConfigMaps(gpushare.AssignmentConfigMapNamespace).Create(&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: gpushare.AssignmentConfigMapNamespace,
+					Labels:    map[string]string{gpushare.AssignmentConfigMapLabel: "true"},
+				},
+				Data: map[string]string{gpushare.AssignmentConfigMapKey: string(data)},
+			})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		cm = cm.DeepCopy()
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[gpushare.AssignmentConfigMapKey] = string(data)
+		_, err = clientset.CoreV1().ConfigMaps(gpushare.AssignmentConfigMapNamespace).Update(cm)
+		return err
+	})
+}
@@ -0,0 +1,113 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestFileAssignmentStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "assignments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "gpushare", "assignments.json")
+
+	store, err := newAssignmentStore(AssignmentStoreFile, file)
+	if err != nil {
+		t.Fatalf("failed to create the store without the file: %v", err)
+	}
+
+	a := newGPUSharePod("a", 2, map[string]string{EnvAssignedFlag: "false"}, v1.PodPending)
+	b := newGPUSharePod("b", 4, map[string]string{EnvAssignedFlag: "false"}, v1.PodPending)
+	byAnnotation := newGPUSharePod("c", 4, map[string]string{EnvAssignedFlag: "true"}, v1.PodRunning)
+	if err = store.Assign(context.Background(), &a, 1); err != nil {
+		t.Fatalf("failed to assign pod a: %v", err)
+	}
+	if err = store.Assign(context.Background(), &b, 0); err != nil {
+		t.Fatalf("failed to assign pod b: %v", err)
+	}
+	if !store.IsAssigned(&a) || !store.IsAssigned(&b) || !store.IsAssigned(&byAnnotation) {
+		t.Errorf("expected pods a, b and c to be assigned")
+	}
+
+	// pod b is deleted, it's kept if it's assigned after listing the pods
+	store.Prune([]v1.Pod{a}, time.Now().Add(-time.Hour))
+	if !store.IsAssigned(&b) {
+		t.Errorf("expected pod b assigned after listing to be kept")
+	}
+	store.Prune([]v1.Pod{a}, time.Now())
+
+	// the records are loaded by the new store after restarting
+	store, err = newAssignmentStore(AssignmentStoreFile, file)
+	if err != nil {
+		t.Fatalf("failed to load the assignments: %v", err)
+	}
+	if !store.IsAssigned(&a) {
+		t.Errorf("expected pod a to be assigned after loading")
+	}
+	if store.IsAssigned(&b) {
+		t.Errorf("expected pod b to be pruned")
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := gpushare.AssignmentRecord{}
+	if err = json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("failed to parse %s: %v", data, err)
+	}
+	if len(record.Assignments) != 1 || record.Assignments[0].Index != 1 || record.Assignments[0].GPUMemory != 2 {
+		t.Errorf("unexpected assignments %+v", record.Assignments)
+	}
+}
+
+func TestBatchedAssignmentStore(t *testing.T) {
+	saved := 0
+	store := &recordStore{
+		assignments: map[types.UID]*gpushare.Assignment{},
+		batched:     true,
+		load:        func() ([]byte, error) { return nil, nil },
+		save:        func(data []byte) error { saved++; return nil },
+	}
+
+	a := newGPUSharePod("a", 2, nil, v1.PodPending)
+	b := newGPUSharePod("b", 2, nil, v1.PodPending)
+	store.Assign(context.Background(), &a, 0)
+	store.Assign(context.Background(), &b, 0)
+	if saved != 0 {
+		t.Errorf("expected the assignments not to be saved until flushed, got %d saves", saved)
+	}
+	if !store.IsAssigned(&a) {
+		t.Errorf("expected pod a to be assigned before flushed")
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	store.Run(stop)
+	if saved != 1 {
+		t.Errorf("expected the assignments to be saved once, got %d saves", saved)
+	}
+
+	// nothing is changed
+	store.Run(stop)
+	if saved != 1 {
+		t.Errorf("expected no save without changes, got %d saves", saved)
+	}
+}
+
+func TestUnknownAssignmentStore(t *testing.T) {
+	if _, err := newAssignmentStore("crd", ""); err == nil {
+		t.Errorf("expected error for the unknown store")
+	}
+}
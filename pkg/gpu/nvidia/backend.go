@@ -0,0 +1,149 @@
+package nvidia
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+)
+
+var errNotSupported = errors.New("not supported by the device backend")
+
+// gpuDevice is a physical GPU found by the device backend
+type gpuDevice struct {
+	UUID string
+	// Index is the minor number of the device, e.g. 1 for /dev/nvidia1
+	Index uint
+	Path  string
+	Model string
+	// Memory is the total GPU memory in MiB
+	Memory uint
+}
+
+// gpuUsage is the GPU memory used on a GPU found by the device backend
+type gpuUsage struct {
+	Index uint
+	// Used is the memory used on the GPU in MiB
+	Used      uint64
+	Processes []gpuProcess
+}
+
+// gpuProcess is a process using the GPU memory, the PID is in the PID namespace of the host
+type gpuProcess struct {
+	PID uint
+	// Memory is the memory used by the process in MiB
+	Memory uint64
+}
+
+// deviceBackend discovers the GPUs on the node
+type deviceBackend interface {
+	Devices() ([]*gpuDevice, error)
+	DriverVersion() (string, error)
+	CUDAVersion() (string, error)
+	// MIGEnabled returns true if MIG is enabled on any GPU
+	MIGEnabled() (bool, error)
+	// Usage returns the memory used on the GPUs and the processes using them
+	Usage() ([]*gpuUsage, error)
+}
+
+// backend is the device backend used by the device plugin, it's replaced in the tests
+var backend deviceBackend = nvmlBackend{}
+
+type nvmlBackend struct{}
+
+func (nvmlBackend) Devices() ([]*gpuDevice, error) {
+	n, err := nvml.GetDeviceCount()
+	if err != nil {
+		return nil, err
+	}
+
+	devs := []*gpuDevice{}
+	for i := uint(0); i < n; i++ {
+		d, err := nvml.NewDevice(i)
+		if err != nil {
+			return nil, err
+		}
+
+		dev := &gpuDevice{
+			UUID: d.UUID,
+			Path: d.Path,
+		}
+		if _, err = fmt.Sscanf(d.Path, "/dev/nvidia%d", &dev.Index); err != nil {
+			return nil, err
+		}
+		if d.Model != nil {
+			dev.Model = *d.Model
+		}
+		if d.Memory != nil {
+			dev.Memory = uint(*d.Memory)
+		}
+		devs = append(devs, dev)
+	}
+
+	return devs, nil
+}
+
+func (nvmlBackend) DriverVersion() (string, error) {
+	return nvml.GetDriverVersion()
+}
+
+func (nvmlBackend) CUDAVersion() (string, error) {
+	return nvmlCUDAVersion()
+}
+
+func (nvmlBackend) MIGEnabled() (bool, error) {
+	n, err := nvml.GetDeviceCount()
+	if err != nil {
+		return false, err
+	}
+
+	for i := uint(0); i < n; i++ {
+		enabled, err := nvmlMIGEnabled(i)
+		if err != nil || enabled {
+			return enabled, err
+		}
+	}
+
+	return false, nil
+}
+
+func (nvmlBackend) Usage() ([]*gpuUsage, error) {
+	n, err := nvml.GetDeviceCount()
+	if err != nil {
+		return nil, err
+	}
+
+	usages := []*gpuUsage{}
+	for i := uint(0); i < n; i++ {
+		d, err := nvml.NewDeviceLite(i)
+		if err != nil {
+			return nil, err
+		}
+		processes, err := d.GetAllRunningProcesses()
+		if err != nil {
+			return nil, err
+		}
+
+		usage := &gpuUsage{Processes: []gpuProcess{}}
+		if _, err = fmt.Sscanf(d.Path, "/dev/nvidia%d", &usage.Index); err != nil {
+			return nil, err
+		}
+		// a process may be both a compute and a graphics process
+		seen := map[uint]bool{}
+		for _, p := range processes {
+			if seen[p.PID] {
+				continue
+			}
+			seen[p.PID] = true
+			usage.Processes = append(usage.Processes, gpuProcess{PID: p.PID, Memory: p.MemoryUsed})
+			usage.Used += p.MemoryUsed
+		}
+		// the memory used on the GPU includes the contexts which are not of any process
+		if status, err := d.Status(); err == nil && status.Memory.Global.Used != nil && *status.Memory.Global.Used > usage.Used {
+			usage.Used = *status.Memory.Global.Used
+		}
+		usages = append(usages, usage)
+	}
+
+	return usages, nil
+}
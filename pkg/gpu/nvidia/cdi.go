@@ -0,0 +1,176 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/golang/glog"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// cdiVersion is the lowest version which supports the annotations of the devices
+	cdiVersion = "0.6.0"
+	// cdiKind is the kind of the devices in the CDI spec, a device is referred as aliyun.com/gpushare=0
+	cdiKind = "aliyun.com/gpushare"
+	// cdiAnnotationKey is the annotation to request the CDI devices from containerd and CRI-O,
+	// since the vendored device plugin API has no CDIDevices field yet
+	cdiAnnotationKey  = "cdi.k8s.io/gpushare-device-plugin"
+	cdiSpecFile       = "aliyun.com-gpushare.json"
+	DefaultCDISpecDir = "/var/run/cdi"
+
+	// cdiAnnotationShared marks the device is sliced by GPU memory and shared by the containers
+	cdiAnnotationShared = "aliyun.com/gpu-mem-shared"
+)
+
+// cdiSpec is the Container Device Interface spec of the GPUs on the node
+type cdiSpec struct {
+	Version        string            `json:"cdiVersion"`
+	Kind           string            `json:"kind"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	Devices        []cdiDevice       `json:"devices"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits,omitempty"`
+}
+
+type cdiDevice struct {
+	Name           string            `json:"name"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+type cdiContainerEdits struct {
+	Env         []string        `json:"env,omitempty"`
+	DeviceNodes []cdiDeviceNode `json:"deviceNodes,omitempty"`
+	Mounts      []cdiMount      `json:"mounts,omitempty"`
+}
+
+type cdiDeviceNode struct {
+	Path        string `json:"path"`
+	HostPath    string `json:"hostPath,omitempty"`
+	Permissions string `json:"permissions,omitempty"`
+}
+
+type cdiMount struct {
+	HostPath      string   `json:"hostPath"`
+	ContainerPath string   `json:"containerPath"`
+	Options       []string `json:"options,omitempty"`
+}
+
+// controlDeviceNodes are shared by all the GPUs
+var controlDeviceNodes = []string{
+	"/dev/nvidiactl",
+	"/dev/nvidia-uvm",
+	"/dev/nvidia-uvm-tools",
+}
+
+// cdiMountOptions are the options of the driver files mounted by CDI
+var cdiMountOptions = []string{"ro", "nosuid", "nodev", "bind"}
+
+// writeNodeCDISpec writes the CDI spec of the GPUs with the control devices and the driver files
+// found on the host, so the containers run by a CDI runtime without the nvidia container runtime
+func writeNodeCDISpec(config Config) error {
+	gpus, err := backend.Devices()
+	if err != nil {
+		return err
+	}
+	mounts, err := discoverDriverMounts(config.Driver)
+	if err != nil {
+		return err
+	}
+	controls := discoverControlDeviceSpecs(config.Driver.HostRoot)
+
+	return writeCDISpec(config.CDISpecDir, generateCDISpec(gpus, config.memoryUnit(), controls, mounts))
+}
+
+// generateCDISpec generates the CDI spec of the GPUs, each GPU is named by its index. The control
+// devices and the driver files are shared by the GPUs, so they're the edits of the spec which are
+// applied with any GPU. The gpu-mem of the GPUs is counted in the unit.
+func generateCDISpec(devs []*gpuDevice, unit MemoryUnit, controls []*pluginapi.DeviceSpec, mounts []*pluginapi.Mount) *cdiSpec {
+	spec := &cdiSpec{
+		Version: cdiVersion,
+		Kind:    cdiKind,
+		Devices: []cdiDevice{},
+	}
+
+	for _, dev := range devs {
+		spec.Devices = append(spec.Devices, cdiDevice{
+			Name: fmt.Sprintf("%d", dev.Index),
+			Annotations: map[string]string{
+				cdiAnnotationShared:      "true",
+				NodeAnnotationMemoryUnit: string(unit),
+				resourceName:             fmt.Sprintf("%d", dev.Memory/unit.MiB()),
+			},
+			ContainerEdits: cdiContainerEdits{
+				DeviceNodes: []cdiDeviceNode{{Path: dev.Path, Permissions: "rw"}},
+			},
+		})
+	}
+
+	for _, control := range controls {
+		spec.ContainerEdits.DeviceNodes = append(spec.ContainerEdits.DeviceNodes, cdiDeviceNode{
+			Path:        control.ContainerPath,
+			HostPath:    control.HostPath,
+			Permissions: control.Permissions,
+		})
+	}
+	for _, mount := range mounts {
+		spec.ContainerEdits.Mounts = append(spec.ContainerEdits.Mounts, cdiMount{
+			HostPath:      mount.HostPath,
+			ContainerPath: mount.ContainerPath,
+			Options:       cdiMountOptions,
+		})
+	}
+
+	return spec
+}
+
+// writeCDISpec writes the CDI spec into the directory, the file is replaced atomically
+func writeCDISpec(dir string, spec *cdiSpec) error {
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".gpushare-cdi-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, cdiSpecFile)
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	log.Infof("Wrote CDI spec of %d devices to %s", len(spec.Devices), path)
+
+	return nil
+}
+
+// cdiAnnotations returns the annotations to inject the GPU with the index by CDI
+func cdiAnnotations(devIndex uint) map[string]string {
+	return map[string]string{
+		cdiAnnotationKey: cdiDeviceName(devIndex),
+	}
+}
+
+// cdiDeviceName returns the fully qualified CDI device name, e.g. aliyun.com/gpushare=0
+func cdiDeviceName(devIndex uint) string {
+	return fmt.Sprintf("%s=%d", cdiKind, devIndex)
+}
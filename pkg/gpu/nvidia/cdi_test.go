@@ -0,0 +1,155 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeBackend is a device backend with a fixed device inventory
+type fakeBackend struct {
+	devs   []*gpuDevice
+	usages []*gpuUsage
+	mig    bool
+	err    error
+}
+
+func (b *fakeBackend) Devices() ([]*gpuDevice, error) {
+	return b.devs, b.err
+}
+
+func (b *fakeBackend) DriverVersion() (string, error) {
+	return "418.87.01", nil
+}
+
+func (b *fakeBackend) CUDAVersion() (string, error) {
+	return "10.1", nil
+}
+
+func (b *fakeBackend) MIGEnabled() (bool, error) {
+	return b.mig, nil
+}
+
+func (b *fakeBackend) Usage() ([]*gpuUsage, error) {
+	return b.usages, nil
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{devs: []*gpuDevice{
+		{UUID: "GPU-a", Index: 0, Path: "/dev/nvidia0", Model: "Tesla T4", Memory: 15109},
+		{UUID: "GPU-b", Index: 3, Path: "/dev/nvidia3", Model: "Tesla T4", Memory: 15109},
+	}}
+}
+
+func TestGenerateCDISpec(t *testing.T) {
+	devs, _ := newFakeBackend().Devices()
+	// the host has no /dev/nvidia-uvm-tools
+	root := newHostRoot(t)
+	defer os.RemoveAll(root)
+	config := DefaultDriverConfig()
+	config.HostRoot = root
+	mounts, err := discoverDriverMounts(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := generateCDISpec(devs, GiBPrefix, discoverControlDeviceSpecs(root), mounts)
+	if spec.Kind != cdiKind || spec.Version != "0.6.0" {
+		t.Fatalf("unexpected kind %s or version %s, the annotations of the devices require 0.6.0", spec.Kind, spec.Version)
+	}
+	if len(spec.Devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(spec.Devices))
+	}
+
+	dev := spec.Devices[1]
+	if dev.Name != "3" {
+		t.Errorf("expected the device to be named by its index 3, got %s", dev.Name)
+	}
+	expectedNodes := []cdiDeviceNode{{Path: "/dev/nvidia3", Permissions: "rw"}}
+	if !reflect.DeepEqual(dev.ContainerEdits.DeviceNodes, expectedNodes) {
+		t.Errorf("expected device nodes %v, got %v", expectedNodes, dev.ContainerEdits.DeviceNodes)
+	}
+	expectedAnnotations := map[string]string{
+		cdiAnnotationShared:      "true",
+		NodeAnnotationMemoryUnit: "GiB",
+		resourceName:             "14",
+	}
+	if !reflect.DeepEqual(dev.Annotations, expectedAnnotations) {
+		t.Errorf("expected annotations %v, got %v", expectedAnnotations, dev.Annotations)
+	}
+
+	expectedNodes = []cdiDeviceNode{
+		{Path: "/dev/nvidiactl", HostPath: "/dev/nvidiactl", Permissions: "rw"},
+		{Path: "/dev/nvidia-uvm", HostPath: "/dev/nvidia-uvm", Permissions: "rw"},
+	}
+	if !reflect.DeepEqual(spec.ContainerEdits.DeviceNodes, expectedNodes) {
+		t.Errorf("expected only the control device nodes on the host %v, got %v", expectedNodes, spec.ContainerEdits.DeviceNodes)
+	}
+	if len(spec.ContainerEdits.Mounts) != len(mounts) {
+		t.Fatalf("expected the driver files %v to be mounted, got %v", mounts, spec.ContainerEdits.Mounts)
+	}
+	expectedMount := cdiMount{
+		HostPath:      "/usr/bin/nvidia-smi",
+		ContainerPath: "/usr/local/nvidia/bin/nvidia-smi",
+		Options:       []string{"ro", "nosuid", "nodev", "bind"},
+	}
+	if mount := spec.ContainerEdits.Mounts[len(mounts)-1]; !reflect.DeepEqual(mount, expectedMount) {
+		t.Errorf("expected mount %v, got %v", expectedMount, mount)
+	}
+}
+
+func TestWriteNodeCDISpec(t *testing.T) {
+	oldBackend := backend
+	defer func() { backend = oldBackend }()
+	backend = newFakeBackend()
+
+	root := newHostRoot(t)
+	defer os.RemoveAll(root)
+	dir, err := ioutil.TempDir("", "cdi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := Config{CDI: true, CDISpecDir: dir, MemoryUnit: "256MiB", Driver: DefaultDriverConfig()}
+	config.Driver.HostRoot = root
+	if err = writeNodeCDISpec(config); err != nil {
+		t.Fatalf("failed to write CDI spec: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, cdiSpecFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &cdiSpec{}
+	if err = json.Unmarshal(data, spec); err != nil {
+		t.Fatalf("failed to parse CDI spec: %v", err)
+	}
+	if got := spec.Devices[0].Annotations[resourceName]; got != "59" {
+		t.Errorf("expected 59 blocks of 256MiB, got %s", got)
+	}
+	if len(spec.ContainerEdits.Mounts) == 0 {
+		t.Errorf("expected the driver files to be mounted")
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("expected only the spec file in %s, got %d files", dir, len(files))
+	}
+
+	// the CDI spec can't be used without the driver files
+	config.Driver.HostRoot = dir
+	if err = writeNodeCDISpec(config); err == nil {
+		t.Errorf("expected an error without the driver files")
+	}
+}
+
+func TestCDIAnnotations(t *testing.T) {
+	expected := map[string]string{cdiAnnotationKey: "aliyun.com/gpushare=3"}
+	if got := cdiAnnotations(3); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,388 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/kubelet/client"
+	"github.com/ghodss/yaml"
+	log "github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+)
+
+// ConfigVersion is the version of the config file format
+const ConfigVersion = "v1"
+
+const serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Config is the config of the device plugin, it's loaded from the config file
+// and the command line flags are used as the defaults.
+type Config struct {
+	Version string `json:"version"`
+	// MPS enables MPS, it requires restarting the device plugin
+	MPS bool `json:"mps"`
+	// HealthCheck enables the XID health check, it's reloaded on the fly
+	HealthCheck bool `json:"healthCheck"`
+	// MemoryUnit is the GPU memory of one fake device, it requires restarting the device plugin
+	MemoryUnit string `json:"memoryUnit"`
+	// ReservedMemory is the GPU memory reserved on each GPU, it's reloaded on the fly
+	ReservedMemory string `json:"reservedMemory,omitempty"`
+	// QueryKubelet queries pending pods from kubelet instead of kube-apiserver
+	QueryKubelet bool `json:"queryKubelet"`
+	// CDI writes the CDI spec of the GPUs with the driver files found in Driver into CDISpecDir,
+	// and requests the GPU by CDI in Allocate
+	CDI        bool   `json:"cdi"`
+	CDISpecDir string `json:"cdiSpecDir,omitempty"`
+	// DeviceSpecs returns the device nodes and the driver mounts in Allocate, so the containers
+	// work without the nvidia container runtime
+	DeviceSpecs bool         `json:"deviceSpecs"`
+	Driver      DriverConfig `json:"driver"`
+	// AssumedPodTTL expires the pods which are assumed but not allocated in the seconds, the expired
+	// pods of the controllers are deleted to be recreated. 0 disables it
+	AssumedPodTTL int `json:"assumedPodTTL"`
+	// AssignmentStore is where the assigned state of the pods is kept: annotation, file or configmap
+	AssignmentStore string `json:"assignmentStore"`
+	// AssignmentFile is the file on the node used by the file store
+	AssignmentFile string `json:"assignmentFile,omitempty"`
+	// GPUShareNode reports the GPUs and their pods in the GPUShareNode of the node, it requires the CRD
+	GPUShareNode bool `json:"gpuShareNode"`
+	// MemoryUsageInterval publishes the GPU memory used by the pods in the node annotation every
+	// the seconds, 0 disables it. The processes are mapped to the pods by their cgroups in ProcRoot.
+	MemoryUsageInterval int    `json:"memoryUsageInterval"`
+	ProcRoot            string `json:"procRoot,omitempty"`
+	// Enforcer applies the action to the containers which use more GPU memory than their gpu-mem,
+	// the processes are attributed to the containers in ProcRoot
+	Enforcer EnforcerConfig `json:"enforcer"`
+	// Kubelet is the kubelet client config, it requires restarting the device plugin
+	Kubelet KubeletConfig `json:"kubelet"`
+	// NodePools override the config on the nodes selected by their labels, the latter wins
+	NodePools []NodePoolConfig `json:"nodePools,omitempty"`
+}
+
+// KubeletConfig is the config of the kubelet client
+type KubeletConfig struct {
+	// Address is the node IP by default, so it matches the serving certificate of kubelet
+	Address    string `json:"address"`
+	Port       uint   `json:"port"`
+	ClientCert string `json:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty"`
+	Token      string `json:"token,omitempty"`
+	// TokenFile is read again when the token is rotated, the service account token is used if no credential is set
+	TokenFile string `json:"tokenFile,omitempty"`
+	// Timeout is the http timeout in seconds
+	Timeout int `json:"timeout"`
+	// CAFile or CAData, the base64 encoded PEM in the config file, verifies the certificate of kubelet
+	// against ServerName or Address. The system roots are used if neither is set.
+	CAFile     string `json:"caFile,omitempty"`
+	CAData     []byte `json:"caData,omitempty"`
+	ServerName string `json:"serverName,omitempty"`
+	// InsecureSkipTLSVerify doesn't verify the certificate of kubelet. The certificate isn't verified
+	// either if none of CAFile, CAData and ServerName is set, which is deprecated.
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify"`
+}
+
+// unverified tells if none of the ways to verify kubelet is set, the device plugin used to skip
+// verifying kubelet then, so it's kept for one release with a warning
+func (k KubeletConfig) unverified() bool {
+	return !k.InsecureSkipTLSVerify && k.CAFile == "" && len(k.CAData) == 0 && k.ServerName == ""
+}
+
+// NodePoolConfig overrides the config on the nodes matching the node selector
+type NodePoolConfig struct {
+	Name           string            `json:"name,omitempty"`
+	NodeSelector   map[string]string `json:"nodeSelector"`
+	MPS            *bool             `json:"mps,omitempty"`
+	HealthCheck    *bool             `json:"healthCheck,omitempty"`
+	MemoryUnit     string            `json:"memoryUnit,omitempty"`
+	ReservedMemory string            `json:"reservedMemory,omitempty"`
+	QueryKubelet   *bool             `json:"queryKubelet,omitempty"`
+}
+
+// LoadConfig loads the config file, the fields which are not in the file keep the defaults.
+// The unknown fields are rejected, so a misspelled field doesn't silently keep the default.
+func LoadConfig(file string, defaults Config) (Config, error) {
+	config := defaults
+	config.NodePools = nil
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse config file %s: %v", file, err)
+	}
+	if err = checkUnknownFields(data, config); err != nil {
+		return config, fmt.Errorf("failed to parse config file %s: %v", file, err)
+	}
+	if err = config.Validate(); err != nil {
+		return config, fmt.Errorf("invalid config file %s: %v", file, err)
+	}
+
+	return config, nil
+}
+
+// checkUnknownFields returns an error if a field of the YAML is not a field of the object
+func checkUnknownFields(data []byte, obj interface{}) error {
+	j, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if err = json.Unmarshal(j, &value); err != nil {
+		return err
+	}
+	return unknownField(value, reflect.TypeOf(obj), "")
+}
+
+// unknownField walks the decoded value along the type, the field names are matched case
+// insensitively as encoding/json does
+func unknownField(value interface{}, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		keys := []string{}
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field, ok := jsonField(t, key)
+			if !ok {
+				return fmt.Errorf("unknown field %q", joinFieldPath(path, key))
+			}
+			if err := unknownField(v[key], field.Type, joinFieldPath(path, key)); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice {
+			return nil
+		}
+		for i, item := range v {
+			if err := unknownField(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// jsonField returns the field of the struct with the JSON name
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		if strings.EqualFold(tag, name) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// Validate checks the config
+func (c Config) Validate() error {
+	if c.Version != ConfigVersion {
+		return fmt.Errorf("unsupported version %q, it should be %s", c.Version, ConfigVersion)
+	}
+	if _, err := ParseMemoryUnit(c.MemoryUnit); err != nil {
+		return err
+	}
+	if _, err := ParseMemoryReservation(c.ReservedMemory); err != nil {
+		return err
+	}
+	if c.Kubelet.Port == 0 || c.Kubelet.Port > 65535 {
+		return fmt.Errorf("invalid kubelet port %d", c.Kubelet.Port)
+	}
+	if c.CDI && c.CDISpecDir == "" {
+		return fmt.Errorf("cdiSpecDir is required in CDI mode")
+	}
+	if c.DeviceSpecs || c.CDI {
+		if c.Driver.HostRoot == "" {
+			return fmt.Errorf("driver.hostRoot is required to return the device specs or write the CDI spec")
+		}
+		if !filepath.IsAbs(c.Driver.ContainerLibraryDir) || !filepath.IsAbs(c.Driver.ContainerBinaryDir) {
+			return fmt.Errorf("driver.containerLibraryDir and driver.containerBinaryDir should be absolute paths")
+		}
+	}
+	switch c.AssignmentStore {
+	case AssignmentStoreAnnotation, AssignmentStoreConfigMap:
+	case AssignmentStoreFile:
+		if !filepath.IsAbs(c.AssignmentFile) {
+			return fmt.Errorf("assignmentFile should be an absolute path")
+		}
+	default:
+		return fmt.Errorf("unknown assignmentStore %q, it should be annotation, file or configmap", c.AssignmentStore)
+	}
+	if c.AssumedPodTTL < 0 {
+		return fmt.Errorf("invalid assumedPodTTL %d", c.AssumedPodTTL)
+	}
+	if c.MemoryUsageInterval < 0 {
+		return fmt.Errorf("invalid memoryUsageInterval %d", c.MemoryUsageInterval)
+	}
+	if err := c.Enforcer.Validate(); err != nil {
+		return err
+	}
+	if (c.MemoryUsageInterval > 0 || c.Enforcer.Action != "") && !filepath.IsAbs(c.ProcRoot) {
+		return fmt.Errorf("procRoot should be an absolute path")
+	}
+	if c.Kubelet.Timeout <= 0 {
+		return fmt.Errorf("invalid kubelet timeout %d", c.Kubelet.Timeout)
+	}
+	if c.Kubelet.Token != "" && c.Kubelet.TokenFile != "" {
+		return fmt.Errorf("kubelet token and tokenFile can't be both set")
+	}
+	if c.Kubelet.InsecureSkipTLSVerify && (c.Kubelet.CAFile != "" || len(c.Kubelet.CAData) > 0) {
+		return fmt.Errorf("kubelet caFile and caData can't be set with insecureSkipTLSVerify")
+	}
+
+	for i, pool := range c.NodePools {
+		if len(pool.NodeSelector) == 0 {
+			return fmt.Errorf("node pool %d %q has no node selector", i, pool.Name)
+		}
+		if pool.MemoryUnit != "" {
+			if _, err := ParseMemoryUnit(pool.MemoryUnit); err != nil {
+				return fmt.Errorf("node pool %d %q: %v", i, pool.Name, err)
+			}
+		}
+		if _, err := ParseMemoryReservation(pool.ReservedMemory); err != nil {
+			return fmt.Errorf("node pool %d %q: %v", i, pool.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// queriesKubelet tells if the pods are queried from kubelet on any node
+func (c Config) queriesKubelet() bool {
+	for _, pool := range c.NodePools {
+		if pool.QueryKubelet != nil && *pool.QueryKubelet {
+			return true
+		}
+	}
+	return c.QueryKubelet
+}
+
+// forNode returns the config of the node with the given labels, the node pools are applied in order
+func (c Config) forNode(nodeLabels map[string]string) Config {
+	config := c
+	config.NodePools = nil
+
+	for _, pool := range c.NodePools {
+		if !labels.SelectorFromSet(pool.NodeSelector).Matches(labels.Set(nodeLabels)) {
+			continue
+		}
+		if pool.MPS != nil {
+			config.MPS = *pool.MPS
+		}
+		if pool.HealthCheck != nil {
+			config.HealthCheck = *pool.HealthCheck
+		}
+		if pool.MemoryUnit != "" {
+			config.MemoryUnit = pool.MemoryUnit
+		}
+		if pool.ReservedMemory != "" {
+			config.ReservedMemory = pool.ReservedMemory
+		}
+		if pool.QueryKubelet != nil {
+			config.QueryKubelet = *pool.QueryKubelet
+		}
+	}
+
+	return config
+}
+
+// restartRequired returns true if the config can't be reloaded without restarting the device plugin,
+// only HealthCheck and ReservedMemory are reloaded on the fly
+func (c Config) restartRequired(old Config) bool {
+	return len(c.restartFields(old)) > 0
+}
+
+// restartFields returns the fields changed from the old config which restart the device plugin
+func (c Config) restartFields(old Config) []string {
+	fields := []string{}
+	value, oldValue := reflect.ValueOf(c), reflect.ValueOf(old)
+	for i := 0; i < value.NumField(); i++ {
+		name := strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "healthCheck" || name == "reservedMemory" {
+			continue
+		}
+		if !reflect.DeepEqual(value.Field(i).Interface(), oldValue.Field(i).Interface()) {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// defaultNodeSettings returns the settings which are overridden by the node labels and annotations
+func (c Config) defaultNodeSettings() nodeSettings {
+	// the config is validated already
+	reservation, _ := ParseMemoryReservation(c.ReservedMemory)
+	return nodeSettings{
+		healthCheck: c.HealthCheck,
+		reservation: reservation,
+	}
+}
+
+// memoryUnit returns the memory unit, the config is validated already
+func (c Config) memoryUnit() MemoryUnit {
+	unit, _ := ParseMemoryUnit(c.MemoryUnit)
+	return unit
+}
+
+// newClient builds the kubelet client, the service account token is used if no credential is set.
+// The token file is read again when it's rotated, since the projected service account token expires.
+func (k KubeletConfig) newClient() (*client.KubeletClient, error) {
+	tokenFile := k.TokenFile
+	if k.ClientCert == "" && k.ClientKey == "" && k.Token == "" && tokenFile == "" {
+		tokenFile = serviceAccountTokenFile
+	}
+	insecure := k.InsecureSkipTLSVerify
+	if k.unverified() {
+		log.Warningf("The serving certificate of kubelet at %s isn't verified since none of caFile, caData and serverName is set. "+
+			"It's deprecated and will be rejected in the next release, set one of them or insecureSkipTLSVerify", k.Address)
+		insecure = true
+	}
+
+	return client.NewKubeletClient(&client.KubeletClientConfig{
+		Address: k.Address,
+		Port:    k.Port,
+		TLSClientConfig: rest.TLSClientConfig{
+			Insecure:   insecure,
+			ServerName: k.ServerName,
+			CAFile:     k.CAFile,
+			CAData:     k.CAData,
+			CertFile:   k.ClientCert,
+			KeyFile:    k.ClientKey,
+		},
+		BearerToken:     k.Token,
+		BearerTokenFile: tokenFile,
+		HTTPTimeout:     time.Duration(k.Timeout) * time.Second,
+	})
+}
@@ -0,0 +1,412 @@
+package nvidia
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	v1 "k8s.io/api/core/v1"
+)
+
+// testConfig returns a valid config as the flags build it
+func testConfig() Config {
+	return Config{
+		Version:         ConfigVersion,
+		MemoryUnit:      "GiB",
+		AssignmentStore: AssignmentStoreAnnotation,
+		ProcRoot:        DefaultProcRoot,
+		Driver:          DefaultDriverConfig(),
+		Kubelet: KubeletConfig{
+			Address: "10.0.0.1",
+			Port:    10250,
+			Timeout: 10,
+		},
+	}
+}
+
+func writeConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "gpushare-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "config.yaml")
+	if err = ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return file, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadConfig(t *testing.T) {
+	file, cleanup := writeConfig(t, `
+version: v1
+memoryUnit: 256MiB
+reservedMemory: 5%
+kubelet:
+  port: 10255
+nodePools:
+- name: training
+  nodeSelector:
+    pool: training
+  mps: true
+`)
+	defer cleanup()
+
+	defaults := testConfig()
+	defaults.HealthCheck = true
+	defaults.NodePools = []NodePoolConfig{{Name: "flag", NodeSelector: map[string]string{"a": "b"}}}
+
+	config, err := LoadConfig(file, defaults)
+	if err != nil {
+		t.Fatalf("failed to load the config: %v", err)
+	}
+	if config.MemoryUnit != "256MiB" || config.ReservedMemory != "5%" || config.Kubelet.Port != 10255 {
+		t.Errorf("expected the fields in the file to be loaded, got %+v", config)
+	}
+	// the fields which are not in the file keep the defaults
+	if !config.HealthCheck || config.Kubelet.Address != "10.0.0.1" || config.Kubelet.Timeout != 10 {
+		t.Errorf("expected the defaults to be kept, got %+v", config)
+	}
+	if len(config.NodePools) != 1 || config.NodePools[0].Name != "training" || config.NodePools[0].MPS == nil || !*config.NodePools[0].MPS {
+		t.Errorf("expected only the node pools in the file, got %+v", config.NodePools)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "misspelled field",
+			content:  "version: v1\nqueryKublet: true\n",
+			expected: `unknown field "queryKublet"`,
+		},
+		{
+			name:     "misspelled nested field",
+			content:  "version: v1\nkubelet:\n  adress: 10.0.0.1\n",
+			expected: `unknown field "kubelet.adress"`,
+		},
+		{
+			name:     "misspelled field of a node pool",
+			content:  "version: v1\nnodePools:\n- nodeSelector:\n    a: b\n  mpss: true\n",
+			expected: `unknown field "nodePools[0].mpss"`,
+		},
+		{
+			name:     "invalid type",
+			content:  "version: v1\nmps: yes please\n",
+			expected: "failed to parse",
+		},
+		{
+			name:     "invalid config",
+			content:  "version: v2\n",
+			expected: `unsupported version "v2"`,
+		},
+	}
+
+	for _, test := range tests {
+		file, cleanup := writeConfig(t, test.content)
+		_, err := LoadConfig(file, testConfig())
+		cleanup()
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.expected, err)
+		}
+	}
+
+	if _, err := LoadConfig("/nonexistent/config.yaml", testConfig()); err == nil {
+		t.Errorf("expected the missing file to fail")
+	}
+}
+
+func TestLoadConfigCaseInsensitive(t *testing.T) {
+	file, cleanup := writeConfig(t, "Version: v1\nQueryKubelet: true\nkubelet:\n  insecureSkipTLSVerify: true\n")
+	defer cleanup()
+
+	config, err := LoadConfig(file, testConfig())
+	if err != nil {
+		t.Fatalf("expected the fields to be matched as encoding/json does, got %v", err)
+	}
+	if !config.QueryKubelet {
+		t.Errorf("expected QueryKubelet to be loaded")
+	}
+}
+
+// TestLoadExampleConfig loads the config in the example ConfigMap
+func TestLoadExampleConfig(t *testing.T) {
+	data, err := ioutil.ReadFile("../../../device-plugin-config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMap := &v1.ConfigMap{}
+	if err = yaml.Unmarshal(data, configMap); err != nil {
+		t.Fatal(err)
+	}
+	file, cleanup := writeConfig(t, configMap.Data["config.yaml"])
+	defer cleanup()
+
+	if _, err = LoadConfig(file, testConfig()); err != nil {
+		t.Errorf("failed to load the example config: %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(c *Config)
+		expected string
+	}{
+		{
+			name:   "valid",
+			modify: func(c *Config) {},
+		},
+		{
+			name:     "version",
+			modify:   func(c *Config) { c.Version = "" },
+			expected: "unsupported version",
+		},
+		{
+			name:     "memory unit",
+			modify:   func(c *Config) { c.MemoryUnit = "TiB" },
+			expected: "unsupported memory unit",
+		},
+		{
+			name:     "reserved memory",
+			modify:   func(c *Config) { c.ReservedMemory = "100%" },
+			expected: "unsupported memory reservation",
+		},
+		{
+			name:     "kubelet port",
+			modify:   func(c *Config) { c.Kubelet.Port = 65536 },
+			expected: "invalid kubelet port",
+		},
+		{
+			name:     "cdi spec dir",
+			modify:   func(c *Config) { c.CDI, c.CDISpecDir = true, "" },
+			expected: "cdiSpecDir is required",
+		},
+		{
+			name:     "driver host root",
+			modify:   func(c *Config) { c.DeviceSpecs, c.Driver.HostRoot = true, "" },
+			expected: "driver.hostRoot is required",
+		},
+		{
+			name:     "driver container dirs",
+			modify:   func(c *Config) { c.DeviceSpecs, c.Driver.ContainerLibraryDir = true, "lib64" },
+			expected: "should be absolute paths",
+		},
+		{
+			name:     "assignment store",
+			modify:   func(c *Config) { c.AssignmentStore = "etcd" },
+			expected: "unknown assignmentStore",
+		},
+		{
+			name:     "assignment file",
+			modify:   func(c *Config) { c.AssignmentStore, c.AssignmentFile = AssignmentStoreFile, "assignments.json" },
+			expected: "assignmentFile should be an absolute path",
+		},
+		{
+			name:     "assumed pod ttl",
+			modify:   func(c *Config) { c.AssumedPodTTL = -1 },
+			expected: "invalid assumedPodTTL",
+		},
+		{
+			name:     "memory usage interval",
+			modify:   func(c *Config) { c.MemoryUsageInterval = -1 },
+			expected: "invalid memoryUsageInterval",
+		},
+		{
+			name:     "enforcer",
+			modify:   func(c *Config) { c.Enforcer.Action = "evict" },
+			expected: "evict",
+		},
+		{
+			name:     "proc root",
+			modify:   func(c *Config) { c.MemoryUsageInterval, c.ProcRoot = 10, "proc" },
+			expected: "procRoot should be an absolute path",
+		},
+		{
+			name:     "kubelet timeout",
+			modify:   func(c *Config) { c.Kubelet.Timeout = 0 },
+			expected: "invalid kubelet timeout",
+		},
+		{
+			name:     "kubelet token",
+			modify:   func(c *Config) { c.Kubelet.Token, c.Kubelet.TokenFile = "token", "/var/run/token" },
+			expected: "can't be both set",
+		},
+		{
+			name: "kubelet insecure with ca",
+			modify: func(c *Config) {
+				c.Kubelet.InsecureSkipTLSVerify, c.Kubelet.CAFile = true, "/etc/kubernetes/pki/ca.crt"
+			},
+			expected: "can't be set with insecureSkipTLSVerify",
+		},
+		{
+			// deprecated, kubelet isn't verified with a warning
+			name:   "kubelet unverified",
+			modify: func(c *Config) { c.QueryKubelet = true },
+		},
+		{
+			name:   "kubelet ca",
+			modify: func(c *Config) { c.QueryKubelet, c.Kubelet.CAFile = true, "/etc/kubernetes/pki/ca.crt" },
+		},
+		{
+			name:   "kubelet server name",
+			modify: func(c *Config) { c.QueryKubelet, c.Kubelet.ServerName = true, "node-1" },
+		},
+		{
+			name:   "kubelet insecure",
+			modify: func(c *Config) { c.QueryKubelet, c.Kubelet.InsecureSkipTLSVerify = true, true },
+		},
+		{
+			name:     "node pool selector",
+			modify:   func(c *Config) { c.NodePools = []NodePoolConfig{{Name: "all"}} },
+			expected: `node pool 0 "all" has no node selector`,
+		},
+		{
+			name: "node pool memory unit",
+			modify: func(c *Config) {
+				c.NodePools = []NodePoolConfig{{Name: "a", NodeSelector: map[string]string{"a": "b"}, MemoryUnit: "1.5GiB"}}
+			},
+			expected: `node pool 0 "a"`,
+		},
+		{
+			name: "node pool reserved memory",
+			modify: func(c *Config) {
+				c.NodePools = []NodePoolConfig{{Name: "a", NodeSelector: map[string]string{"a": "b"}, ReservedMemory: "5"}}
+			},
+			expected: `node pool 0 "a"`,
+		},
+	}
+
+	for _, test := range tests {
+		config := testConfig()
+		test.modify(&config)
+		err := config.Validate()
+		if test.expected == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestConfigForNode(t *testing.T) {
+	yes, no := true, false
+	config := testConfig()
+	config.ReservedMemory = "256MiB"
+	config.NodePools = []NodePoolConfig{
+		{Name: "gpu", NodeSelector: map[string]string{"gpu": "true"}, MPS: &yes, MemoryUnit: "512MiB", QueryKubelet: &yes},
+		{Name: "inference", NodeSelector: map[string]string{"gpu": "true", "pool": "inference"}, MPS: &no, ReservedMemory: "5%"},
+		{Name: "training", NodeSelector: map[string]string{"pool": "training"}, HealthCheck: &yes},
+	}
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected func(c *Config)
+	}{
+		{
+			name:     "no pool",
+			labels:   map[string]string{"gpu": "false"},
+			expected: func(c *Config) {},
+		},
+		{
+			name:   "one pool",
+			labels: map[string]string{"gpu": "true"},
+			expected: func(c *Config) {
+				c.MPS, c.MemoryUnit, c.QueryKubelet = true, "512MiB", true
+			},
+		},
+		{
+			name:   "the latter pool wins",
+			labels: map[string]string{"gpu": "true", "pool": "inference"},
+			expected: func(c *Config) {
+				c.MPS, c.MemoryUnit, c.QueryKubelet, c.ReservedMemory = false, "512MiB", true, "5%"
+			},
+		},
+		{
+			name:   "other pool",
+			labels: map[string]string{"pool": "training"},
+			expected: func(c *Config) {
+				c.HealthCheck = true
+			},
+		},
+	}
+
+	for _, test := range tests {
+		expected := testConfig()
+		expected.ReservedMemory = "256MiB"
+		test.expected(&expected)
+
+		nodeConfig := config.forNode(test.labels)
+		if nodeConfig.NodePools != nil {
+			t.Errorf("%s: expected the node pools to be cleared", test.name)
+		}
+		if nodeConfig.restartRequired(expected) || nodeConfig.HealthCheck != expected.HealthCheck ||
+			nodeConfig.ReservedMemory != expected.ReservedMemory {
+			t.Errorf("%s: expected %+v, got %+v", test.name, expected, nodeConfig)
+		}
+	}
+}
+
+func TestConfigRestartRequired(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(c *Config)
+		expected bool
+	}{
+		{name: "unchanged", modify: func(c *Config) {}},
+		{name: "health check", modify: func(c *Config) { c.HealthCheck = true }},
+		{name: "reserved memory", modify: func(c *Config) { c.ReservedMemory = "1GiB" }},
+		{name: "memory unit", modify: func(c *Config) { c.MemoryUnit = "MiB" }, expected: true},
+		{name: "mps", modify: func(c *Config) { c.MPS = true }, expected: true},
+		{name: "query kubelet", modify: func(c *Config) { c.QueryKubelet = true }, expected: true},
+		{name: "kubelet", modify: func(c *Config) { c.Kubelet.ServerName = "node1" }, expected: true},
+		{name: "cdi", modify: func(c *Config) { c.CDI = true }, expected: true},
+		{name: "enforcer", modify: func(c *Config) { c.Enforcer.Action = EnforceActionEvent }, expected: true},
+	}
+
+	for _, test := range tests {
+		config := testConfig()
+		test.modify(&config)
+		if restart := config.restartRequired(testConfig()); restart != test.expected {
+			t.Errorf("%s: expected restart required %t, got %t", test.name, test.expected, restart)
+		}
+	}
+
+	config := testConfig()
+	config.MemoryUnit, config.HealthCheck, config.Kubelet.ServerName = "MiB", true, "node1"
+	if fields := config.restartFields(testConfig()); !reflect.DeepEqual(fields, []string{"memoryUnit", "kubelet"}) {
+		t.Errorf("expected memoryUnit and kubelet to restart the device plugin, got %v", fields)
+	}
+}
+
+func TestKubeletConfigUnverified(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   KubeletConfig
+		expected bool
+	}{
+		{name: "nothing set", expected: true},
+		{name: "ca file", config: KubeletConfig{CAFile: "/etc/kubernetes/pki/ca.crt"}},
+		{name: "ca data", config: KubeletConfig{CAData: []byte("ca")}},
+		{name: "server name", config: KubeletConfig{ServerName: "node-1"}},
+		{name: "insecure", config: KubeletConfig{InsecureSkipTLSVerify: true}},
+	}
+
+	for _, test := range tests {
+		if actual := test.config.unverified(); actual != test.expected {
+			t.Errorf("%s: expected unverified %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
@@ -1,19 +1,19 @@
 package nvidia
 
 import (
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
 	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
 )
 
-// MemoryUnit describes GPU Memory, now only supports Gi, Mi
+// MemoryUnit describes the GPU Memory of one fake device, it supports GiB, MiB
+// and a multiple of them such as 256MiB
 type MemoryUnit string
 
 const (
-	resourceName  = "aliyun.com/gpu-mem"
-	resourceCount = "aliyun.com/gpu-count"
+	resourceName  = gpushare.ResourceName
+	resourceCount = gpushare.ResourceCount
 	serverSock    = pluginapi.DevicePluginPath + "aliyungpushare.sock"
 
-	OptimisticLockErrorMsg = "the object has been modified; please apply your changes to the latest version and try again"
-
 	allHealthChecks             = "xids"
 	containerTypeLabelKey       = "io.kubernetes.docker.type"
 	containerTypeLabelSandbox   = "podsandbox"
@@ -22,15 +22,34 @@ const (
 	sandboxIDLabelKey           = "io.kubernetes.sandbox.id"
 
 	envNVGPU                   = "NVIDIA_VISIBLE_DEVICES"
-	EnvResourceIndex           = "ALIYUN_COM_GPU_MEM_IDX"
+	EnvResourceIndex           = gpushare.EnvResourceIndex
 	EnvResourceByPod           = "ALIYUN_COM_GPU_MEM_POD"
 	EnvResourceByContainer     = "ALIYUN_COM_GPU_MEM_CONTAINER"
 	EnvResourceByDev           = "ALIYUN_COM_GPU_MEM_DEV"
-	EnvAssignedFlag            = "ALIYUN_COM_GPU_MEM_ASSIGNED"
-	EnvResourceAssumeTime      = "ALIYUN_COM_GPU_MEM_ASSUME_TIME"
+	EnvResourceUnit            = "ALIYUN_COM_GPU_MEM_UNIT"
+	EnvAssignedFlag            = gpushare.EnvAssignedFlag
+	EnvResourceAssumeTime      = gpushare.EnvResourceAssumeTime
 	EnvResourceAssignTime      = "ALIYUN_COM_GPU_MEM_ASSIGN_TIME"
 	EnvNodeLabelForDisableCGPU = "cgpu.disable.isolation"
 
+	// AssignedFlagExpired marks the assumed pod which is not allocated in time,
+	// so it's no longer a candidate of Allocate
+	AssignedFlagExpired = gpushare.AssignedFlagExpired
+
+	NodeLabelForReservedMemory   = "aliyun.com/gpu-mem-reserve"
+	NodeLabelForHealthCheck      = "aliyun.com/gpu-health-check"
+	NodeAnnotationMemoryUnit     = gpushare.NodeAnnotationMemoryUnit
+	NodeAnnotationReservedMemory = "aliyun.com/gpu-mem-reserved"
+	NodeAnnotationMemoryUsage    = gpushare.NodeAnnotationMemoryUsage
+
+	NodeLabelGPUName       = "aliyun.accelerator/nvidia_name"
+	NodeLabelGPUMemory     = "aliyun.accelerator/nvidia_mem"
+	NodeLabelGPUCount      = "aliyun.accelerator/nvidia_count"
+	NodeLabelDriverVersion = "aliyun.accelerator/nvidia_driver"
+	NodeLabelCUDAVersion   = "aliyun.accelerator/nvidia_cuda"
+	NodeLabelMPSMode       = "aliyun.accelerator/gpushare_mps"
+	NodeLabelMIGMode       = "aliyun.accelerator/nvidia_mig"
+
 	GiBPrefix = MemoryUnit("GiB")
 	MiBPrefix = MemoryUnit("MiB")
 )
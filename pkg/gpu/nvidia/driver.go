@@ -0,0 +1,178 @@
+package nvidia
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/golang/glog"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// maxSymlinkDepth is the max symlinks followed when resolving a driver file on the host
+const maxSymlinkDepth = 16
+
+// DriverConfig describes where to find the driver files on the host, and where to mount them
+// into the containers when the device plugin returns the device specs instead of relying on
+// the nvidia container runtime
+type DriverConfig struct {
+	// HostRoot is where the root of the host is mounted in the device plugin, e.g. /host
+	HostRoot string `json:"hostRoot"`
+	// LibraryDirs and BinaryDirs are searched on the host in order
+	LibraryDirs []string `json:"libraryDirs"`
+	BinaryDirs  []string `json:"binaryDirs"`
+	// Libraries and Binaries are the glob patterns of the driver files
+	Libraries []string `json:"libraries"`
+	Binaries  []string `json:"binaries"`
+	// ContainerLibraryDir and ContainerBinaryDir are where the driver files are mounted in the containers
+	ContainerLibraryDir string `json:"containerLibraryDir"`
+	ContainerBinaryDir  string `json:"containerBinaryDir"`
+}
+
+// DefaultDriverConfig returns the driver config which works with the CUDA images,
+// they have /usr/local/nvidia/lib64 in LD_LIBRARY_PATH and /usr/local/nvidia/bin in PATH
+func DefaultDriverConfig() DriverConfig {
+	return DriverConfig{
+		HostRoot: "/",
+		LibraryDirs: []string{
+			"/usr/lib64",
+			"/usr/lib/x86_64-linux-gnu",
+			"/usr/lib",
+		},
+		BinaryDirs: []string{
+			"/usr/bin",
+		},
+		Libraries: []string{
+			"libcuda.so*",
+			"libnvidia-ml.so*",
+			"libnvidia-ptxjitcompiler.so*",
+			"libnvidia-fatbinaryloader.so*",
+			"libnvidia-compiler.so*",
+			"libnvidia-opencl.so*",
+		},
+		Binaries: []string{
+			"nvidia-smi",
+			"nvidia-debugdump",
+		},
+		ContainerLibraryDir: "/usr/local/nvidia/lib64",
+		ContainerBinaryDir:  "/usr/local/nvidia/bin",
+	}
+}
+
+// discoverDriverMounts finds the driver files on the host, the first one wins if a file
+// is found in more than one directory
+func discoverDriverMounts(config DriverConfig) ([]*pluginapi.Mount, error) {
+	mounts := []*pluginapi.Mount{}
+	found := map[string]bool{}
+
+	discover := func(dirs, patterns []string, containerDir string) error {
+		for _, dir := range dirs {
+			for _, pattern := range patterns {
+				matches, err := filepath.Glob(filepath.Join(config.HostRoot, dir, pattern))
+				if err != nil {
+					return err
+				}
+				for _, match := range matches {
+					containerPath := filepath.Join(containerDir, filepath.Base(match))
+					if found[containerPath] {
+						continue
+					}
+					hostPath, err := resolveHostPath(config.HostRoot, match)
+					if err != nil {
+						log.Warningf("Skip driver file %s due to %v", match, err)
+						continue
+					}
+					found[containerPath] = true
+					mounts = append(mounts, &pluginapi.Mount{
+						ContainerPath: containerPath,
+						HostPath:      hostPath,
+						ReadOnly:      true,
+					})
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := discover(config.LibraryDirs, config.Libraries, config.ContainerLibraryDir); err != nil {
+		return nil, err
+	}
+	if err := discover(config.BinaryDirs, config.Binaries, config.ContainerBinaryDir); err != nil {
+		return nil, err
+	}
+	if len(mounts) == 0 {
+		return nil, fmt.Errorf("no driver file is found in %s", config.HostRoot)
+	}
+
+	return mounts, nil
+}
+
+// resolveHostPath follows the symlinks of the file under the host root, and returns
+// the path of the regular file on the host
+func resolveHostPath(hostRoot, path string) (string, error) {
+	for i := 0; i < maxSymlinkDepth; i++ {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			if info.IsDir() {
+				return "", fmt.Errorf("%s is a directory", path)
+			}
+			return hostPathOf(hostRoot, path)
+		}
+
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			path = filepath.Join(hostRoot, target)
+		} else {
+			path = filepath.Join(filepath.Dir(path), target)
+		}
+	}
+
+	return "", fmt.Errorf("too many levels of symbolic links")
+}
+
+// hostPathOf converts the path in the device plugin to the path on the host
+func hostPathOf(hostRoot, path string) (string, error) {
+	rel, err := filepath.Rel(hostRoot, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s is out of the host root %s", path, hostRoot)
+	}
+
+	return filepath.Join("/", rel), nil
+}
+
+// discoverControlDeviceSpecs finds the device nodes shared by all the GPUs on the host
+func discoverControlDeviceSpecs(hostRoot string) []*pluginapi.DeviceSpec {
+	specs := []*pluginapi.DeviceSpec{}
+	for _, path := range controlDeviceNodes {
+		if _, err := os.Stat(filepath.Join(hostRoot, path)); err != nil {
+			log.Warningf("Skip device %s due to %v", path, err)
+			continue
+		}
+		specs = append(specs, &pluginapi.DeviceSpec{
+			ContainerPath: path,
+			HostPath:      path,
+			Permissions:   "rw",
+		})
+	}
+
+	return specs
+}
+
+// gpuDeviceSpecs returns the device nodes of the GPU with the index and the control devices
+func gpuDeviceSpecs(devIndex uint, controls []*pluginapi.DeviceSpec) []*pluginapi.DeviceSpec {
+	path := fmt.Sprintf("/dev/nvidia%d", devIndex)
+	specs := []*pluginapi.DeviceSpec{{
+		ContainerPath: path,
+		HostPath:      path,
+		Permissions:   "rw",
+	}}
+
+	return append(specs, controls...)
+}
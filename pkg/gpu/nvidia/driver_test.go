@@ -0,0 +1,126 @@
+package nvidia
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// newHostRoot creates a fixture tree of the driver files on the host
+func newHostRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "hostroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{
+		"/dev/nvidia0",
+		"/dev/nvidiactl",
+		"/dev/nvidia-uvm",
+		"/usr/lib/x86_64-linux-gnu/libcuda.so.418.87.01",
+		"/usr/lib/x86_64-linux-gnu/libnvidia-ml.so.418.87.01",
+		"/usr/lib/libnvidia-ml.so.418.87.01",
+		"/usr/bin/nvidia-smi",
+	}
+	for _, f := range files {
+		path := filepath.Join(root, f)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	links := map[string]string{
+		// relative symlink
+		"/usr/lib/x86_64-linux-gnu/libcuda.so.1": "libcuda.so.418.87.01",
+		// absolute symlink which is resolved under the host root
+		"/usr/lib/x86_64-linux-gnu/libnvidia-ml.so.1": "/usr/lib/x86_64-linux-gnu/libnvidia-ml.so.418.87.01",
+	}
+	for link, target := range links {
+		if err = os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func TestDiscoverDriverMounts(t *testing.T) {
+	root := newHostRoot(t)
+	defer os.RemoveAll(root)
+
+	config := DefaultDriverConfig()
+	config.HostRoot = root
+	mounts, err := discoverDriverMounts(config)
+	if err != nil {
+		t.Fatalf("failed to discover driver files: %v", err)
+	}
+
+	expected := map[string]string{
+		"/usr/local/nvidia/lib64/libcuda.so.1":              "/usr/lib/x86_64-linux-gnu/libcuda.so.418.87.01",
+		"/usr/local/nvidia/lib64/libcuda.so.418.87.01":      "/usr/lib/x86_64-linux-gnu/libcuda.so.418.87.01",
+		"/usr/local/nvidia/lib64/libnvidia-ml.so.1":         "/usr/lib/x86_64-linux-gnu/libnvidia-ml.so.418.87.01",
+		"/usr/local/nvidia/lib64/libnvidia-ml.so.418.87.01": "/usr/lib/x86_64-linux-gnu/libnvidia-ml.so.418.87.01",
+		"/usr/local/nvidia/bin/nvidia-smi":                  "/usr/bin/nvidia-smi",
+	}
+	got := map[string]string{}
+	for _, m := range mounts {
+		if !m.ReadOnly {
+			t.Errorf("expected %s to be mounted read only", m.ContainerPath)
+		}
+		got[m.ContainerPath] = m.HostPath
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected mounts %v, got %v", expected, got)
+	}
+}
+
+func TestDiscoverDriverMountsNotFound(t *testing.T) {
+	root, err := ioutil.TempDir("", "hostroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	config := DefaultDriverConfig()
+	config.HostRoot = root
+	if _, err = discoverDriverMounts(config); err == nil {
+		t.Errorf("expected error without driver files")
+	}
+}
+
+func TestResolveHostPathOutOfRoot(t *testing.T) {
+	root := newHostRoot(t)
+	defer os.RemoveAll(root)
+
+	link := filepath.Join(root, "/usr/lib/libescape.so")
+	if err := os.Symlink("../../../../../../etc/passwd", link); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := resolveHostPath(root, link); err == nil {
+		t.Errorf("expected error for the symlink out of the host root, got %s", path)
+	}
+}
+
+func TestGPUDeviceSpecs(t *testing.T) {
+	root := newHostRoot(t)
+	defer os.RemoveAll(root)
+
+	controls := discoverControlDeviceSpecs(root)
+	specs := gpuDeviceSpecs(0, controls)
+
+	expected := []*pluginapi.DeviceSpec{
+		{ContainerPath: "/dev/nvidia0", HostPath: "/dev/nvidia0", Permissions: "rw"},
+		{ContainerPath: "/dev/nvidiactl", HostPath: "/dev/nvidiactl", Permissions: "rw"},
+		{ContainerPath: "/dev/nvidia-uvm", HostPath: "/dev/nvidia-uvm", Permissions: "rw"},
+	}
+	if !reflect.DeepEqual(specs, expected) {
+		t.Errorf("expected device specs %v, got %v", expected, specs)
+	}
+}
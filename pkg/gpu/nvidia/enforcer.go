@@ -0,0 +1,354 @@
+package nvidia
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// The actions of the enforcer on the containers which use more GPU memory than their gpu-mem
+const (
+	// EnforceActionEvent records a warning event of the pod
+	EnforceActionEvent = "event"
+	// EnforceActionAnnotate records the over-use in the pod annotation PodAnnotationMemoryOverUse
+	EnforceActionAnnotate = "annotate"
+	// EnforceActionKill kills the GPU processes of the container
+	EnforceActionKill = "kill"
+)
+
+// PodAnnotationMemoryOverUse is the last over-use of the GPU memory found in the pod by the enforcer
+const PodAnnotationMemoryOverUse = "aliyun.com/gpu-mem-overuse"
+
+// EnforcerConfig is the config of the enforcer of the GPU memory used by the containers, it's
+// disabled if Action is empty
+type EnforcerConfig struct {
+	// Action is event, annotate or kill
+	Action string `json:"action,omitempty"`
+	// DryRun only logs the over-use and the action
+	DryRun bool `json:"dryRun"`
+	// Interval is the seconds between sampling the GPU processes
+	Interval int `json:"interval"`
+	// Tolerance is the memory a container may use over its gpu-mem, e.g. 256MiB or 10% of the gpu-mem
+	Tolerance string `json:"tolerance,omitempty"`
+}
+
+// Validate checks the config of the enforcer
+func (c EnforcerConfig) Validate() error {
+	switch c.Action {
+	case "":
+		return nil
+	case EnforceActionEvent, EnforceActionAnnotate, EnforceActionKill:
+	default:
+		return fmt.Errorf("unknown enforcer action %q, it should be event, annotate or kill", c.Action)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("invalid enforcer interval %d", c.Interval)
+	}
+	if _, err := ParseMemoryReservation(c.Tolerance); err != nil {
+		return fmt.Errorf("invalid enforcer tolerance: %v", err)
+	}
+	return nil
+}
+
+// containerUsage is the GPU memory used by the processes of a container
+type containerUsage struct {
+	podUID      types.UID
+	containerID string
+	// limit is the gpu-mem of the container in MiB from the pod spec, or ALIYUN_COM_GPU_MEM_CONTAINER if
+	// the pod is not found
+	limit uint64
+	// used is the memory used on all the GPUs in MiB
+	used uint64
+	pids []uint
+}
+
+func (c *containerUsage) String() string {
+	return fmt.Sprintf("container %s of pod %s", c.containerID, c.podUID)
+}
+
+// gpuMemoryOfProcess returns the gpu-mem of the container of the process from its environ in the proc root,
+// it's false if the container is not allocated by the device plugin
+func gpuMemoryOfProcess(procRoot string, pid uint) (uint, bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(procRoot, fmt.Sprintf("%d", pid), "environ"))
+	if err != nil {
+		return 0, false, err
+	}
+
+	prefix := []byte(EnvResourceByContainer + "=")
+	for _, env := range bytes.Split(data, []byte{0}) {
+		if !bytes.HasPrefix(env, prefix) {
+			continue
+		}
+		value, err := strconv.ParseUint(string(env[len(prefix):]), 10, 32)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid %s of process %d: %v", EnvResourceByContainer, pid, err)
+		}
+		return uint(value), true, nil
+	}
+	return 0, false, nil
+}
+
+// gpuMemoryOfPodContainer returns the gpu-mem of the container in the pod spec by its ID in the pod status,
+// it's false if the container is not in the status yet
+func gpuMemoryOfPodContainer(pod *v1.Pod, containerID string) (uint, bool) {
+	if containerID == "" {
+		return 0, false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		// the ID in the status is <runtime>://<id>, the ID in the cgroup may be shortened
+		id := status.ContainerID
+		if i := strings.Index(id, "://"); i >= 0 {
+			id = id[i+len("://"):]
+		}
+		if !strings.HasPrefix(id, containerID) {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			if container.Name == status.Name {
+				val := container.Resources.Limits[resourceName]
+				return uint(val.Value()), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// gpuMemoryOfContainer returns the gpu-mem of the container of the process, it's false if the container is not
+// allocated by the device plugin. The pod spec is the authority as the container can change its environ, which is
+// only the fallback if the pod is not found
+func gpuMemoryOfContainer(procRoot string, pid uint, pod *v1.Pod, containerID string) (uint, bool) {
+	memory, ok, err := gpuMemoryOfProcess(procRoot, pid)
+	if err != nil {
+		log.V(4).Infof("Failed to get the gpu-mem of process %d due to %v", pid, err)
+		ok = false
+	}
+	if pod == nil {
+		return memory, ok
+	}
+
+	if limit, found := gpuMemoryOfPodContainer(pod, containerID); found {
+		return limit, limit > 0
+	}
+	// the container can't use more than its pod
+	limit := getGPUMemoryFromPodResource(pod)
+	if ok && memory < limit {
+		limit = memory
+	}
+	return limit, limit > 0
+}
+
+// findOverUses attributes the GPU processes to the containers of the pods by their cgroups, and returns the
+// containers which use more memory than their gpu-mem and the tolerance, sorted by the pod and the container
+func findOverUses(usages []*gpuUsage, procRoot string, pods []v1.Pod, unit MemoryUnit, tolerance MemoryReservation) []*containerUsage {
+	byUID := map[types.UID]*v1.Pod{}
+	for i := range pods {
+		byUID[pods[i].UID] = &pods[i]
+	}
+
+	// the containers not allocated by the device plugin are nil
+	containers := map[string]*containerUsage{}
+	for _, usage := range usages {
+		for _, p := range usage.Processes {
+			uid, containerID, err := containerOfProcess(procRoot, p.PID)
+			if err != nil || uid == "" {
+				// the process may have exited, or it's not in a pod
+				continue
+			}
+
+			key := string(uid) + "/" + containerID
+			if containerID == "" {
+				key = fmt.Sprintf("%s/%d", uid, p.PID)
+			}
+			c, ok := containers[key]
+			if !ok {
+				if memory, allocated := gpuMemoryOfContainer(procRoot, p.PID, byUID[uid], containerID); allocated {
+					c = &containerUsage{podUID: uid, containerID: containerID, limit: uint64(memory * unit.MiB())}
+				}
+				containers[key] = c
+			}
+			if c == nil {
+				continue
+			}
+			c.used += p.Memory
+			c.pids = append(c.pids, p.PID)
+		}
+	}
+
+	overUses := []*containerUsage{}
+	for _, c := range containers {
+		if c != nil && c.used > c.limit+uint64(tolerance.Of(uint(c.limit))) {
+			overUses = append(overUses, c)
+		}
+	}
+	sort.Slice(overUses, func(i, j int) bool {
+		if overUses[i].podUID != overUses[j].podUID {
+			return overUses[i].podUID < overUses[j].podUID
+		}
+		return overUses[i].containerID < overUses[j].containerID
+	})
+	return overUses
+}
+
+// enforcer applies the action to the containers which use more GPU memory than their gpu-mem
+type enforcer struct {
+	action    string
+	dryRun    bool
+	interval  time.Duration
+	tolerance MemoryReservation
+	procRoot  string
+	// overUsing are the containers over-using the memory in the last sample, they're counted once
+	overUsing map[string]bool
+	// reported are the containers over-using the memory which are already reported, so they're not
+	// reported again until they stop
+	reported map[string]bool
+
+	// the actions, they're replaced in the tests
+	kill     func(pid uint) error
+	record   func(pod *v1.Pod, message string)
+	annotate func(pod *v1.Pod, message string) error
+}
+
+// newEnforcer creates the enforcer, it's nil if the enforcer is disabled
+func newEnforcer(config EnforcerConfig, procRoot string) *enforcer {
+	if config.Action == "" {
+		return nil
+	}
+	// the config is validated already
+	tolerance, _ := ParseMemoryReservation(config.Tolerance)
+	return &enforcer{
+		action:    config.Action,
+		dryRun:    config.DryRun,
+		interval:  time.Duration(config.Interval) * time.Second,
+		tolerance: tolerance,
+		procRoot:  procRoot,
+		overUsing: map[string]bool{},
+		reported:  map[string]bool{},
+		kill: func(pid uint) error {
+			return syscall.Kill(int(pid), syscall.SIGKILL)
+		},
+		record: func(pod *v1.Pod, message string) {
+			recordPodEvent(pod.Namespace, pod.Name, pod.UID, v1.EventTypeWarning, "GPUShareMemoryOverUse", message)
+		},
+		annotate: annotateOverUse,
+	}
+}
+
+// run samples the GPU processes and enforces the over-uses periodically, unit returns the memory unit of gpu-mem
+func (e *enforcer) run(unit func() MemoryUnit, stop <-chan struct{}) {
+	log.Infof("Enforcing the GPU memory of the containers by %s every %v, dry run: %v", e.action, e.interval, e.dryRun)
+	wait.Until(func() {
+		usages, err := backend.Usage()
+		if err != nil {
+			log.Warningf("Failed to get the GPU processes due to %v", err)
+			return
+		}
+		if !hasProcesses(usages) {
+			e.enforce(nil, nil)
+			return
+		}
+		pods, err := getActivePodsInNode()
+		if err != nil {
+			log.Warningf("Failed to get the pods of the GPU processes due to %v", err)
+			return
+		}
+		e.enforce(findOverUses(usages, e.procRoot, pods, unit(), e.tolerance), pods)
+	}, e.interval, stop)
+}
+
+func hasProcesses(usages []*gpuUsage) bool {
+	for _, usage := range usages {
+		if len(usage.Processes) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// enforce applies the action to the over-uses of the pods
+func (e *enforcer) enforce(overUses []*containerUsage, pods []v1.Pod) {
+	byUID := map[types.UID]*v1.Pod{}
+	for i := range pods {
+		byUID[pods[i].UID] = &pods[i]
+	}
+
+	overUsing := map[string]bool{}
+	for _, c := range overUses {
+		key := string(c.podUID) + "/" + c.containerID
+		overUsing[key] = true
+		if !e.overUsing[key] {
+			memoryOverUses.Add(1)
+		}
+
+		pod, ok := byUID[c.podUID]
+		if !ok {
+			log.Warningf("%s uses %dMiB GPU memory over its gpu-mem %dMiB, but the pod is not found", c, c.used, c.limit)
+			continue
+		}
+		message := fmt.Sprintf("container %s uses %dMiB GPU memory over its gpu-mem %dMiB and the tolerance %s",
+			c.containerID, c.used, c.limit, e.tolerance)
+		if e.dryRun {
+			log.Warningf("[dry run] %s of pod %s in ns %s, the action %s is not applied", message, pod.Name, pod.Namespace, e.action)
+			continue
+		}
+		log.Warningf("%s of pod %s in ns %s, applying %s", message, pod.Name, pod.Namespace, e.action)
+
+		switch e.action {
+		case EnforceActionEvent:
+			if !e.reported[key] {
+				e.record(pod, message)
+			}
+		case EnforceActionAnnotate:
+			if !e.reported[key] {
+				if err := e.annotate(pod, message); err != nil {
+					log.Warningf("Failed to annotate pod %s in ns %s due to %v", pod.Name, pod.Namespace, err)
+					continue
+				}
+			}
+		case EnforceActionKill:
+			for _, pid := range c.pids {
+				if err := e.kill(pid); err != nil {
+					log.Warningf("Failed to kill process %d of pod %s in ns %s due to %v", pid, pod.Name, pod.Namespace, err)
+					continue
+				}
+				killedProcesses.Add(1)
+			}
+			if !e.reported[key] {
+				e.record(pod, fmt.Sprintf("%s, killed its GPU processes %v", message, c.pids))
+			}
+		}
+		e.reported[key] = true
+	}
+
+	e.overUsing = overUsing
+	for key := range e.reported {
+		if !overUsing[key] {
+			delete(e.reported, key)
+		}
+	}
+}
+
+func annotateOverUse(pod *v1.Pod, message string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]map[string]string{"annotations": {
+			PodAnnotationMemoryOverUse: fmt.Sprintf("%s: %s", time.Now().Format(time.RFC3339), message),
+		}}})
+	if err != nil {
+		return err
+	}
+	return retryPatch(func() error {
+		_, err := clientset.CoreV1().Pods(pod.Namespace).Patch(pod.Name, types.StrategicMergePatchType, patch)
+		return err
+	})
+}
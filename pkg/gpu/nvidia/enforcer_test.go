@@ -0,0 +1,234 @@
+package nvidia
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	testPodA = types.UID("6a2b1c3d-1111-4e5f-8a9b-0123456789ab")
+	testPodB = types.UID("7b3c2d4e-2222-4f60-9bac-123456789abc")
+	testPodC = types.UID("8c4d3e5f-3333-4071-acbd-23456789abcd")
+)
+
+// newOverUseBackend returns the GPU processes in testdata/proc, the container of 1001 and 1006 in pod A uses
+// 2400MiB of the 2GiB in its environ, the container of 1005 in pod A stays in its 1GiB, pod B uses 3000MiB
+// of its 1GiB, 1003 in pod C has no gpu-mem in its environ and 1004 is on the host
+func newOverUseBackend() *fakeBackend {
+	b := newFakeBackend()
+	b.usages = []*gpuUsage{
+		{Index: 0, Used: 8000, Processes: []gpuProcess{
+			{PID: 1001, Memory: 1500},
+			{PID: 1005, Memory: 512},
+			{PID: 1004, Memory: 4000},
+		}},
+		{Index: 3, Used: 14000, Processes: []gpuProcess{
+			{PID: 1006, Memory: 900},
+			{PID: 1002, Memory: 3000},
+			{PID: 1003, Memory: 10000},
+		}},
+	}
+	return b
+}
+
+func newGPUMemoryContainer(name string, gpuMemory int64) v1.Container {
+	return v1.Container{
+		Name: name,
+		Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+			resourceName: *resource.NewQuantity(gpuMemory, resource.DecimalSI),
+		}},
+	}
+}
+
+// newOverUsePods returns the pods of the GPU processes, the container of 1001 and 1006 in pod A has 1GiB in
+// the spec, pod B has no container status yet and pod C has 4GiB
+func newOverUsePods() []v1.Pod {
+	return []v1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default", UID: testPodA},
+			Spec: v1.PodSpec{Containers: []v1.Container{
+				newGPUMemoryContainer("main", 1),
+				newGPUMemoryContainer("sidecar", 1),
+			}},
+			Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{
+				{Name: "main", ContainerID: "docker://4f3e2d1c0b9a8f7e6d5c4b3a29180706f5e4d3c2b1a0"},
+				{Name: "sidecar", ContainerID: "docker://7e6d5c4b3a29"},
+			}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "default", UID: testPodB},
+			Spec:       v1.PodSpec{Containers: []v1.Container{newGPUMemoryContainer("main", 1)}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "c", Namespace: "default", UID: testPodC},
+			Spec:       v1.PodSpec{Containers: []v1.Container{newGPUMemoryContainer("main", 4)}},
+			Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{
+				{Name: "main", ContainerID: "containerd://6b5c4d3e2f10a9b8c7d6e5f4"},
+			}},
+		},
+	}
+}
+
+func TestFindOverUses(t *testing.T) {
+	oldBackend := backend
+	defer func() { backend = oldBackend }()
+	backend = newOverUseBackend()
+
+	usages, _ := backend.Usage()
+	tolerance, _ := ParseMemoryReservation("10%")
+
+	// the environ is the fallback without the pods
+	overUses := findOverUses(usages, testProcRoot, nil, GiBPrefix, tolerance)
+	expected := []*containerUsage{
+		{podUID: testPodA, containerID: "4f3e2d1c0b9a8f7e6d5c4b3a29180706f5e4d3c2b1a0", limit: 2048, used: 2400, pids: []uint{1001, 1006}},
+		{podUID: testPodB, containerID: "5a4b3c2d1e0f", limit: 1024, used: 3000, pids: []uint{1002}},
+	}
+	if !reflect.DeepEqual(overUses, expected) {
+		t.Errorf("expected the over-uses %v, got %v", expected, overUses)
+	}
+
+	// the pod spec overrides the environ
+	overUses = findOverUses(usages, testProcRoot, newOverUsePods(), GiBPrefix, tolerance)
+	expected = []*containerUsage{
+		{podUID: testPodA, containerID: "4f3e2d1c0b9a8f7e6d5c4b3a29180706f5e4d3c2b1a0", limit: 1024, used: 2400, pids: []uint{1001, 1006}},
+		{podUID: testPodB, containerID: "5a4b3c2d1e0f", limit: 1024, used: 3000, pids: []uint{1002}},
+		{podUID: testPodC, containerID: "6b5c4d3e2f10", limit: 4096, used: 10000, pids: []uint{1003}},
+	}
+	if !reflect.DeepEqual(overUses, expected) {
+		t.Errorf("expected the over-uses by the pod spec %v, got %v", expected, overUses)
+	}
+
+	// pod A is in the tolerance of 512MiB
+	tolerance, _ = ParseMemoryReservation("512MiB")
+	overUses = findOverUses(usages, testProcRoot, nil, GiBPrefix, tolerance)
+	if len(overUses) != 1 || overUses[0].podUID != testPodB {
+		t.Errorf("expected only pod B to over-use with the tolerance, got %v", overUses)
+	}
+}
+
+// fakeActions records the actions of the enforcer
+type fakeActions struct {
+	killed    []uint
+	events    []string
+	annotated []string
+	err       error
+}
+
+func newTestEnforcer(action string, dryRun bool) (*enforcer, *fakeActions) {
+	actions := &fakeActions{}
+	e := newEnforcer(EnforcerConfig{Action: action, DryRun: dryRun, Interval: 10, Tolerance: "10%"}, testProcRoot)
+	e.kill = func(pid uint) error {
+		actions.killed = append(actions.killed, pid)
+		return nil
+	}
+	e.record = func(pod *v1.Pod, message string) {
+		actions.events = append(actions.events, pod.Name+": "+message)
+	}
+	e.annotate = func(pod *v1.Pod, message string) error {
+		if actions.err != nil {
+			return actions.err
+		}
+		actions.annotated = append(actions.annotated, pod.Name)
+		return nil
+	}
+	return e, actions
+}
+
+func enforceTestOverUses(e *enforcer) {
+	usages, _ := newOverUseBackend().Usage()
+	pods := newOverUsePods()
+	e.enforce(findOverUses(usages, testProcRoot, pods, GiBPrefix, e.tolerance), pods)
+}
+
+func TestEnforceKill(t *testing.T) {
+	e, actions := newTestEnforcer(EnforceActionKill, false)
+	enforceTestOverUses(e)
+
+	if !reflect.DeepEqual(actions.killed, []uint{1001, 1006, 1002, 1003}) {
+		t.Errorf("expected to kill the GPU processes of the over-using containers, got %v", actions.killed)
+	}
+	if len(actions.events) != 3 || !strings.Contains(actions.events[0], "uses 2400MiB GPU memory over its gpu-mem 1024MiB") {
+		t.Errorf("expected an event of each kill, got %v", actions.events)
+	}
+
+	// the processes are killed again, but the event is recorded once
+	enforceTestOverUses(e)
+	if len(actions.killed) != 8 || len(actions.events) != 3 {
+		t.Errorf("expected to kill the processes again without the events, got %+v", actions)
+	}
+}
+
+func TestEnforceDryRun(t *testing.T) {
+	e, actions := newTestEnforcer(EnforceActionKill, true)
+	before := memoryOverUses.Value()
+	enforceTestOverUses(e)
+	enforceTestOverUses(e)
+
+	if len(actions.killed) != 0 || len(actions.events) != 0 {
+		t.Errorf("expected no action in dry run, got %+v", actions)
+	}
+	if memoryOverUses.Value()-before != 3 {
+		t.Errorf("expected the over-uses to be counted once in dry run, got %d", memoryOverUses.Value()-before)
+	}
+}
+
+func TestEnforceReportsOnce(t *testing.T) {
+	e, actions := newTestEnforcer(EnforceActionEvent, false)
+	enforceTestOverUses(e)
+	enforceTestOverUses(e)
+	if len(actions.events) != 3 {
+		t.Errorf("expected the over-uses to be reported once, got %v", actions.events)
+	}
+
+	// the over-uses are reported again after they stop
+	e.enforce(nil, nil)
+	enforceTestOverUses(e)
+	if len(actions.events) != 6 {
+		t.Errorf("expected the over-uses to be reported again, got %v", actions.events)
+	}
+}
+
+func TestEnforceAnnotate(t *testing.T) {
+	e, actions := newTestEnforcer(EnforceActionAnnotate, false)
+	actions.err = fmt.Errorf("conflict")
+	enforceTestOverUses(e)
+	if len(e.reported) != 0 {
+		t.Errorf("expected the failed annotations to be retried, got %v", e.reported)
+	}
+
+	actions.err = nil
+	enforceTestOverUses(e)
+	enforceTestOverUses(e)
+	if !reflect.DeepEqual(actions.annotated, []string{"a", "b", "c"}) {
+		t.Errorf("expected to annotate the pods once, got %v", actions.annotated)
+	}
+}
+
+func TestEnforcerConfigValidate(t *testing.T) {
+	tests := []struct {
+		config EnforcerConfig
+		valid  bool
+	}{
+		{config: EnforcerConfig{}, valid: true},
+		{config: EnforcerConfig{Action: EnforceActionKill, Interval: 10, Tolerance: "256MiB"}, valid: true},
+		{config: EnforcerConfig{Action: "evict", Interval: 10}, valid: false},
+		{config: EnforcerConfig{Action: EnforceActionEvent}, valid: false},
+		{config: EnforcerConfig{Action: EnforceActionEvent, Interval: 10, Tolerance: "200%"}, valid: false},
+	}
+
+	for _, test := range tests {
+		if err := test.config.Validate(); (err == nil) != test.valid {
+			t.Errorf("%+v: expected valid %v, got %v", test.config, test.valid, err)
+		}
+	}
+	if newEnforcer(EnforcerConfig{}, DefaultProcRoot) != nil {
+		t.Errorf("expected the enforcer to be disabled without the action")
+	}
+}
@@ -0,0 +1,61 @@
+package nvidia
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const eventSource = "gpushare-device-plugin"
+
+// recordNodeEvent records an event of the node, the failure is only logged
+func recordNodeEvent(eventType, reason, message string) {
+	ref := &v1.ObjectReference{
+		Kind: "Node",
+		Name: nodeName,
+	}
+	// the events of the node are in default namespace as kubelet does
+	recordEvent(ref, metav1.NamespaceDefault, eventType, reason, message)
+}
+
+// recordPodEvent records an event of the pod, the failure is only logged
+func recordPodEvent(namespace, name string, uid types.UID, eventType, reason, message string) {
+	ref := &v1.ObjectReference{
+		Kind:      "Pod",
+		Namespace: namespace,
+		Name:      name,
+		UID:       uid,
+	}
+	recordEvent(ref, namespace, eventType, reason, message)
+}
+
+func recordEvent(ref *v1.ObjectReference, namespace, eventType, reason, message string) {
+	hostname, _ := os.Hostname()
+	now := metav1.NewTime(time.Now())
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: *ref,
+		Reason:         reason,
+		Message:        message,
+		Source: v1.EventSource{
+			Component: eventSource,
+			Host:      hostname,
+		},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+
+	if _, err := clientset.CoreV1().Events(namespace).Create(event); err != nil {
+		log.Warningf("Failed to record event %s of %s %s due to %v", reason, ref.Kind, ref.Name, err)
+	}
+}
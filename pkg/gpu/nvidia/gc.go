@@ -0,0 +1,145 @@
+package nvidia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	sweepInterval = time.Minute
+)
+
+// sweep expires the stale assumed pods and removes the orphaned allocations and assignments periodically
+func (m *NvidiaDevicePlugin) sweep(ttl time.Duration, stop <-chan struct{}) {
+	if ttl <= 0 {
+		log.Infof("Assumed pod TTL is not set, skip expiring the stale assumed pods")
+	}
+
+	wait.Until(func() {
+		// the pods are listed and patched without the lock, so Allocate isn't blocked by the
+		// requests. The allocations and the assignments made after listing are kept.
+		listed := time.Now()
+		pods, err := getActivePods(context.Background(), m.queryKubelet, m.kubeletClient)
+		if err != nil {
+			log.Warningf("Failed to sweep the stale assumed pods due to %v", err)
+			return
+		}
+
+		if ttl > 0 {
+			for _, pod := range expiredPods(pods, ttl, time.Now(), m.store.IsAssigned) {
+				expirePod(pod, ttl)
+			}
+		}
+
+		m.Lock()
+		m.removeOrphanedAllocations(pods, listed)
+		m.Unlock()
+		m.store.Prune(pods, listed)
+		m.notifyNodeStatus()
+	}, sweepInterval, stop)
+}
+
+// expiredPods returns the pending assumed pods which are assumed longer than the ttl
+func expiredPods(pods []v1.Pod, ttl time.Duration, now time.Time, assigned func(*v1.Pod) bool) []*v1.Pod {
+	expired := []*v1.Pod{}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase != v1.PodPending || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if pod.Annotations[EnvAssignedFlag] != "false" || assigned(pod) {
+			continue
+		}
+		assumeTime := getAssumeTimeFromPodAnnotation(pod)
+		if assumeTime == 0 {
+			continue
+		}
+		if now.Sub(time.Unix(0, int64(assumeTime))) > ttl {
+			expired = append(expired, pod)
+		}
+	}
+
+	return expired
+}
+
+// expirePod marks the assumed pod expired. The patch tests the annotations first, so the pod
+// which is assigned or assumed again after listing isn't expired. The pod is bound to the node, so
+// it can't be rescheduled and would stay Pending with the gpu-mem reserved by the scheduler extender.
+// It's deleted if it has a controller, which recreates it for the scheduler, the pod without a
+// controller is only marked expired and left for its owner to delete.
+func expirePod(pod *v1.Pod, ttl time.Duration) {
+	patch, err := json.Marshal([]map[string]string{
+		{"op": "test", "path": annotationPath(EnvAssignedFlag), "value": "false"},
+		{"op": "test", "path": annotationPath(EnvResourceAssumeTime), "value": pod.Annotations[EnvResourceAssumeTime]},
+		{"op": "replace", "path": annotationPath(EnvAssignedFlag), "value": AssignedFlagExpired},
+	})
+	if err != nil {
+		log.Warningf("Failed to expire pod %s in ns %s due to %v", pod.Name, pod.Namespace, err)
+		return
+	}
+	err = retryPatch(func() error {
+		_, err := clientset.CoreV1().Pods(pod.Namespace).Patch(pod.Name, types.JSONPatchType, patch)
+		return err
+	})
+	if err != nil {
+		log.Warningf("Failed to expire pod %s in ns %s due to %v", pod.Name, pod.Namespace, err)
+		return
+	}
+
+	expiredAssumedPods.Add(1)
+	log.Infof("Expired assumed pod %s in ns %s which is not allocated in %v", pod.Name, pod.Namespace, ttl)
+	message := fmt.Sprintf("GPU %s is assumed but not allocated in %v, it's no longer reserved for the pod by node %s",
+		pod.Annotations[EnvResourceIndex], ttl, nodeName)
+	if metav1.GetControllerOf(pod) == nil {
+		recordPodEvent(pod.Namespace, pod.Name, pod.UID, v1.EventTypeWarning, "GPUShareAssumeExpired",
+			message+", delete the pod to schedule it again")
+		return
+	}
+	recordPodEvent(pod.Namespace, pod.Name, pod.UID, v1.EventTypeWarning, "GPUShareAssumeExpired",
+		message+", the pod is deleted to be recreated by its controller")
+
+	// the precondition keeps the pod recreated with the same name
+	err = retryPatch(func() error {
+		return clientset.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{
+			Preconditions: metav1.NewUIDPreconditions(string(pod.UID)),
+		})
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Warningf("Failed to delete expired pod %s in ns %s due to %v", pod.Name, pod.Namespace, err)
+		return
+	}
+	log.Infof("Deleted expired pod %s in ns %s to be recreated by its controller", pod.Name, pod.Namespace)
+}
+
+// annotationPath is the JSON pointer of the pod annotation
+func annotationPath(key string) string {
+	return "/metadata/annotations/" + strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+// removeOrphanedAllocations removes the allocations of the pods which are gone, the allocations
+// recorded after the pods are listed are kept. It's called with the lock held.
+func (m *NvidiaDevicePlugin) removeOrphanedAllocations(pods []v1.Pod, listed time.Time) {
+	uids := map[types.UID]bool{}
+	for _, pod := range pods {
+		uids[pod.UID] = true
+	}
+
+	for key, a := range m.allocations {
+		if a.uid == "" || uids[a.uid] || a.allocateTime.After(listed) {
+			continue
+		}
+		delete(m.allocations, key)
+		orphanedAllocations.Add(1)
+		log.V(4).Infof("Removed the orphaned allocation of %s", a)
+	}
+}
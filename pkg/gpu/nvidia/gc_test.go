@@ -0,0 +1,122 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestExpiredPods(t *testing.T) {
+	now := time.Now()
+	assumedAt := func(assigned string, ago time.Duration) map[string]string {
+		return map[string]string{
+			EnvAssignedFlag:       assigned,
+			EnvResourceIndex:      "0",
+			EnvResourceAssumeTime: fmt.Sprintf("%d", now.Add(-ago).UnixNano()),
+		}
+	}
+	pods := []v1.Pod{
+		newGPUSharePod("stale", 2, assumedAt("false", time.Hour), v1.PodPending),
+		newGPUSharePod("fresh", 2, assumedAt("false", time.Minute), v1.PodPending),
+		newGPUSharePod("assigned", 2, assumedAt("true", time.Hour), v1.PodPending),
+		newGPUSharePod("running", 2, assumedAt("false", time.Hour), v1.PodRunning),
+		newGPUSharePod("expired", 2, assumedAt(AssignedFlagExpired, time.Hour), v1.PodPending),
+		newGPUSharePod("unassumed", 2, nil, v1.PodPending),
+	}
+
+	expired := expiredPods(pods, 10*time.Minute, now, isAssignedByAnnotation)
+	if len(expired) != 1 || expired[0].Name != "stale" {
+		t.Errorf("expected only pod stale to be expired, got %v", expired)
+	}
+}
+
+func TestRemoveOrphanedAllocations(t *testing.T) {
+	m := &NvidiaDevicePlugin{allocations: map[string]*allocation{
+		"GPU-a-_-0": {name: "alive", uid: "alive"},
+		"GPU-a-_-1": {name: "gone", uid: "gone"},
+		// recorded without the pod
+		"GPU-a-_-2": {devIndex: 0},
+	}}
+
+	// allocated after listing
+	m.recordAllocation([]string{"GPU-a-_-3"}, &allocation{name: "new", uid: "new"})
+
+	m.removeOrphanedAllocations([]v1.Pod{newGPUSharePod("alive", 1, nil, v1.PodRunning)}, time.Now().Add(-time.Minute))
+	if len(m.allocations) != 3 {
+		t.Errorf("expected 3 allocations, got %v", m.allocations)
+	}
+	if _, ok := m.allocations["GPU-a-_-1"]; ok {
+		t.Errorf("expected the allocation of the deleted pod to be removed")
+	}
+}
+
+func TestExpirePod(t *testing.T) {
+	pod := newGPUSharePod("stale", 2, map[string]string{
+		EnvAssignedFlag:       "false",
+		EnvResourceAssumeTime: "1000",
+	}, v1.PodPending)
+	var contentType string
+	var patch []map[string]string
+	var deleted *metav1.DeleteOptions
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted = &metav1.DeleteOptions{}
+			body, _ := ioutil.ReadAll(r.Body)
+			if err := json.Unmarshal(body, deleted); err != nil {
+				t.Errorf("failed to decode the delete options %s: %v", body, err)
+			}
+		}
+		if r.Method == http.MethodPatch {
+			contentType = r.Header.Get("Content-Type")
+			body, _ := ioutil.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &patch); err != nil {
+				t.Errorf("failed to decode the patch %s: %v", body, err)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&pod)
+	}))
+	defer server.Close()
+
+	oldClientset := clientset
+	defer func() { clientset = oldClientset }()
+	clientset = kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})
+
+	expirePod(&pod, time.Minute)
+	if contentType != "application/json-patch+json" {
+		t.Errorf("expected a JSON patch, got %s", contentType)
+	}
+	expected := []map[string]string{
+		{"op": "test", "path": "/metadata/annotations/" + EnvAssignedFlag, "value": "false"},
+		{"op": "test", "path": "/metadata/annotations/" + EnvResourceAssumeTime, "value": "1000"},
+		{"op": "replace", "path": "/metadata/annotations/" + EnvAssignedFlag, "value": AssignedFlagExpired},
+	}
+	if !reflect.DeepEqual(patch, expected) {
+		t.Errorf("expected the patch %v, got %v", expected, patch)
+	}
+	if deleted != nil {
+		t.Errorf("expected the pod without a controller to be kept")
+	}
+
+	// the pod of a controller is deleted to be recreated
+	controller := true
+	pod.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "rs", UID: "rs", Controller: &controller}}
+	expirePod(&pod, time.Minute)
+	if deleted == nil || deleted.Preconditions == nil || deleted.Preconditions.UID == nil || *deleted.Preconditions.UID != pod.UID {
+		t.Errorf("expected the pod to be deleted with the uid precondition, got %+v", deleted)
+	}
+
+	if path := annotationPath("aliyun.com/gpu~mem"); path != "/metadata/annotations/aliyun.com~1gpu~0mem" {
+		t.Errorf("expected the escaped path, got %s", path)
+	}
+}
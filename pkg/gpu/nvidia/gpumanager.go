@@ -3,31 +3,49 @@ package nvidia
 import (
 	"fmt"
 	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/kubelet/client"
-	"syscall"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
 	"github.com/fsnotify/fsnotify"
 	log "github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
 )
 
 type sharedGPUManager struct {
-	enableMPS     bool
-	healthCheck   bool
-	queryKubelet  bool
+	// config is loaded from the config file, the flags are used if there is no config file
+	config Config
+	// defaults are the config from the flags
+	defaults      Config
+	configFile    string
 	kubeletClient *client.KubeletClient
 }
 
-func NewSharedGPUManager(enableMPS, healthCheck, queryKubelet bool, bp MemoryUnit, client *client.KubeletClient) *sharedGPUManager {
-	metric = bp
-	return &sharedGPUManager{
-		enableMPS:     enableMPS,
-		healthCheck:   healthCheck,
-		queryKubelet:  queryKubelet,
-		kubeletClient: client,
+func NewSharedGPUManager(defaults Config, configFile string) (*sharedGPUManager, error) {
+	kubeInit()
+
+	config := defaults
+	if configFile != "" {
+		var err error
+		config, err = LoadConfig(configFile, defaults)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Loaded config file %s", configFile)
+	} else if err := config.Validate(); err != nil {
+		return nil, err
 	}
+
+	return &sharedGPUManager{
+		config:     config,
+		defaults:   defaults,
+		configFile: configFile,
+	}, nil
 }
 
 func (ngm *sharedGPUManager) Run() error {
@@ -36,6 +54,7 @@ func (ngm *sharedGPUManager) Run() error {
 	if err := nvml.Init(); err != nil {
 		log.V(1).Infof("Failed to initialize NVML: %s.", err)
 		log.V(1).Infof("If this is a GPU node, did you set the docker default runtime to `nvidia`?")
+		removeGPULabels()
 		select {}
 	}
 	defer func() { log.V(1).Infoln("Shutdown of NVML returned:", nvml.Shutdown()) }()
@@ -43,11 +62,17 @@ func (ngm *sharedGPUManager) Run() error {
 	log.V(1).Infoln("Fetching devices.")
 	if getDeviceCount() == uint(0) {
 		log.V(1).Infoln("No devices found. Waiting indefinitely.")
+		removeGPULabels()
 		select {}
 	}
 
 	log.V(1).Infoln("Starting FS watcher.")
-	watcher, err := newFSWatcher(pluginapi.DevicePluginPath)
+	watchedFiles := []string{pluginapi.DevicePluginPath}
+	if ngm.configFile != "" {
+		// watch the directory since the file mounted from ConfigMap is replaced by symlinks
+		watchedFiles = append(watchedFiles, filepath.Dir(ngm.configFile))
+	}
+	watcher, err := newFSWatcher(watchedFiles...)
 	if err != nil {
 		log.V(1).Infoln("Failed to created FS watcher.")
 		return err
@@ -57,8 +82,22 @@ func (ngm *sharedGPUManager) Run() error {
 	log.V(1).Infoln("Starting OS watcher.")
 	sigs := newOSWatcher(syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
+	node, err := clientset.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		log.Warningf("Failed to get node %s due to %v", nodeName, err)
+		return err
+	}
+
+	log.V(1).Infoln("Starting node watcher.")
+	stopNodeWatcher := make(chan struct{})
+	defer close(stopNodeWatcher)
+	nodeUpdates := watchNode(stopNodeWatcher)
+
 	restart := true
-	var devicePlugin *NvidiaDevicePlugin
+	var (
+		devicePlugin *NvidiaDevicePlugin
+		nodeConfig   Config
+	)
 
 L:
 	for {
@@ -67,7 +106,8 @@ L:
 				devicePlugin.Stop()
 			}
 
-			devicePlugin, err = NewNvidiaDevicePlugin(ngm.enableMPS, ngm.healthCheck, ngm.queryKubelet, ngm.kubeletClient)
+			nodeConfig = ngm.config.forNode(node.Labels)
+			devicePlugin, err = ngm.newDevicePlugin(nodeConfig, node)
 			if err != nil {
 				log.Warningf("Failed to get device plugin due to %v", err)
 				os.Exit(1)
@@ -84,15 +124,26 @@ L:
 			if event.Name == pluginapi.KubeletSocket && event.Op&fsnotify.Create == fsnotify.Create {
 				log.V(1).Infof("inotify: %s created, restarting.", pluginapi.KubeletSocket)
 				restart = true
+			} else if ngm.configFile != "" && filepath.Dir(event.Name) == filepath.Dir(ngm.configFile) {
+				if !ngm.reloadConfig() {
+					continue
+				}
+				restart = ngm.reconcile(devicePlugin, node, nodeConfig)
 			}
 
 		case err := <-watcher.Errors:
 			log.Warningf("inotify: %s", err)
 
+		case node = <-nodeUpdates:
+			restart = ngm.reconcile(devicePlugin, node, nodeConfig)
+
 		case s := <-sigs:
 			switch s {
 			case syscall.SIGHUP:
 				log.V(1).Infoln("Received SIGHUP, restarting.")
+				if ngm.configFile != "" {
+					ngm.reloadConfig()
+				}
 				restart = true
 			case syscall.SIGQUIT:
 				t := time.Now()
@@ -109,3 +160,44 @@ L:
 
 	return nil
 }
+
+// newDevicePlugin creates the device plugin with the config of the node
+func (ngm *sharedGPUManager) newDevicePlugin(config Config, node *v1.Node) (*NvidiaDevicePlugin, error) {
+	if config.QueryKubelet {
+		kubeletClient, err := config.Kubelet.newClient()
+		if err != nil {
+			return nil, err
+		}
+		ngm.kubeletClient = kubeletClient
+	}
+
+	settings := nodeSettingsOf(node, config.defaultNodeSettings())
+	return NewNvidiaDevicePlugin(config, settings, ngm.kubeletClient)
+}
+
+// reloadConfig reloads the config file, the current config is kept if it's invalid
+func (ngm *sharedGPUManager) reloadConfig() bool {
+	config, err := LoadConfig(ngm.configFile, ngm.defaults)
+	if err != nil {
+		log.Warningf("Failed to reload config due to %v, keep the current config", err)
+		return false
+	}
+
+	ngm.config = config
+	log.Infof("Reloaded config file %s", ngm.configFile)
+	return true
+}
+
+// reconcile applies the config and the settings of the node to the running device plugin,
+// it returns true if the device plugin has to be restarted
+func (ngm *sharedGPUManager) reconcile(devicePlugin *NvidiaDevicePlugin, node *v1.Node, current Config) bool {
+	config := ngm.config.forNode(node.Labels)
+	if fields := config.restartFields(current); len(fields) > 0 {
+		log.Infof("Config of node %s is changed in %s, restarting. Only healthCheck and reservedMemory are reloaded without restarting.",
+			nodeName, strings.Join(fields, ", "))
+		return true
+	}
+
+	devicePlugin.applySettings(nodeSettingsOf(node, config.defaultNodeSettings()))
+	return false
+}
@@ -0,0 +1,163 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	gpusharev1alpha1 "github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare/v1alpha1"
+	log "github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// gpuShareNodeResync is the interval of reporting the GPUShareNode without any allocation
+const gpuShareNodeResync = time.Minute
+
+var gpuShareNodePath = "/apis/" + gpusharev1alpha1.GroupName + "/" + gpusharev1alpha1.Version + "/" + gpusharev1alpha1.Resource
+
+// notifyNodeStatus triggers reporting the GPUShareNode, it doesn't block
+func (m *NvidiaDevicePlugin) notifyNodeStatus() {
+	select {
+	case m.nodeStatusUpdated <- struct{}{}:
+	default:
+	}
+}
+
+// reportNodeStatus reports the GPUShareNode of the node on the allocations and periodically
+func (m *NvidiaDevicePlugin) reportNodeStatus(stop <-chan struct{}) {
+	ticker := time.NewTicker(gpuShareNodeResync)
+	defer ticker.Stop()
+
+	for {
+		if err := m.updateGPUShareNode(); err != nil {
+			log.Warningf("Failed to update GPUShareNode %s due to %v", nodeName, err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-m.nodeStatusUpdated:
+		}
+	}
+}
+
+func (m *NvidiaDevicePlugin) updateGPUShareNode() error {
+	gpus, err := backend.Devices()
+	if err != nil {
+		return err
+	}
+	pods, err := getActivePodsInNode()
+	if err != nil {
+		return err
+	}
+
+	// the reserved memory is changed with the node settings
+	m.RLock()
+	unhealthy := map[string]bool{}
+	for id := range m.unhealthyDevs {
+		unhealthy[id] = true
+	}
+	unit, allocatable := m.unit, getAllocatableGPUMemory()
+	m.RUnlock()
+
+	status := gpuShareNodeStatus(gpus, pods, unit, allocatable, unhealthy, m.store.IsAssigned)
+	return putGPUShareNode(status)
+}
+
+// gpuShareNodeStatus builds the status of the GPUs from the pods assigned to them, each GPU
+// has the allocatable memory in the unit
+func gpuShareNodeStatus(gpus []*gpuDevice, pods []v1.Pod, unit MemoryUnit, allocatable uint,
+	unhealthy map[string]bool, assigned func(*v1.Pod) bool) gpusharev1alpha1.GPUShareNodeStatus {
+	status := gpusharev1alpha1.GPUShareNodeStatus{
+		MemoryUnit: string(unit),
+		GPUs:       []gpusharev1alpha1.GPUStatus{},
+		UpdateTime: metav1.Now(),
+	}
+
+	byIndex := map[uint]int{}
+	for _, gpu := range gpus {
+		byIndex[gpu.Index] = len(status.GPUs)
+		status.GPUs = append(status.GPUs, gpusharev1alpha1.GPUStatus{
+			Index:       gpu.Index,
+			UUID:        gpu.UUID,
+			Model:       gpu.Model,
+			TotalMemory: allocatable,
+			Healthy:     !unhealthy[gpu.UUID],
+		})
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		mem := getGPUMemoryFromPodResource(pod)
+		if mem == 0 {
+			continue
+		}
+		if !assigned(pod) {
+			if pod.Status.Phase == v1.PodPending && pod.Annotations[EnvAssignedFlag] != AssignedFlagExpired {
+				status.PendingPods = append(status.PendingPods, podAllocation(pod, mem))
+			}
+			continue
+		}
+		id, err := strconv.Atoi(pod.Annotations[EnvResourceIndex])
+		if err != nil || id < 0 {
+			continue
+		}
+		j, ok := byIndex[uint(id)]
+		if !ok {
+			log.Warningf("Pod %s in ns %s is assigned to gpu %d which is not found", pod.Name, pod.Namespace, id)
+			continue
+		}
+		status.GPUs[j].AllocatedMemory += mem
+		status.GPUs[j].Pods = append(status.GPUs[j].Pods, podAllocation(pod, mem))
+	}
+
+	sort.Slice(status.GPUs, func(i, j int) bool { return status.GPUs[i].Index < status.GPUs[j].Index })
+	return status
+}
+
+func podAllocation(pod *v1.Pod, mem uint) gpusharev1alpha1.PodAllocation {
+	return gpusharev1alpha1.PodAllocation{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		UID:       pod.UID,
+		GPUMemory: mem,
+	}
+}
+
+// putGPUShareNode creates or updates the GPUShareNode of the node
+func putGPUShareNode(status gpusharev1alpha1.GPUShareNodeStatus) error {
+	restClient := clientset.CoreV1().RESTClient()
+	return retryPatch(func() error {
+		node := &gpusharev1alpha1.GPUShareNode{}
+		data, err := restClient.Get().AbsPath(gpuShareNodePath, nodeName).DoRaw()
+		found := true
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+			found = false
+		} else if err = json.Unmarshal(data, node); err != nil {
+			return err
+		}
+
+		node.APIVersion = gpusharev1alpha1.GroupName + "/" + gpusharev1alpha1.Version
+		node.Kind = gpusharev1alpha1.Kind
+		node.Name = nodeName
+		node.Status = status
+		body, err := json.Marshal(node)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			return restClient.Post().AbsPath(gpuShareNodePath).
+				SetHeader("Content-Type", "application/json").Body(body).Do().Error()
+		}
+		return restClient.Put().AbsPath(gpuShareNodePath, nodeName).
+			SetHeader("Content-Type", "application/json").Body(body).Do().Error()
+	})
+}
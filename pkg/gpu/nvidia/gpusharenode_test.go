@@ -0,0 +1,42 @@
+package nvidia
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestGPUShareNodeStatus(t *testing.T) {
+	gpus, _ := newFakeBackend().Devices()
+	assignedTo := func(idx string) map[string]string {
+		return map[string]string{EnvAssignedFlag: "true", EnvResourceIndex: idx}
+	}
+	pods := []v1.Pod{
+		newGPUSharePod("a", 4, assignedTo("3"), v1.PodRunning),
+		newGPUSharePod("b", 2, assignedTo("3"), v1.PodRunning),
+		newGPUSharePod("c", 8, map[string]string{EnvAssignedFlag: "false", EnvResourceIndex: "0"}, v1.PodPending),
+		// the gpu is not on the node
+		newGPUSharePod("d", 8, assignedTo("1"), v1.PodRunning),
+		newGPUSharePod("e", 2, map[string]string{EnvAssignedFlag: AssignedFlagExpired}, v1.PodPending),
+		newGPUSharePod("f", 0, nil, v1.PodPending),
+	}
+
+	status := gpuShareNodeStatus(gpus, pods, GiBPrefix, 14, map[string]bool{"GPU-b": true}, isAssignedByAnnotation)
+	if status.MemoryUnit != "GiB" || len(status.GPUs) != 2 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	gpu0, gpu3 := status.GPUs[0], status.GPUs[1]
+	if gpu0.Index != 0 || gpu0.AllocatedMemory != 0 || len(gpu0.Pods) != 0 || !gpu0.Healthy {
+		t.Errorf("expected healthy gpu 0 without pods, got %+v", gpu0)
+	}
+	if gpu3.Index != 3 || gpu3.UUID != "GPU-b" || gpu3.TotalMemory != 14 || gpu3.AllocatedMemory != 6 || gpu3.Healthy {
+		t.Errorf("expected unhealthy gpu 3 with 6/14 allocated, got %+v", gpu3)
+	}
+	if len(gpu3.Pods) != 2 || gpu3.Pods[0].Name != "a" || gpu3.Pods[1].GPUMemory != 2 {
+		t.Errorf("expected pods a and b on gpu 3, got %+v", gpu3.Pods)
+	}
+	if len(status.PendingPods) != 1 || status.PendingPods[0].Name != "c" || status.PendingPods[0].GPUMemory != 8 {
+		t.Errorf("expected pending pod c, got %+v", status.PendingPods)
+	}
+}
@@ -0,0 +1,128 @@
+package nvidia
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+)
+
+// ParseMemoryUnit parses the block size of one fake device. Besides GiB and MiB,
+// it accepts a multiple of them such as 128MiB, 256MiB, 512MiB or 2GiB.
+func ParseMemoryUnit(value string) (MemoryUnit, error) {
+	size, err := blockSizeInMiB(value)
+	if err != nil {
+		return "", err
+	}
+
+	return memoryUnitOfMiB(size), nil
+}
+
+func blockSizeInMiB(value string) (uint, error) {
+	size, err := gpushare.MemoryUnitInMiB(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint(size), nil
+}
+
+// memoryUnitOfMiB returns the canonical name of a block with the given size in MiB,
+// e.g. GiB for 1024 and 512MiB for 512.
+func memoryUnitOfMiB(size uint) MemoryUnit {
+	switch {
+	case size == 1:
+		return MiBPrefix
+	case size == 1024:
+		return GiBPrefix
+	case size%1024 == 0:
+		return MemoryUnit(fmt.Sprintf("%d%s", size/1024, GiBPrefix))
+	default:
+		return MemoryUnit(fmt.Sprintf("%d%s", size, MiBPrefix))
+	}
+}
+
+// MiB returns the size of one block in MiB, it panics if the unit isn't from ParseMemoryUnit, so a
+// typo is never taken for another unit
+func (u MemoryUnit) MiB() uint {
+	size, err := blockSizeInMiB(string(u))
+	if err != nil {
+		panic(fmt.Sprintf("invalid memory unit: %v", err))
+	}
+
+	return size
+}
+
+// Quantity describes the given number of blocks in GiB or MiB, e.g. 4 blocks of 256MiB is 1024MiB
+func (u MemoryUnit) Quantity(blocks uint) string {
+	size := u.MiB()
+	if size%1024 == 0 {
+		return fmt.Sprintf("%d%s", blocks*size/1024, GiBPrefix)
+	}
+
+	return fmt.Sprintf("%d%s", blocks*size, MiBPrefix)
+}
+
+// MemoryReservation is the GPU memory kept away from the fake devices on each GPU
+// for the CUDA context, the driver and the system daemons
+type MemoryReservation struct {
+	// Percent of the GPU memory to reserve, it's used when MiB is 0
+	Percent uint
+	// MiB is the absolute GPU memory to reserve
+	MiB uint
+}
+
+// percentSuffixes are the suffixes of a percentage, pct can be used in a node label
+// where % is not allowed
+var percentSuffixes = []string{"%", "pct"}
+
+// ParseMemoryReservation parses an absolute reservation such as 512MiB or 1GiB,
+// or a percentage of the GPU memory such as 5% or 5pct.
+func ParseMemoryReservation(value string) (MemoryReservation, error) {
+	reservation := MemoryReservation{}
+	if value == "" || value == "0" {
+		return reservation, nil
+	}
+
+	for _, suffix := range percentSuffixes {
+		if !strings.HasSuffix(value, suffix) {
+			continue
+		}
+		percent, err := strconv.ParseUint(strings.TrimSuffix(value, suffix), 10, 32)
+		if err != nil || percent >= 100 {
+			return reservation, fmt.Errorf("unsupported memory reservation %q, the percentage should be between 0 and 99", value)
+		}
+		reservation.Percent = uint(percent)
+		return reservation, nil
+	}
+
+	size, err := blockSizeInMiB(value)
+	if err != nil {
+		return reservation, fmt.Errorf("unsupported memory reservation %q, it should be like 512MiB, 1GiB, 5%% or 5pct", value)
+	}
+	reservation.MiB = size
+
+	return reservation, nil
+}
+
+// Of returns the reserved memory in MiB of a GPU with the given memory in MiB
+func (r MemoryReservation) Of(total uint) uint {
+	reserved := r.MiB
+	if reserved == 0 {
+		reserved = total * r.Percent / 100
+	}
+	if reserved > total {
+		reserved = total
+	}
+
+	return reserved
+}
+
+func (r MemoryReservation) String() string {
+	if r.MiB > 0 {
+		return string(memoryUnitOfMiB(r.MiB))
+	}
+
+	return fmt.Sprintf("%d%%", r.Percent)
+}
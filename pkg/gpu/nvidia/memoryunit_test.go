@@ -0,0 +1,160 @@
+package nvidia
+
+import (
+	"testing"
+)
+
+func TestParseMemoryUnit(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    MemoryUnit
+		expectedMiB uint
+		expectedErr bool
+	}{
+		{value: "GiB", expected: GiBPrefix, expectedMiB: 1024},
+		{value: "MiB", expected: MiBPrefix, expectedMiB: 1},
+		{value: "128MiB", expected: "128MiB", expectedMiB: 128},
+		{value: "2GiB", expected: "2GiB", expectedMiB: 2048},
+		{value: "1GiB", expected: GiBPrefix, expectedMiB: 1024},
+		{value: "1024MiB", expected: GiBPrefix, expectedMiB: 1024},
+		{value: "2048MiB", expected: "2GiB", expectedMiB: 2048},
+		{value: "1MiB", expected: MiBPrefix, expectedMiB: 1},
+		{value: "0MiB", expectedErr: true},
+		{value: "1.5GiB", expectedErr: true},
+		{value: "-1GiB", expectedErr: true},
+		{value: "128", expectedErr: true},
+		{value: "2TiB", expectedErr: true},
+		{value: "", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		unit, err := ParseMemoryUnit(test.value)
+		if test.expectedErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s", test.value, unit)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.value, err)
+			continue
+		}
+		if unit != test.expected {
+			t.Errorf("%q: expected %s, got %s", test.value, test.expected, unit)
+		}
+		if size, _ := blockSizeInMiB(test.value); size != test.expectedMiB {
+			t.Errorf("%q: expected %d MiB, got %d", test.value, test.expectedMiB, size)
+		}
+		if unit.MiB() != test.expectedMiB {
+			t.Errorf("%q: expected the unit to be %d MiB, got %d", test.value, test.expectedMiB, unit.MiB())
+		}
+	}
+}
+
+func TestMemoryUnitOfMiB(t *testing.T) {
+	tests := map[uint]MemoryUnit{
+		1:    MiBPrefix,
+		256:  "256MiB",
+		1024: GiBPrefix,
+		1536: "1536MiB",
+		4096: "4GiB",
+	}
+	for size, expected := range tests {
+		if unit := memoryUnitOfMiB(size); unit != expected {
+			t.Errorf("%d MiB: expected %s, got %s", size, expected, unit)
+		}
+	}
+}
+
+func TestMemoryUnitQuantity(t *testing.T) {
+	tests := []struct {
+		unit     MemoryUnit
+		blocks   uint
+		expected string
+	}{
+		{unit: GiBPrefix, blocks: 4, expected: "4GiB"},
+		{unit: MiBPrefix, blocks: 2048, expected: "2048MiB"},
+		{unit: "2GiB", blocks: 3, expected: "6GiB"},
+		{unit: "256MiB", blocks: 4, expected: "1024MiB"},
+		{unit: "512MiB", blocks: 3, expected: "1536MiB"},
+		{unit: GiBPrefix, blocks: 0, expected: "0GiB"},
+	}
+
+	for _, test := range tests {
+		if quantity := test.unit.Quantity(test.blocks); quantity != test.expected {
+			t.Errorf("%d blocks of %s: expected %s, got %s", test.blocks, test.unit, test.expected, quantity)
+		}
+	}
+}
+
+func TestMemoryUnitMiBInvalid(t *testing.T) {
+	for _, unit := range []MemoryUnit{"", "GB", "0MiB"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q: expected the invalid unit to panic", unit)
+				}
+			}()
+			unit.MiB()
+		}()
+	}
+}
+
+func TestParseMemoryReservation(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    MemoryReservation
+		expectedOf  uint
+		expectedErr bool
+	}{
+		{value: "", expected: MemoryReservation{}, expectedOf: 0},
+		{value: "0", expected: MemoryReservation{}, expectedOf: 0},
+		{value: "512MiB", expected: MemoryReservation{MiB: 512}, expectedOf: 512},
+		{value: "1GiB", expected: MemoryReservation{MiB: 1024}, expectedOf: 1024},
+		{value: "5%", expected: MemoryReservation{Percent: 5}, expectedOf: 800},
+		// % is not allowed in a node label
+		{value: "5pct", expected: MemoryReservation{Percent: 5}, expectedOf: 800},
+		{value: "0pct", expected: MemoryReservation{}, expectedOf: 0},
+		// the reservation can't be more than the GPU memory
+		{value: "32GiB", expected: MemoryReservation{MiB: 32768}, expectedOf: 16000},
+		{value: "100%", expectedErr: true},
+		{value: "5.5pct", expectedErr: true},
+		{value: "pct", expectedErr: true},
+		{value: "-5%", expectedErr: true},
+		{value: "512", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		reservation, err := ParseMemoryReservation(test.value)
+		if test.expectedErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s", test.value, reservation)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.value, err)
+			continue
+		}
+		if reservation != test.expected {
+			t.Errorf("%q: expected %+v, got %+v", test.value, test.expected, reservation)
+		}
+		if reserved := reservation.Of(16000); reserved != test.expectedOf {
+			t.Errorf("%q: expected to reserve %d MiB of 16000 MiB, got %d", test.value, test.expectedOf, reserved)
+		}
+	}
+}
+
+func TestMemoryReservationString(t *testing.T) {
+	tests := map[MemoryReservation]string{
+		{MiB: 512}:   "512MiB",
+		{MiB: 1024}:  "GiB",
+		{Percent: 5}: "5%",
+		{}:           "0%",
+	}
+	for reservation, expected := range tests {
+		if s := reservation.String(); s != expected {
+			t.Errorf("%+v: expected %s, got %s", reservation, expected, s)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package nvidia
+
+import (
+	"expvar"
+	"net/http"
+
+	log "github.com/golang/glog"
+)
+
+// The metrics are published by expvar at /debug/vars
+var (
+	expiredAssumedPods  = expvar.NewInt("gpushare_expired_assumed_pods_total")
+	orphanedAllocations = expvar.NewInt("gpushare_orphaned_allocations_total")
+	memoryOverUses      = expvar.NewInt("gpushare_memory_overuses_total")
+	killedProcesses     = expvar.NewInt("gpushare_killed_processes_total")
+	// unverifiedAllocations counts the containers started without verifying the allocation
+	// since the pods or the namespace couldn't be got
+	unverifiedAllocations = expvar.NewInt("gpushare_unverified_allocations_total")
+)
+
+// ServeMetrics serves the metrics on the address in the background
+func ServeMetrics(address string) {
+	go func() {
+		log.Infof("Serving metrics on %s/debug/vars", address)
+		if err := http.ListenAndServe(address, nil); err != nil {
+			log.Warningf("Failed to serve metrics due to %v", err)
+		}
+	}()
+}
@@ -0,0 +1,91 @@
+package nvidia
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// gpuLabelKeys are the node labels owned by the device plugin
+var gpuLabelKeys = []string{
+	NodeLabelGPUName,
+	NodeLabelGPUMemory,
+	NodeLabelGPUCount,
+	NodeLabelDriverVersion,
+	NodeLabelCUDAVersion,
+	NodeLabelMPSMode,
+	NodeLabelMIGMode,
+}
+
+var invalidLabelValueChars = regexp.MustCompile("[^-A-Za-z0-9_.]+")
+
+// gpuLabels returns the labels describing the GPUs of the node, it's empty if there is no GPU
+func gpuLabels(devs []*gpuDevice, mps bool) map[string]string {
+	labels := map[string]string{}
+	if len(devs) == 0 {
+		return labels
+	}
+
+	labels[NodeLabelGPUName] = labelValue(devs[0].Model)
+	labels[NodeLabelGPUMemory] = fmt.Sprintf("%d%s", devs[0].Memory, MiBPrefix)
+	labels[NodeLabelGPUCount] = fmt.Sprintf("%d", len(devs))
+	labels[NodeLabelMPSMode] = fmt.Sprintf("%t", mps)
+
+	if version, err := backend.DriverVersion(); err == nil {
+		labels[NodeLabelDriverVersion] = labelValue(version)
+	} else {
+		log.Warningf("Failed to get driver version due to %v", err)
+	}
+	if version, err := backend.CUDAVersion(); err == nil {
+		labels[NodeLabelCUDAVersion] = labelValue(version)
+	} else {
+		log.Warningf("Failed to get cuda version due to %v", err)
+	}
+	if enabled, err := backend.MIGEnabled(); err == nil {
+		labels[NodeLabelMIGMode] = fmt.Sprintf("%t", enabled)
+		if enabled {
+			log.Warningf("MIG is enabled on node %s", nodeName)
+		}
+	} else {
+		log.Warningf("Failed to get MIG mode due to %v", err)
+	}
+
+	for _, dev := range devs[1:] {
+		if dev.Model != devs[0].Model || dev.Memory != devs[0].Memory {
+			log.Warningf("GPU %s (%s, %dMiB) is different from GPU %s (%s, %dMiB), label the node with the latter",
+				dev.UUID, dev.Model, dev.Memory, devs[0].UUID, devs[0].Model, devs[0].Memory)
+		}
+	}
+
+	return labels
+}
+
+// syncGPULabels labels the node with the GPUs found by the device backend
+func syncGPULabels(mps bool) error {
+	devs, err := backend.Devices()
+	if err != nil {
+		return err
+	}
+
+	return syncNodeLabels(gpuLabels(devs, mps))
+}
+
+// removeGPULabels removes the labels when the GPUs disappear
+func removeGPULabels() {
+	if err := syncNodeLabels(map[string]string{}); err != nil {
+		log.Warningf("Failed to remove labels of node %s due to %v", nodeName, err)
+	}
+}
+
+// labelValue converts the value to a valid label value, e.g. Tesla-P100-PCIE-16GB for "Tesla P100-PCIE-16GB"
+func labelValue(value string) string {
+	value = invalidLabelValueChars.ReplaceAllString(value, "-")
+	if len(value) > validation.LabelValueMaxLength {
+		value = value[:validation.LabelValueMaxLength]
+	}
+
+	return strings.Trim(value, "-_.")
+}
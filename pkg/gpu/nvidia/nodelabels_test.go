@@ -0,0 +1,134 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestGPULabels(t *testing.T) {
+	oldBackend := backend
+	defer func() { backend = oldBackend }()
+	fake := newFakeBackend()
+	backend = fake
+
+	expected := map[string]string{
+		NodeLabelGPUName:       "Tesla-T4",
+		NodeLabelGPUMemory:     "15109MiB",
+		NodeLabelGPUCount:      "2",
+		NodeLabelDriverVersion: "418.87.01",
+		NodeLabelCUDAVersion:   "10.1",
+		NodeLabelMPSMode:       "true",
+		NodeLabelMIGMode:       "false",
+	}
+	if labels := gpuLabels(fake.devs, true); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, labels)
+	}
+
+	fake.mig = true
+	if labels := gpuLabels(fake.devs, false); labels[NodeLabelMIGMode] != "true" || labels[NodeLabelMPSMode] != "false" {
+		t.Errorf("expected MIG mode and no MPS, got %v", labels)
+	}
+
+	if labels := gpuLabels(nil, false); len(labels) != 0 {
+		t.Errorf("expected no labels without GPUs, got %v", labels)
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	tests := map[string]string{
+		"Tesla P100-PCIE-16GB":  "Tesla-P100-PCIE-16GB",
+		"418.87.01":             "418.87.01",
+		"  GeForce RTX (2080) ": "GeForce-RTX-2080",
+		"A100/SXM4_40GB":        "A100-SXM4_40GB",
+		"":                      "",
+		strings.Repeat("a", 70): strings.Repeat("a", 63),
+	}
+	for value, expected := range tests {
+		if label := labelValue(value); label != expected {
+			t.Errorf("%q: expected %q, got %q", value, expected, label)
+		}
+	}
+}
+
+func TestCUDAVersionString(t *testing.T) {
+	tests := map[int]string{
+		10010: "10.1",
+		11020: "11.2",
+		12000: "12.0",
+	}
+	for version, expected := range tests {
+		if s := cudaVersionString(version); s != expected {
+			t.Errorf("%d: expected %s, got %s", version, expected, s)
+		}
+	}
+}
+
+// TestSyncGPULabels labels a node which has a stale label of the device plugin and a label of the user
+func TestSyncGPULabels(t *testing.T) {
+	node := &v1.Node{
+		TypeMeta: metav1.TypeMeta{Kind: "Node", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{
+			NodeLabelGPUName:     "Tesla-T4",
+			NodeLabelCUDAVersion: "9.0",
+			"user":               "label",
+		}},
+	}
+	var patch map[string]map[string]map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPatch {
+			body, _ := ioutil.ReadAll(r.Body)
+			if err := json.Unmarshal(body, &patch); err != nil {
+				t.Errorf("failed to decode the patch %s: %v", body, err)
+			}
+		}
+		json.NewEncoder(w).Encode(node)
+	}))
+	defer server.Close()
+
+	oldClientset, oldNodeName, oldBackend := clientset, nodeName, backend
+	defer func() { clientset, nodeName, backend = oldClientset, oldNodeName, oldBackend }()
+	clientset = kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})
+	nodeName = "node1"
+	backend = newFakeBackend()
+
+	if err := syncGPULabels(false); err != nil {
+		t.Fatalf("failed to sync the labels: %v", err)
+	}
+	// the unchanged name and the label of the user are not patched
+	expected := map[string]interface{}{
+		NodeLabelGPUMemory:     "15109MiB",
+		NodeLabelGPUCount:      "2",
+		NodeLabelDriverVersion: "418.87.01",
+		NodeLabelCUDAVersion:   "10.1",
+		NodeLabelMPSMode:       "false",
+		NodeLabelMIGMode:       "false",
+	}
+	if labels := patch["metadata"]["labels"]; !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected to patch the labels %v, got %v", expected, labels)
+	}
+
+	// the labels of the device plugin are removed when the GPUs disappear
+	patch = nil
+	backend = &fakeBackend{}
+	if err := syncGPULabels(false); err != nil {
+		t.Fatalf("failed to sync the labels: %v", err)
+	}
+	expected = map[string]interface{}{
+		NodeLabelGPUName:     nil,
+		NodeLabelCUDAVersion: nil,
+	}
+	if labels := patch["metadata"]["labels"]; !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected to remove the labels %v, got %v", expected, labels)
+	}
+}
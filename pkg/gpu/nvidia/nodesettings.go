@@ -0,0 +1,190 @@
+package nvidia
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	log "github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/tools/cache"
+)
+
+// nodeSettings are the settings of the device plugin which can be overridden per node
+// by the labels and annotations, they are reloaded without restarting the device plugin
+type nodeSettings struct {
+	disableCGPUIsolation bool
+	reservation          MemoryReservation
+	healthCheck          bool
+}
+
+func (s nodeSettings) String() string {
+	return fmt.Sprintf("cgpu isolation disabled: %t, reserved memory: %s, health check: %t",
+		s.disableCGPUIsolation,
+		s.reservation,
+		s.healthCheck)
+}
+
+// changes describes the settings which are different from the old ones
+func (s nodeSettings) changes(old nodeSettings) []string {
+	changes := []string{}
+	if s.disableCGPUIsolation != old.disableCGPUIsolation {
+		changes = append(changes, fmt.Sprintf("cgpu isolation disabled %t -> %t", old.disableCGPUIsolation, s.disableCGPUIsolation))
+	}
+	if s.reservation != old.reservation {
+		changes = append(changes, fmt.Sprintf("reserved memory %s -> %s", old.reservation, s.reservation))
+	}
+	if s.healthCheck != old.healthCheck {
+		changes = append(changes, fmt.Sprintf("health check %t -> %t", old.healthCheck, s.healthCheck))
+	}
+	return changes
+}
+
+// nodeValue returns the value of the key in the annotations or the labels of the node
+func nodeValue(node *v1.Node, key string) (string, bool) {
+	if value, ok := node.Annotations[key]; ok {
+		return value, true
+	}
+	value, ok := node.Labels[key]
+	return value, ok
+}
+
+// nodeSettingsOf overrides the default settings by the labels and annotations of the node
+func nodeSettingsOf(node *v1.Node, defaults nodeSettings) nodeSettings {
+	settings := defaults
+
+	if value, ok := nodeValue(node, EnvNodeLabelForDisableCGPU); ok && value == "true" {
+		settings.disableCGPUIsolation = true
+	}
+
+	if value, ok := nodeValue(node, NodeLabelForReservedMemory); ok {
+		reservation, err := ParseMemoryReservation(value)
+		if err != nil {
+			log.Warningf("Ignore %s of node %s due to %v", NodeLabelForReservedMemory, node.Name, err)
+		} else {
+			settings.reservation = reservation
+		}
+	}
+
+	if value, ok := nodeValue(node, NodeLabelForHealthCheck); ok {
+		healthCheck, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Warningf("Ignore %s of node %s due to %v", NodeLabelForHealthCheck, node.Name, err)
+		} else {
+			settings.healthCheck = healthCheck
+		}
+	}
+
+	return settings
+}
+
+// watchNode watches the node, and sends the latest node when it's changed
+func watchNode(stop <-chan struct{}) <-chan *v1.Node {
+	updates := make(chan *v1.Node, 1)
+	send := func(obj interface{}) {
+		node, ok := obj.(*v1.Node)
+		if !ok {
+			return
+		}
+		// drop the stale node which is not consumed yet
+		select {
+		case <-updates:
+		default:
+		}
+		updates <- node
+	}
+
+	lw := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "nodes", v1.NamespaceAll,
+		fields.OneTermEqualSelector("metadata.name", nodeName))
+	_, controller := cache.NewInformer(lw, &v1.Node{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: send,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*v1.Node)
+			newNode, newOK := newObj.(*v1.Node)
+			if ok && newOK && !metadataChanged(oldNode, newNode) {
+				return
+			}
+			send(newObj)
+		},
+	})
+	go controller.Run(stop)
+
+	return updates
+}
+
+// metadataChanged returns true if the labels or the annotations which the config and the settings
+// are read from are changed. The status and the memory usage published by the device plugin itself
+// change every interval, and they're ignored.
+func metadataChanged(old, node *v1.Node) bool {
+	if !reflect.DeepEqual(old.Labels, node.Labels) {
+		return true
+	}
+	oldAnnotations := map[string]string{}
+	for k, v := range old.Annotations {
+		oldAnnotations[k] = v
+	}
+	annotations := map[string]string{}
+	for k, v := range node.Annotations {
+		annotations[k] = v
+	}
+	delete(oldAnnotations, NodeAnnotationMemoryUsage)
+	delete(annotations, NodeAnnotationMemoryUsage)
+	return !reflect.DeepEqual(oldAnnotations, annotations)
+}
+
+// applySettings applies the new settings of the node to the running device plugin, the node is
+// annotated and the event is recorded after releasing the lock
+func (m *NvidiaDevicePlugin) applySettings(settings nodeSettings) {
+	changes, reserved, reservationChanged := m.updateSettings(settings)
+	if len(changes) == 0 {
+		return
+	}
+	log.Infof("settings of node %s are changed: %s", nodeName, strings.Join(changes, ", "))
+
+	if reservationChanged {
+		err := patchNodeAnnotations(map[string]string{
+			NodeAnnotationReservedMemory: fmt.Sprintf("%d", reserved),
+		})
+		if err != nil {
+			log.Warningf("Failed to publish reserved memory due to %v", err)
+		}
+	}
+	recordNodeEvent(v1.EventTypeNormal, "GPUShareSettingsChanged", strings.Join(changes, ", "))
+}
+
+// memoryUnit returns the memory unit of gpu-mem, it's fixed for the device plugin since a new unit
+// restarts it
+func (m *NvidiaDevicePlugin) memoryUnit() MemoryUnit {
+	return m.unit
+}
+
+// updateSettings updates the devices and the health check with the new settings, and returns
+// the changes and the reserved memory
+func (m *NvidiaDevicePlugin) updateSettings(settings nodeSettings) (changes []string, reserved uint, reservationChanged bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	changes = settings.changes(m.settings)
+	if len(changes) == 0 {
+		return changes, reservedGPUMemory, false
+	}
+
+	reservationChanged = settings.reservation != m.settings.reservation
+	if reservationChanged {
+		if err := m.updateDevices(settings.reservation); err != nil {
+			// the reservation is applied again with the next update of the node
+			log.Warningf("Failed to apply the reserved memory %s due to %v, keep %s", settings.reservation, err, m.settings.reservation)
+			settings.reservation = m.settings.reservation
+			reservationChanged = false
+			changes = settings.changes(m.settings)
+		}
+	}
+	if settings.healthCheck != m.settings.healthCheck {
+		m.restartHealthCheck(settings.healthCheck)
+	}
+	m.settings = settings
+
+	return changes, reservedGPUMemory, reservationChanged
+}
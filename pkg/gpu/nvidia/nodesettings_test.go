@@ -0,0 +1,175 @@
+package nvidia
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func TestNodeSettingsOf(t *testing.T) {
+	defaults := nodeSettings{
+		reservation: MemoryReservation{MiB: 256},
+		healthCheck: true,
+	}
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		expected    nodeSettings
+	}{
+		{
+			name:     "defaults",
+			expected: defaults,
+		},
+		{
+			name: "labels",
+			labels: map[string]string{
+				EnvNodeLabelForDisableCGPU: "true",
+				NodeLabelForReservedMemory: "5pct",
+				NodeLabelForHealthCheck:    "false",
+			},
+			expected: nodeSettings{
+				disableCGPUIsolation: true,
+				reservation:          MemoryReservation{Percent: 5},
+				healthCheck:          false,
+			},
+		},
+		{
+			name:        "annotations override labels",
+			labels:      map[string]string{NodeLabelForReservedMemory: "1GiB", NodeLabelForHealthCheck: "false"},
+			annotations: map[string]string{NodeLabelForReservedMemory: "10%", NodeLabelForHealthCheck: "true", EnvNodeLabelForDisableCGPU: "true"},
+			expected: nodeSettings{
+				disableCGPUIsolation: true,
+				reservation:          MemoryReservation{Percent: 10},
+				healthCheck:          true,
+			},
+		},
+		{
+			name: "invalid values are ignored",
+			labels: map[string]string{
+				EnvNodeLabelForDisableCGPU: "yes",
+				NodeLabelForReservedMemory: "512",
+				NodeLabelForHealthCheck:    "maybe",
+			},
+			expected: defaults,
+		},
+		{
+			name:     "no reservation",
+			labels:   map[string]string{NodeLabelForReservedMemory: "0"},
+			expected: nodeSettings{healthCheck: true},
+		},
+	}
+
+	for _, test := range tests {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "node1",
+			Labels:      test.labels,
+			Annotations: test.annotations,
+		}}
+		if settings := nodeSettingsOf(node, defaults); settings != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, settings)
+		}
+	}
+}
+
+func TestNodeSettingsChanges(t *testing.T) {
+	old := nodeSettings{reservation: MemoryReservation{MiB: 256}}
+	if changes := old.changes(old); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+
+	settings := nodeSettings{
+		disableCGPUIsolation: true,
+		reservation:          MemoryReservation{Percent: 5},
+		healthCheck:          true,
+	}
+	expected := []string{
+		"cgpu isolation disabled false -> true",
+		"reserved memory 256MiB -> 5%",
+		"health check false -> true",
+	}
+	if changes := settings.changes(old); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+}
+
+func TestUpdateSettingsKeepsDevices(t *testing.T) {
+	oldBackend := backend
+	defer func() { backend = oldBackend }()
+	b := newFakeBackend()
+	b.err = fmt.Errorf("nvml: unknown error")
+	backend = b
+
+	devs := []*pluginapi.Device{{ID: "GPU-a-_-0", Health: pluginapi.Healthy}}
+	old := nodeSettings{reservation: MemoryReservation{MiB: 256}}
+	m := &NvidiaDevicePlugin{devs: devs, settings: old, unhealthyDevs: map[string]bool{}}
+
+	changes, _, reservationChanged := m.updateSettings(nodeSettings{reservation: MemoryReservation{MiB: 512}, disableCGPUIsolation: true})
+	if reservationChanged || !reflect.DeepEqual(m.devs, devs) || m.settings.reservation != old.reservation {
+		t.Errorf("expected the devices and the reservation to be kept, got %v, %s", m.devs, m.settings.reservation)
+	}
+	if !reflect.DeepEqual(changes, []string{"cgpu isolation disabled false -> true"}) {
+		t.Errorf("expected only the other settings to be changed, got %v", changes)
+	}
+}
+
+func TestListDevicesCopies(t *testing.T) {
+	m := &NvidiaDevicePlugin{
+		devs:          []*pluginapi.Device{{ID: "GPU-a-_-0", Health: pluginapi.Healthy}},
+		unhealthyDevs: map[string]bool{},
+	}
+	listed := m.listDevices()
+	m.markUnhealthy("GPU-a")
+	if listed[0].Health != pluginapi.Healthy || m.devs[0].Health != pluginapi.Unhealthy {
+		t.Errorf("expected the listed devices to be a copy, got %v and %v", listed, m.devs)
+	}
+}
+
+func TestMetadataChanged(t *testing.T) {
+	node := func(labels, annotations map[string]string, heartbeat int64) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: annotations},
+			Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{
+				Type:              v1.NodeReady,
+				LastHeartbeatTime: metav1.Unix(heartbeat, 0),
+			}}},
+		}
+	}
+	old := node(map[string]string{NodeLabelForHealthCheck: "true"},
+		map[string]string{NodeAnnotationMemoryUsage: `{"gpus":[]}`, NodeAnnotationMemoryUnit: "GiB"}, 1)
+
+	tests := []struct {
+		name     string
+		node     *v1.Node
+		expected bool
+	}{
+		{
+			name: "heartbeat and memory usage",
+			node: node(map[string]string{NodeLabelForHealthCheck: "true"},
+				map[string]string{NodeAnnotationMemoryUsage: `{"gpus":[{"index":0}]}`, NodeAnnotationMemoryUnit: "GiB"}, 2),
+		},
+		{
+			name: "label",
+			node: node(map[string]string{NodeLabelForHealthCheck: "false"},
+				map[string]string{NodeAnnotationMemoryUsage: `{"gpus":[]}`, NodeAnnotationMemoryUnit: "GiB"}, 1),
+			expected: true,
+		},
+		{
+			name: "annotation",
+			node: node(map[string]string{NodeLabelForHealthCheck: "true"},
+				map[string]string{NodeAnnotationMemoryUsage: `{"gpus":[]}`, NodeAnnotationMemoryUnit: "GiB", NodeLabelForReservedMemory: "1"}, 1),
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		if changed := metadataChanged(old, test.node); changed != test.expected {
+			t.Errorf("%s: expected changed %t, got %t", test.name, test.expected, changed)
+		}
+	}
+}
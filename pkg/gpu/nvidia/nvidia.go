@@ -13,8 +13,8 @@ import (
 )
 
 var (
-	gpuMemory uint
-	metric    MemoryUnit
+	gpuMemory         uint
+	reservedGPUMemory uint
 )
 
 func check(err error) {
@@ -31,51 +31,61 @@ func extractRealDeviceID(fakeDeviceID string) string {
 	return strings.Split(fakeDeviceID, "-_-")[0]
 }
 
-func setGPUMemory(raw uint) {
-	v := raw
-	if metric == GiBPrefix {
-		v = raw / 1024
+func setGPUMemory(raw uint, unit MemoryUnit, reservation MemoryReservation) {
+	v := raw / unit.MiB()
+	if v == 0 {
+		log.Warningf("memory unit %s is larger than the gpu memory %dMiB", unit, raw)
 	}
 	gpuMemory = v
 	log.Infof("set gpu memory: %d", gpuMemory)
+
+	// round the reserved memory up to the memory unit
+	reserved := (reservation.Of(raw) + unit.MiB() - 1) / unit.MiB()
+	if reserved > gpuMemory {
+		reserved = gpuMemory
+	}
+	reservedGPUMemory = reserved
+	log.Infof("set reserved gpu memory: %d (%s)", reservedGPUMemory, reservation)
 }
 
 func getGPUMemory() uint {
 	return gpuMemory
 }
 
+// getAllocatableGPUMemory returns the gpu memory of each GPU which is exposed as fake devices
+func getAllocatableGPUMemory() uint {
+	return gpuMemory - reservedGPUMemory
+}
+
 func getDeviceCount() uint {
 	n, err := nvml.GetDeviceCount()
 	check(err)
 	return n
 }
 
-func getDevices() ([]*pluginapi.Device, map[string]uint) {
-	n, err := nvml.GetDeviceCount()
-	check(err)
+// getDevices returns the fake devices of the GPUs with the reservation, it's called at the start and
+// when the reservation of the node is changed, so the error is returned instead of exiting
+func getDevices(unit MemoryUnit, reservation MemoryReservation) ([]*pluginapi.Device, map[string]uint, error) {
+	gpus, err := backend.Devices()
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var devs []*pluginapi.Device
 	realDevNames := map[string]uint{}
-	for i := uint(0); i < n; i++ {
-		d, err := nvml.NewDevice(i)
-		check(err)
-		// realDevNames = append(realDevNames, d.UUID)
-		var id uint
+	for i, d := range gpus {
 		log.Infof("Deivce %s's Path is %s", d.UUID, d.Path)
-		_, err = fmt.Sscanf(d.Path, "/dev/nvidia%d", &id)
-		check(err)
-		realDevNames[d.UUID] = id
-		// var KiB uint64 = 1024
-		log.Infof("# device Memory: %d", uint(*d.Memory))
-		if getGPUMemory() == uint(0) {
-			setGPUMemory(uint(*d.Memory))
+		realDevNames[d.UUID] = d.Index
+		log.Infof("# device Memory: %d", d.Memory)
+		if i == 0 {
+			setGPUMemory(d.Memory, unit, reservation)
 		}
-		for j := uint(0); j < getGPUMemory(); j++ {
+		for j := uint(0); j < getAllocatableGPUMemory(); j++ {
 			fakeID := generateFakeDeviceID(d.UUID, j)
 			if j == 0 {
 				log.Infoln("# Add first device ID: " + fakeID)
 			}
-			if j == getGPUMemory()-1 {
+			if j == getAllocatableGPUMemory()-1 {
 				log.Infoln("# Add last device ID: " + fakeID)
 			}
 			devs = append(devs, &pluginapi.Device{
@@ -85,7 +95,7 @@ func getDevices() ([]*pluginapi.Device, map[string]uint) {
 		}
 	}
 
-	return devs, realDevNames
+	return devs, realDevNames, nil
 }
 
 func deviceExists(devs []*pluginapi.Device, id string) bool {
@@ -107,12 +117,12 @@ func watchXIDs(ctx context.Context, devs []*pluginapi.Device, xids chan<- *plugi
 		if err != nil && strings.HasSuffix(err.Error(), "Not Supported") {
 			log.Infof("Warning: %s (%s) is too old to support healthchecking: %s. Marking it unhealthy.", realDeviceID, d.ID, err)
 
-			xids <- d
+			sendXID(ctx, xids, d)
 			continue
 		}
 
 		if err != nil {
-			log.Fatalf("Fatal error:", err)
+			log.Fatalf("Fatal error: %v", err)
 		}
 	}
 
@@ -138,15 +148,23 @@ func watchXIDs(ctx context.Context, devs []*pluginapi.Device, xids chan<- *plugi
 		if e.UUID == nil || len(*e.UUID) == 0 {
 			// All devices are unhealthy
 			for _, d := range devs {
-				xids <- d
+				sendXID(ctx, xids, d)
 			}
 			continue
 		}
 
 		for _, d := range devs {
 			if extractRealDeviceID(d.ID) == *e.UUID {
-				xids <- d
+				sendXID(ctx, xids, d)
 			}
 		}
 	}
 }
+
+// sendXID sends the unhealthy device unless the health check is stopped
+func sendXID(ctx context.Context, xids chan<- *pluginapi.Device, d *pluginapi.Device) {
+	select {
+	case xids <- d:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,110 @@
+package nvidia
+
+// The vendored NVML bindings don't expose the CUDA version of the driver and the MIG mode,
+// they're looked up in libnvidia-ml which is loaded by nvml.Init.
+
+/*
+#cgo LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stddef.h>
+
+#define NVML_SUCCESS                  0
+#define NVML_ERROR_NOT_SUPPORTED      3
+#define NVML_ERROR_LIBRARY_NOT_FOUND  12
+#define NVML_ERROR_FUNCTION_NOT_FOUND 13
+
+typedef void *nvmlDevice_t;
+
+static int nvmlSymbol(const char *name, void **sym)
+{
+	void *handle = dlopen("libnvidia-ml.so.1", RTLD_LAZY | RTLD_NOLOAD);
+	if (handle == NULL) {
+		return NVML_ERROR_LIBRARY_NOT_FOUND;
+	}
+	*sym = dlsym(handle, name);
+	// the library stays loaded by the bindings
+	dlclose(handle);
+	return *sym == NULL ? NVML_ERROR_FUNCTION_NOT_FOUND : NVML_SUCCESS;
+}
+
+static int nvmlCudaDriverVersion(int *version)
+{
+	int (*getVersion)(int *);
+	int ret = nvmlSymbol("nvmlSystemGetCudaDriverVersion", (void **)&getVersion);
+	if (ret != NVML_SUCCESS) {
+		return ret;
+	}
+	return getVersion(version);
+}
+
+static int nvmlMigMode(unsigned int index, unsigned int *current)
+{
+	int (*getHandle)(unsigned int, nvmlDevice_t *);
+	int (*getMigMode)(nvmlDevice_t, unsigned int *, unsigned int *);
+	nvmlDevice_t device;
+	unsigned int pending;
+
+	int ret = nvmlSymbol("nvmlDeviceGetHandleByIndex_v2", (void **)&getHandle);
+	if (ret != NVML_SUCCESS) {
+		return ret;
+	}
+	if ((ret = nvmlSymbol("nvmlDeviceGetMigMode", (void **)&getMigMode)) != NVML_SUCCESS) {
+		return ret;
+	}
+	if ((ret = getHandle(index, &device)) != NVML_SUCCESS) {
+		return ret;
+	}
+	return getMigMode(device, current, &pending);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+)
+
+const (
+	nvmlSuccess             = C.NVML_SUCCESS
+	nvmlErrorNotSupported   = C.NVML_ERROR_NOT_SUPPORTED
+	nvmlErrorLibNotFound    = C.NVML_ERROR_LIBRARY_NOT_FOUND
+	nvmlErrorFuncNotFound   = C.NVML_ERROR_FUNCTION_NOT_FOUND
+	nvmlDeviceMIGModeEnable = 1
+)
+
+func nvmlError(ret C.int) error {
+	switch ret {
+	case nvmlErrorLibNotFound, nvmlErrorFuncNotFound:
+		return errNotSupported
+	default:
+		return fmt.Errorf("nvml error %d", int(ret))
+	}
+}
+
+// nvmlCUDAVersion returns the latest CUDA version supported by the driver, e.g. 10.1
+func nvmlCUDAVersion() (string, error) {
+	var version C.int
+	if ret := C.nvmlCudaDriverVersion(&version); ret != nvmlSuccess {
+		return "", nvmlError(ret)
+	}
+	return cudaVersionString(int(version)), nil
+}
+
+// cudaVersionString formats the CUDA version of the driver, e.g. 10.1 for 10010
+func cudaVersionString(version int) string {
+	return fmt.Sprintf("%d.%d", version/1000, version%1000/10)
+}
+
+// nvmlMIGEnabled returns true if MIG is enabled on the GPU of the NVML index, the GPUs before
+// Ampere don't support MIG
+func nvmlMIGEnabled(index uint) (bool, error) {
+	var current C.uint
+	ret := C.nvmlMigMode(C.uint(index), &current)
+	switch ret {
+	case nvmlSuccess:
+		return current == nvmlDeviceMIGModeEnable, nil
+	case nvmlErrorNotSupported:
+		return false, nil
+	default:
+		return false, nvmlError(ret)
+	}
+}
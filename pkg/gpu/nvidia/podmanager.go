@@ -1,6 +1,7 @@
 package nvidia
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/kubelet/client"
@@ -12,7 +13,9 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	nodeutil "k8s.io/kubernetes/pkg/util/node"
 	"os"
@@ -56,22 +59,13 @@ func kubeInit() {
 
 }
 
-func disableCGPUIsolationOrNot() (bool, error) {
-	disable := false
-	node, err := clientset.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
-	if err != nil {
-		return disable, err
-	}
-	labels := node.ObjectMeta.Labels
-	value, ok := labels[EnvNodeLabelForDisableCGPU]
-	if ok && value == "true" {
-		log.Infof("enable gpusharing mode and disable cgpu mode")
-		disable = true
-	}
-	return disable, nil
+func patchGPUCount(gpuCount int) error {
+	return retryPatch(func() error {
+		return patchGPUCountOnce(gpuCount)
+	})
 }
 
-func patchGPUCount(gpuCount int) error {
+func patchGPUCountOnce(gpuCount int) error {
 	node, err := clientset.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
 	if err != nil {
 		return err
@@ -98,8 +92,90 @@ func patchGPUCount(gpuCount int) error {
 	return err
 }
 
-func getPodList(kubeletClient *client.KubeletClient) (*v1.PodList, error) {
-	podList, err := kubeletClient.GetNodeRunningPods()
+// patchNodeAnnotations publishes the annotations on the node, so the tools don't have to guess them
+func patchNodeAnnotations(annotations map[string]string) error {
+	return retryPatch(func() error {
+		return patchNodeAnnotationsOnce(annotations)
+	})
+}
+
+func patchNodeAnnotationsOnce(annotations map[string]string) error {
+	node, err := clientset.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	changed := map[string]string{}
+	for k, v := range annotations {
+		if node.Annotations[k] != v {
+			changed[k] = v
+		}
+	}
+	if len(changed) == 0 {
+		log.Infof("No need to update annotations %v", annotations)
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]map[string]string{"annotations": changed}})
+	if err != nil {
+		return err
+	}
+
+	_, err = clientset.CoreV1().Nodes().Patch(nodeName, types.StrategicMergePatchType, patch)
+	if err != nil {
+		log.Infof("Failed to update annotations %v.", changed)
+	} else {
+		log.Infof("Updated annotations %v successfully.", changed)
+	}
+	return err
+}
+
+// syncNodeLabels sets the labels on the node, and removes the other labels owned by the device plugin
+func syncNodeLabels(labels map[string]string) error {
+	return retryPatch(func() error {
+		return syncNodeLabelsOnce(labels)
+	})
+}
+
+func syncNodeLabelsOnce(labels map[string]string) error {
+	node, err := clientset.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	changed := map[string]interface{}{}
+	for _, k := range gpuLabelKeys {
+		v, ok := labels[k]
+		current, exists := node.Labels[k]
+		if !ok && exists {
+			changed[k] = nil
+		} else if ok && (!exists || current != v) {
+			changed[k] = v
+		}
+	}
+	if len(changed) == 0 {
+		log.Infof("No need to update labels %v", labels)
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]map[string]interface{}{"labels": changed}})
+	if err != nil {
+		return err
+	}
+
+	_, err = clientset.CoreV1().Nodes().Patch(nodeName, types.StrategicMergePatchType, patch)
+	if err != nil {
+		log.Infof("Failed to update labels %v.", changed)
+	} else {
+		log.Infof("Updated labels %v successfully.", changed)
+	}
+	return err
+}
+
+func getPodList(ctx context.Context, kubeletClient *client.KubeletClient) (*v1.PodList, error) {
+	podList, err := kubeletClient.GetPods(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -122,16 +198,26 @@ func getPodList(kubeletClient *client.KubeletClient) (*v1.PodList, error) {
 	return resultPodList, nil
 }
 
-func getPodListsByQueryKubelet(kubeletClient *client.KubeletClient) (*v1.PodList, error) {
-	podList, err := getPodList(kubeletClient)
-	for i := 0; i < retries && err != nil; i++ {
-		podList, err = getPodList(kubeletClient)
-		log.Warningf("failed to get pending pod list, retry")
-		time.Sleep(100 * time.Millisecond)
+// getPodListsByQueryKubelet lists the pending pods from kubelet, it stops retrying when the context is done,
+// e.g. kubelet cancels Allocate
+func getPodListsByQueryKubelet(ctx context.Context, kubeletClient *client.KubeletClient) (*v1.PodList, error) {
+	podList, err := getPodList(ctx, kubeletClient)
+	// the rejected credential won't be accepted by retrying
+	for i := 0; i < retries && err != nil && !client.IsAuthError(err); i++ {
+		log.Warningf("failed to get pending pod list due to %v, retry", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+		podList, err = getPodList(ctx, kubeletClient)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
 	}
 	if err != nil {
-		log.Warningf("not found from kubelet /pods api, start to list apiserver")
-		podList, err = getPodListsByListAPIServer()
+		log.Warningf("not found from kubelet /pods api due to %v, start to list apiserver", err)
+		podList, err = getPodListsByListAPIServer(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -139,27 +225,123 @@ func getPodListsByQueryKubelet(kubeletClient *client.KubeletClient) (*v1.PodList
 	return podList, nil
 }
 
-func getPodListsByListAPIServer() (*v1.PodList, error) {
+// getPodListsByListAPIServer lists the pending pods on the node from kube-apiserver, the requests and
+// the retries stop when the context is done
+func getPodListsByListAPIServer(ctx context.Context) (*v1.PodList, error) {
 	selector := fields.SelectorFromSet(fields.Set{"spec.nodeName": nodeName, "status.phase": "Pending"})
+	list := func() (*v1.PodList, error) {
+		// the typed client of this client-go doesn't take a context
+		podList := &v1.PodList{}
+		err := clientset.CoreV1().RESTClient().Get().
+			Context(ctx).
+			Resource("pods").
+			VersionedParams(&metav1.ListOptions{
+				FieldSelector: selector.String(),
+				LabelSelector: labels.Everything().String(),
+			}, scheme.ParameterCodec).
+			Do().
+			Into(podList)
+		return podList, err
+	}
+
+	podList, err := list()
+	for i := 0; i < 3 && err != nil; i++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(1 * time.Second):
+		}
+		podList, err = list()
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Pods assigned to node %v due to %v", nodeName, err)
+	}
+
+	return podList, nil
+}
+
+// getActivePodsInNode lists the pods on the node which are not terminated from kube-apiserver
+func getActivePodsInNode() ([]v1.Pod, error) {
+	selector := fields.SelectorFromSet(fields.Set{"spec.nodeName": nodeName})
 	podList, err := clientset.CoreV1().Pods(v1.NamespaceAll).List(metav1.ListOptions{
 		FieldSelector: selector.String(),
 		LabelSelector: labels.Everything().String(),
 	})
-	for i := 0; i < 3 && err != nil; i++ {
-		podList, err = clientset.CoreV1().Pods(v1.NamespaceAll).List(metav1.ListOptions{
-			FieldSelector: selector.String(),
-			LabelSelector: labels.Everything().String(),
-		})
-		time.Sleep(1 * time.Second)
-	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get Pods assigned to node %v", nodeName)
+		return nil, fmt.Errorf("failed to get Pods assigned to node %v due to %v", nodeName, err)
 	}
 
-	return podList, nil
+	return activePods(podList.Items), nil
+}
+
+// nodePodCache caches the pods on the node from a watch, so they're not listed from
+// kube-apiserver on every container start
+type nodePodCache struct {
+	store  cache.Store
+	synced cache.InformerSynced
+}
+
+// watchNodePods starts caching the pods on the node until the stop channel is closed
+func watchNodePods(stop <-chan struct{}) *nodePodCache {
+	lw := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "pods", v1.NamespaceAll,
+		fields.OneTermEqualSelector("spec.nodeName", nodeName))
+	store, controller := cache.NewInformer(lw, &v1.Pod{}, 0, cache.ResourceEventHandlerFuncs{})
+	go controller.Run(stop)
+
+	return &nodePodCache{store: store, synced: controller.HasSynced}
+}
+
+// get returns the cached pod, ok is false if the pod isn't cached
+func (c *nodePodCache) get(namespace, name string) (pod *v1.Pod, ok bool) {
+	obj, ok, err := c.store.GetByKey(namespace + "/" + name)
+	if err != nil || !ok {
+		return nil, false
+	}
+	pod, ok = obj.(*v1.Pod)
+	return pod, ok
+}
+
+// activePods returns the cached pods which are not terminated
+func (c *nodePodCache) activePods() []v1.Pod {
+	items := []v1.Pod{}
+	for _, obj := range c.store.List() {
+		if pod, ok := obj.(*v1.Pod); ok {
+			items = append(items, *pod)
+		}
+	}
+	return activePods(items)
+}
+
+// getActivePods lists the pods on the node which are not terminated from kubelet if queryKubelet
+// is set, it falls back to kube-apiserver if kubelet fails
+func getActivePods(ctx context.Context, queryKubelet bool, kubeletClient *client.KubeletClient) ([]v1.Pod, error) {
+	if queryKubelet {
+		podList, err := kubeletClient.GetPods(ctx)
+		if err == nil {
+			return activePods(podList.Items), nil
+		}
+		log.Warningf("failed to get pod list from kubelet due to %v, start to list apiserver", err)
+	}
+
+	return getActivePodsInNode()
+}
+
+func activePods(items []v1.Pod) []v1.Pod {
+	pods := []v1.Pod{}
+	for _, pod := range items {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+
+	return pods
 }
 
-func getPendingPodsInNode(queryKubelet bool, kubeletClient *client.KubeletClient) ([]v1.Pod, error) {
+func getPendingPodsInNode(ctx context.Context, queryKubelet bool, kubeletClient *client.KubeletClient) ([]v1.Pod, error) {
 	// pods, err := m.lister.List(labels.Everything())
 	// if err != nil {
 	// 	return nil, err
@@ -171,12 +353,12 @@ func getPendingPodsInNode(queryKubelet bool, kubeletClient *client.KubeletClient
 	var podList *v1.PodList
 	var err error
 	if queryKubelet {
-		podList, err = getPodListsByQueryKubelet(kubeletClient)
+		podList, err = getPodListsByQueryKubelet(ctx, kubeletClient)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		podList, err = getPodListsByListAPIServer()
+		podList, err = getPodListsByListAPIServer(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -211,13 +393,9 @@ func getPendingPodsInNode(queryKubelet bool, kubeletClient *client.KubeletClient
 	return pods, nil
 }
 
-// pick up the gpushare pod with assigned status is false, and
-func getCandidatePods(queryKubelet bool, client *client.KubeletClient) ([]*v1.Pod, error) {
+// pick up the gpushare pod with assigned status is false, and order them by the assume time
+func candidatePods(allPods []v1.Pod) []*v1.Pod {
 	candidatePods := []*v1.Pod{}
-	allPods, err := getPendingPodsInNode(queryKubelet, client)
-	if err != nil {
-		return candidatePods, err
-	}
 	for _, pod := range allPods {
 		current := pod
 		if isGPUMemoryAssumedPod(&current) {
@@ -234,7 +412,27 @@ func getCandidatePods(queryKubelet bool, client *client.KubeletClient) ([]*v1.Po
 		}
 	}
 
-	return makePodOrderdByAge(candidatePods), nil
+	return makePodOrderdByAge(candidatePods)
+}
+
+// unassumedPods returns the pending gpushare pods which aren't assumed by the scheduler extender,
+// such as the pods created with the node name, ordered by the creation time
+func unassumedPods(allPods []v1.Pod) []*v1.Pod {
+	pods := []*v1.Pod{}
+	for _, pod := range allPods {
+		current := pod
+		if current.Status.Phase != v1.PodPending || getGPUMemoryFromPodResource(&current) == 0 {
+			continue
+		}
+		if _, ok := current.Annotations[EnvResourceAssumeTime]; ok {
+			continue
+		}
+		pods = append(pods, &current)
+	}
+	sort.SliceStable(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+	})
+	return pods
 }
 
 // make the pod ordered by GPU assumed time
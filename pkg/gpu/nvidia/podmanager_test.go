@@ -0,0 +1,98 @@
+package nvidia
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/kubelet/client"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestGetPodListsByQueryKubeletContext(t *testing.T) {
+	var lock sync.Mutex
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests++
+		lock.Unlock()
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, _ := strconv.Atoi(port)
+	kubeletClient, err := client.NewKubeletClient(&client.KubeletClientConfig{
+		Address:         host,
+		Port:            uint(portNumber),
+		TLSClientConfig: rest.TLSClientConfig{Insecure: true},
+		HTTPTimeout:     time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the retries stop when the context is done, and kube-apiserver isn't listed
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err = getPodListsByQueryKubelet(ctx, kubeletClient); err != context.DeadlineExceeded {
+		t.Errorf("expected the context error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the retries to stop with the context, took %v", elapsed)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if requests > retries {
+		t.Errorf("expected fewer than %d requests before the context is done, got %d", retries, requests)
+	}
+}
+
+func TestGetPodListsByListAPIServerContext(t *testing.T) {
+	var lock sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests++
+		lock.Unlock()
+		selector, err := fields.ParseSelector(r.URL.Query().Get("fieldSelector"))
+		if err != nil || !selector.Matches(fields.Set{"spec.nodeName": "node1", "status.phase": "Pending"}) ||
+			selector.Matches(fields.Set{"spec.nodeName": "node2", "status.phase": "Pending"}) {
+			t.Errorf("unexpected field selector %q", r.URL.Query().Get("fieldSelector"))
+		}
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	oldClientset, oldNodeName := clientset, nodeName
+	defer func() { clientset, nodeName = oldClientset, oldNodeName }()
+	clientset = kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})
+	nodeName = "node1"
+
+	// the retries stop when the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := getPodListsByListAPIServer(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the context error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the retries to stop with the context, took %v", elapsed)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if requests != 1 {
+		t.Errorf("expected 1 request before the context is done, got %d", requests)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
 	log "github.com/golang/glog"
 	v1 "k8s.io/api/core/v1"
 )
@@ -24,12 +25,15 @@ func updatePodAnnotations(oldPod *v1.Pod) (newPod *v1.Pod) {
 	return newPod
 }
 
-func patchPodAnnotationSpecAssigned() ([]byte, error) {
+// patchPodAnnotationSpecAssigned sets the GPU index too, it's the same as the one set by the
+// scheduler extender, or it's the only GPU of the node for the pod which isn't assumed
+func patchPodAnnotationSpecAssigned(devIndex uint) ([]byte, error) {
 	now := time.Now()
 	patchAnnotations := map[string]interface{}{
 		"metadata": map[string]map[string]string{"annotations": {
 			EnvAssignedFlag:       "true",
 			EnvResourceAssumeTime: fmt.Sprintf("%d", now.UnixNano()),
+			EnvResourceIndex:      fmt.Sprintf("%d", devIndex),
 		}}}
 	return json.Marshal(patchAnnotations)
 }
@@ -51,7 +55,7 @@ func getGPUIDFromPodAnnotation(pod *v1.Pod) (id int) {
 				id = -1
 			}
 		} else {
-			log.Warningf("Failed to get dev id %s for pod %s in ns %s",
+			log.Warningf("Failed to get dev id for pod %s in ns %s",
 				pod.Name,
 				pod.Namespace)
 		}
@@ -62,13 +66,9 @@ func getGPUIDFromPodAnnotation(pod *v1.Pod) (id int) {
 
 // get assumed timestamp
 func getAssumeTimeFromPodAnnotation(pod *v1.Pod) (assumeTime uint64) {
-	if assumeTimeStr, ok := pod.ObjectMeta.Annotations[EnvResourceAssumeTime]; ok {
-		u64, err := strconv.ParseUint(assumeTimeStr, 10, 64)
-		if err != nil {
-			log.Warningf("Failed to parse assume Timestamp %s due to %v", assumeTimeStr, err)
-		} else {
-			assumeTime = u64
-		}
+	assumeTime, err := gpushare.AssumeTime(pod)
+	if err != nil {
+		log.Warningf("Failed to parse assume Timestamp of pod %s in ns %s due to %v", pod.Name, pod.Namespace, err)
 	}
 
 	return assumeTime
@@ -76,58 +76,15 @@ func getAssumeTimeFromPodAnnotation(pod *v1.Pod) (assumeTime uint64) {
 
 // determine if the pod is GPU share pod, and is already assumed but not assigned
 func isGPUMemoryAssumedPod(pod *v1.Pod) (assumed bool) {
-	log.V(6).Infof("Determine if the pod %v is GPUSharedAssumed pod", pod)
-	var ok bool
-
-	// 1. Check if it's for GPU share
-	if getGPUMemoryFromPodResource(pod) <= 0 {
-		log.V(6).Infof("Pod %s in namespace %s has not GPU Memory Request, so it's not GPUSharedAssumed assumed pod.",
-			pod.Name,
-			pod.Namespace)
-		return assumed
-	}
-
-	// 2. Check if it already has assume time
-	if _, ok = pod.ObjectMeta.Annotations[EnvResourceAssumeTime]; !ok {
-		log.V(4).Infof("No assume timestamp for pod %s in namespace %s, so it's not GPUSharedAssumed assumed pod.",
-			pod.Name,
-			pod.Namespace)
-		return assumed
-	}
-
-	// 3. Check if it has been assigned already
-	if assigned, ok := pod.ObjectMeta.Annotations[EnvAssignedFlag]; ok {
-
-		if assigned == "false" {
-			log.V(4).Infof("Found GPUSharedAssumed assumed pod %s in namespace %s.",
-				pod.Name,
-				pod.Namespace)
-			assumed = true
-		} else {
-			log.Infof("GPU assigned Flag for pod %s exists in namespace %s and its assigned status is %s, so it's not GPUSharedAssumed assumed pod.",
-				pod.Name,
-				pod.Namespace,
-				assigned)
-		}
-	} else {
-		log.Warningf("No GPU assigned Flag for pod %s in namespace %s, so it's not GPUSharedAssumed assumed pod.",
-			pod.Name,
-			pod.Namespace)
-	}
+	assumed, reason := gpushare.AssumedPodStatus(pod)
+	log.V(4).Infof("Pod %s in ns %s is GPUSharedAssumed pod: %v, %s", pod.Name, pod.Namespace, assumed, reason)
 
 	return assumed
 }
 
 // Get GPU Memory of the Pod
 func getGPUMemoryFromPodResource(pod *v1.Pod) uint {
-	var total uint
-	containers := pod.Spec.Containers
-	for _, container := range containers {
-		if val, ok := container.Resources.Limits[resourceName]; ok {
-			total += uint(val.Value())
-		}
-	}
-	return total
+	return gpushare.GPUMemoryOfPod(pod)
 }
 
 func podIsNotRunning(pod v1.Pod) bool {
@@ -0,0 +1,261 @@
+package nvidia
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	log "github.com/golang/glog"
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+// allocation records the GPU picked in Allocate for the fake devices of one container,
+// so PreStartContainer is able to find it by the device IDs
+type allocation struct {
+	// namespace, name and uid are of the pod assigned in Allocate
+	namespace string
+	name      string
+	uid       types.UID
+	devIndex  uint
+	// podReqGPU is the GPU memory requested by the pod
+	podReqGPU uint
+	// allocateTime is set when the allocation is recorded
+	allocateTime time.Time
+}
+
+func (a *allocation) String() string {
+	if a.name == "" {
+		return fmt.Sprintf("gpu %d", a.devIndex)
+	}
+	return fmt.Sprintf("pod %s in ns %s on gpu %d", a.name, a.namespace, a.devIndex)
+}
+
+// allocationKey is the key of the fake devices regardless of their order
+func allocationKey(devicesIDs []string) string {
+	ids := append([]string{}, devicesIDs...)
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// recordAllocation records the allocation of the container, it's called with the lock held.
+// Kubelet never gives a fake device to two running containers, so the allocations which
+// share any device with the new one are stale and removed.
+func (m *NvidiaDevicePlugin) recordAllocation(devicesIDs []string, a *allocation) {
+	ids := map[string]bool{}
+	for _, id := range devicesIDs {
+		ids[id] = true
+	}
+	for key := range m.allocations {
+		for _, id := range strings.Split(key, ",") {
+			if ids[id] {
+				delete(m.allocations, key)
+				break
+			}
+		}
+	}
+
+	a.allocateTime = time.Now()
+	m.allocations[allocationKey(devicesIDs)] = a
+}
+
+// PreStartContainer verifies the GPU picked in Allocate before the container starts:
+// the devices are assigned to the pod, the GPU is healthy and it's not over-committed.
+func (m *NvidiaDevicePlugin) PreStartContainer(ctx context.Context,
+	req *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+	m.RLock()
+	for _, id := range req.DevicesIDs {
+		if !deviceExists(m.devs, id) {
+			m.RUnlock()
+			return nil, fmt.Errorf("unknown gpu mem device %s", id)
+		}
+	}
+	a, ok := m.allocations[allocationKey(req.DevicesIDs)]
+	m.RUnlock()
+
+	if !ok {
+		// the device plugin is restarted after Allocate, or the container is restarted
+		// after the devices are given to the other container
+		log.Warningf("No allocation is found for devices %v, skip verifying it", req.DevicesIDs)
+		return &pluginapi.PreStartContainerResponse{}, nil
+	}
+
+	if err := m.verifyAllocation(a); err != nil {
+		if _, ok := err.(transientError); ok {
+			// kube-apiserver may be unavailable for a while, the containers keep starting
+			log.Warningf("Skip verifying the allocation of %s due to %v", a, err)
+			unverifiedAllocations.Add(1)
+			return &pluginapi.PreStartContainerResponse{}, nil
+		}
+		log.Warningf("Failed to verify the allocation of %s due to %v", a, err)
+		if a.name != "" {
+			recordPodEvent(a.namespace, a.name, a.uid, v1.EventTypeWarning, "GPUSharePreStartFailed", err.Error())
+		}
+		return nil, fmt.Errorf("gpushare: failed to verify %s: %v", a, err)
+	}
+
+	log.V(4).Infof("Verified the allocation of %s for devices %v", a, req.DevicesIDs)
+	return &pluginapi.PreStartContainerResponse{}, nil
+}
+
+// transientError is the failure to get the pods or the namespace for verifying an allocation,
+// the allocation is neither verified nor denied
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string {
+	return e.err.Error()
+}
+
+// verifyAllocation checks the allocation against the pod and the GPU. The pods are got from
+// the cache of the node without the lock, so Allocate isn't blocked by the requests. It returns
+// a transientError if the pods or the namespace can't be got.
+func (m *NvidiaDevicePlugin) verifyAllocation(a *allocation) error {
+	pod, pods, err := m.podsOfAllocation(a)
+	if err != nil {
+		return err
+	}
+	limit, err := namespaceMaxMemoryPerGPU(a.namespace, m.unit)
+	if err != nil {
+		return transientError{err}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	return m.checkAllocation(a, pod, pods, limit)
+}
+
+// podsOfAllocation returns the pod of the allocation and the active pods on the node from the
+// cache. Kube-apiserver is queried only if the cache isn't synced or the pod isn't cached yet.
+func (m *NvidiaDevicePlugin) podsOfAllocation(a *allocation) (pod *v1.Pod, pods []v1.Pod, err error) {
+	synced := m.podCache != nil && m.podCache.synced()
+	if a.name != "" {
+		var ok bool
+		if synced {
+			pod, ok = m.podCache.get(a.namespace, a.name)
+		}
+		if !ok {
+			pod, err = clientset.CoreV1().Pods(a.namespace).Get(a.name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return nil, nil, fmt.Errorf("the pod is deleted")
+			}
+			if err != nil {
+				return nil, nil, transientError{fmt.Errorf("failed to get the pod: %v", err)}
+			}
+		}
+	}
+
+	if synced {
+		return pod, m.podCache.activePods(), nil
+	}
+	pods, err = getActivePodsInNode()
+	if err != nil {
+		return nil, nil, transientError{err}
+	}
+	return pod, pods, nil
+}
+
+// namespaceMaxMemoryPerGPU returns the gpu-mem in the unit the pods of the namespace may use on any
+// single GPU, or -1 if it's not limited
+func namespaceMaxMemoryPerGPU(namespace string, unit MemoryUnit) (int64, error) {
+	if namespace == "" {
+		return -1, nil
+	}
+	ns, err := clientset.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+	if err != nil {
+		return -1, fmt.Errorf("failed to get the namespace: %v", err)
+	}
+	quota, ok, err := gpushare.MaxMemoryPerGPUOfNamespace(ns)
+	if err != nil {
+		log.Warningf("%v, ignore it", err)
+	}
+	if !ok {
+		return -1, nil
+	}
+	return quota.OnNode(int64(unit.MiB())), nil
+}
+
+// checkAllocation checks the allocation against the pod of it, the pods in the node and the
+// gpu-mem quota of the namespace on the GPU (-1 if it's not limited), it's called with the lock held
+func (m *NvidiaDevicePlugin) checkAllocation(a *allocation, pod *v1.Pod, pods []v1.Pod, limit int64) error {
+	if pod != nil {
+		if pod.UID != a.uid {
+			return fmt.Errorf("the pod is recreated with uid %s", pod.UID)
+		}
+		// the pod which isn't assumed has no GPU index unless it's assigned by annotation
+		if id := getGPUIDFromPodAnnotation(pod); id >= 0 && id != int(a.devIndex) {
+			return fmt.Errorf("the pod is assigned to gpu %d", id)
+		}
+		if !m.store.IsAssigned(pod) {
+			return fmt.Errorf("the pod is not assigned, %s is %q", EnvAssignedFlag, pod.Annotations[EnvAssignedFlag])
+		}
+	}
+
+	realID, ok := m.GetDeviceNameByIndex(a.devIndex)
+	if !ok {
+		return fmt.Errorf("gpu %d is not found", a.devIndex)
+	}
+	if m.unhealthyDevs[realID] {
+		return fmt.Errorf("gpu %d (%s) is unhealthy", a.devIndex, realID)
+	}
+
+	used := usedGPUMemory(pods, a.devIndex, a.uid, m.store.IsAssigned) + a.podReqGPU
+	if allocatable := getAllocatableGPUMemory(); used > allocatable {
+		return fmt.Errorf("gpu %d is over-committed, %s is requested by the pods but only %s is allocatable",
+			a.devIndex,
+			m.unit.Quantity(used),
+			m.unit.Quantity(allocatable))
+	}
+
+	// the webhook rejects the pods early, but the GPU is known only here
+	if limit >= 0 {
+		used := namespaceGPUMemory(pods, a.namespace, a.devIndex, a.uid, m.store.IsAssigned) + a.podReqGPU
+		if int64(used) > limit {
+			return fmt.Errorf("ns %s may use at most %d %s on any single GPU, but the pods use %d on gpu %d",
+				a.namespace, limit, resourceName, used, a.devIndex)
+		}
+	}
+
+	return nil
+}
+
+// usedGPUMemory sums the GPU memory of the pods assigned to the GPU, except the pod with the uid
+func usedGPUMemory(pods []v1.Pod, devIndex uint, exclude types.UID, assigned func(*v1.Pod) bool) uint {
+	return namespaceGPUMemory(pods, v1.NamespaceAll, devIndex, exclude, assigned)
+}
+
+// namespaceGPUMemory sums the GPU memory of the pods of the namespace assigned to the GPU, except
+// the pod with the uid. The pods of all the namespaces are summed if the namespace is empty.
+func namespaceGPUMemory(pods []v1.Pod, namespace string, devIndex uint, exclude types.UID, assigned func(*v1.Pod) bool) uint {
+	var used uint
+	for i := range pods {
+		pod := &pods[i]
+		if namespace != v1.NamespaceAll && pod.Namespace != namespace {
+			continue
+		}
+		if exclude != "" && pod.UID == exclude {
+			continue
+		}
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		if !assigned(pod) {
+			continue
+		}
+		id, err := strconv.Atoi(pod.Annotations[EnvResourceIndex])
+		if err != nil || id != int(devIndex) {
+			continue
+		}
+		used += getGPUMemoryFromPodResource(pod)
+	}
+
+	return used
+}
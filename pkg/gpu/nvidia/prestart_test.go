@@ -0,0 +1,271 @@
+package nvidia
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
+)
+
+func newGPUSharePod(uid types.UID, mem int64, annotations map[string]string, phase v1.PodPhase) v1.Pod {
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        string(uid),
+			Namespace:   "default",
+			UID:         uid,
+			Annotations: annotations,
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name: "main",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{
+						resourceName: *resource.NewQuantity(mem, resource.DecimalSI),
+					},
+				},
+			}},
+		},
+		Status: v1.PodStatus{Phase: phase},
+	}
+}
+
+func TestUsedGPUMemory(t *testing.T) {
+	assignedTo := func(idx string) map[string]string {
+		return map[string]string{EnvAssignedFlag: "true", EnvResourceIndex: idx}
+	}
+	pods := []v1.Pod{
+		newGPUSharePod("a", 4, assignedTo("0"), v1.PodRunning),
+		newGPUSharePod("b", 2, assignedTo("0"), v1.PodPending),
+		// on the other gpu
+		newGPUSharePod("c", 8, assignedTo("1"), v1.PodRunning),
+		// terminated
+		newGPUSharePod("d", 8, assignedTo("0"), v1.PodSucceeded),
+		// assumed but not assigned
+		newGPUSharePod("e", 8, map[string]string{EnvAssignedFlag: "false", EnvResourceIndex: "0"}, v1.PodPending),
+	}
+
+	if used := usedGPUMemory(pods, 0, "", isAssignedByAnnotation); used != 6 {
+		t.Errorf("expected 6 used on gpu 0, got %d", used)
+	}
+	if used := usedGPUMemory(pods, 0, "b", isAssignedByAnnotation); used != 4 {
+		t.Errorf("expected 4 used on gpu 0 except pod b, got %d", used)
+	}
+	if used := usedGPUMemory(pods, 1, "", isAssignedByAnnotation); used != 8 {
+		t.Errorf("expected 8 used on gpu 1, got %d", used)
+	}
+}
+
+func TestRecordAllocation(t *testing.T) {
+	m := &NvidiaDevicePlugin{allocations: map[string]*allocation{}}
+
+	m.recordAllocation([]string{"GPU-a-_-1", "GPU-a-_-0"}, &allocation{name: "p1", devIndex: 0})
+	m.recordAllocation([]string{"GPU-a-_-2"}, &allocation{name: "p2", devIndex: 1})
+	if a := m.allocations[allocationKey([]string{"GPU-a-_-0", "GPU-a-_-1"})]; a == nil || a.name != "p1" {
+		t.Fatalf("expected the allocation of p1 regardless of the device order, got %v", a)
+	}
+
+	// GPU-a-_-1 is given to p3, so the allocation of p1 is stale
+	m.recordAllocation([]string{"GPU-a-_-1"}, &allocation{name: "p3", devIndex: 1})
+	if len(m.allocations) != 2 {
+		t.Errorf("expected 2 allocations, got %v", m.allocations)
+	}
+	if _, ok := m.allocations[allocationKey([]string{"GPU-a-_-0", "GPU-a-_-1"})]; ok {
+		t.Errorf("expected the stale allocation of p1 to be removed")
+	}
+}
+
+func TestCheckAllocation(t *testing.T) {
+	oldGPUMemory, oldReserved := gpuMemory, reservedGPUMemory
+	defer func() { gpuMemory, reservedGPUMemory = oldGPUMemory, oldReserved }()
+	gpuMemory, reservedGPUMemory = 16, 1
+
+	m := &NvidiaDevicePlugin{
+		devNameMap:    map[string]uint{"GPU-a": 0, "GPU-b": 1},
+		unhealthyDevs: map[string]bool{"GPU-b": true},
+		unit:          GiBPrefix,
+		store:         annotationStore{},
+	}
+	assigned := func(index string) map[string]string {
+		return map[string]string{EnvAssignedFlag: "true", EnvResourceIndex: index}
+	}
+	pod := newGPUSharePod("p1", 4, assigned("0"), v1.PodPending)
+	other := newGPUSharePod("p2", 8, assigned("0"), v1.PodRunning)
+
+	tests := []struct {
+		name        string
+		a           *allocation
+		pod         *v1.Pod
+		pods        []v1.Pod
+		limit       int64
+		expectedErr bool
+	}{
+		{
+			name:  "fits",
+			a:     &allocation{name: "p1", uid: "p1", namespace: "default", devIndex: 0, podReqGPU: 4},
+			pod:   &pod,
+			pods:  []v1.Pod{pod, other},
+			limit: -1,
+		},
+		{
+			name:  "fits the quota of the namespace",
+			a:     &allocation{name: "p1", uid: "p1", namespace: "default", devIndex: 0, podReqGPU: 4},
+			pod:   &pod,
+			pods:  []v1.Pod{pod, other, newGPUSharePod("p3", 4, assigned("1"), v1.PodRunning)},
+			limit: 12,
+		},
+		{
+			name:        "exceeds the quota of the namespace",
+			a:           &allocation{name: "p1", uid: "p1", namespace: "default", devIndex: 0, podReqGPU: 4},
+			pod:         &pod,
+			pods:        []v1.Pod{pod, other},
+			limit:       11,
+			expectedErr: true,
+		},
+		{
+			name:  "without pod",
+			a:     &allocation{devIndex: 0, podReqGPU: 4},
+			pods:  []v1.Pod{other},
+			limit: -1,
+		},
+		{
+			name:        "recreated pod",
+			a:           &allocation{name: "p1", uid: "old", devIndex: 0, podReqGPU: 4},
+			pod:         &pod,
+			limit:       -1,
+			expectedErr: true,
+		},
+		{
+			name:        "assigned to another gpu",
+			a:           &allocation{name: "p1", uid: "p1", devIndex: 2, podReqGPU: 4},
+			pod:         &pod,
+			limit:       -1,
+			expectedErr: true,
+		},
+		{
+			name:        "unhealthy gpu",
+			a:           &allocation{devIndex: 1, podReqGPU: 4},
+			limit:       -1,
+			expectedErr: true,
+		},
+		{
+			name:        "over-committed",
+			a:           &allocation{devIndex: 0, podReqGPU: 8},
+			pods:        []v1.Pod{other},
+			limit:       -1,
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		err := m.checkAllocation(test.a, test.pod, test.pods, test.limit)
+		if test.expectedErr && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if !test.expectedErr && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
+
+func TestPreStartContainer(t *testing.T) {
+	oldClientset, oldGPUMemory, oldReserved := clientset, gpuMemory, reservedGPUMemory
+	defer func() { clientset, gpuMemory, reservedGPUMemory = oldClientset, oldGPUMemory, oldReserved }()
+	gpuMemory, reservedGPUMemory = 16, 1
+
+	var lock sync.Mutex
+	requests, status := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests++
+		if status == http.StatusOK {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"kind":"Namespace","apiVersion":"v1","metadata":{"name":"default"}}`))
+			return
+		}
+		http.Error(w, "unavailable", status)
+	}))
+	defer server.Close()
+	clientset = kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})
+
+	pod := newGPUSharePod("p1", 4, map[string]string{EnvAssignedFlag: "true", EnvResourceIndex: "0"}, v1.PodPending)
+	cached := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	cached.Add(&pod)
+	ids := []string{"GPU-a-_-0", "GPU-a-_-1", "GPU-a-_-2", "GPU-a-_-3"}
+
+	tests := []struct {
+		name             string
+		podCache         *nodePodCache
+		status           int
+		expectedErr      bool
+		expectedRequests int
+		expectedSkipped  int64
+	}{
+		{
+			name:             "pod is cached",
+			podCache:         &nodePodCache{store: cached, synced: func() bool { return true }},
+			status:           http.StatusOK,
+			expectedRequests: 1,
+		},
+		{
+			name:             "apiserver is unavailable",
+			status:           http.StatusInternalServerError,
+			expectedRequests: 1,
+			expectedSkipped:  1,
+		},
+		{
+			name:        "pod is deleted",
+			status:      http.StatusNotFound,
+			expectedErr: true,
+			// the pod and the event of the failure
+			expectedRequests: 2,
+		},
+	}
+
+	for _, test := range tests {
+		devs := []*pluginapi.Device{}
+		for _, id := range ids {
+			devs = append(devs, &pluginapi.Device{ID: id, Health: pluginapi.Healthy})
+		}
+		m := &NvidiaDevicePlugin{
+			devs:          devs,
+			devNameMap:    map[string]uint{"GPU-a": 0},
+			unhealthyDevs: map[string]bool{},
+			unit:          GiBPrefix,
+			store:         annotationStore{},
+			allocations:   map[string]*allocation{},
+			podCache:      test.podCache,
+		}
+		m.recordAllocation(ids, &allocation{name: "p1", uid: "p1", namespace: "default", devIndex: 0, podReqGPU: 4})
+
+		lock.Lock()
+		requests, status = 0, test.status
+		lock.Unlock()
+		skipped := unverifiedAllocations.Value()
+		_, err := m.PreStartContainer(context.Background(), &pluginapi.PreStartContainerRequest{DevicesIDs: ids})
+		if test.expectedErr && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if !test.expectedErr && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		lock.Lock()
+		if requests != test.expectedRequests {
+			t.Errorf("%s: expected %d requests to kube-apiserver, got %d", test.name, test.expectedRequests, requests)
+		}
+		lock.Unlock()
+		if n := unverifiedAllocations.Value() - skipped; n != test.expectedSkipped {
+			t.Errorf("%s: expected %d unverified allocations, got %d", test.name, test.expectedSkipped, n)
+		}
+	}
+}
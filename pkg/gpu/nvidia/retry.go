@@ -0,0 +1,81 @@
+package nvidia
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/golang/glog"
+	"golang.org/x/net/context"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// patchBackoff is the backoff of the pod and node patches, it waits about 3s in total
+var patchBackoff = wait.Backoff{
+	Duration: 100 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    5,
+}
+
+// isRetriable returns true if the error of the request is transient
+func isRetriable(err error) bool {
+	if apierrors.IsConflict(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		utilnet.IsConnectionReset(err) {
+		return true
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+
+	return false
+}
+
+// retryOnTransientError calls fn until it succeeds, fails with a permanent error or the backoff
+// is exhausted. It never sleeps beyond the deadline of the context, the delay suggested by
+// the server is honoured. fn should get the latest object again to resolve the conflict.
+func retryOnTransientError(ctx context.Context, backoff wait.Backoff, fn func() error) error {
+	delay := backoff.Duration
+	var err error
+	for i := 0; i < backoff.Steps; i++ {
+		if i > 0 {
+			sleep := delay
+			if backoff.Jitter > 0 {
+				sleep = wait.Jitter(delay, backoff.Jitter)
+			}
+			if seconds, ok := apierrors.SuggestsClientDelay(err); ok && time.Duration(seconds)*time.Second > sleep {
+				sleep = time.Duration(seconds) * time.Second
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(sleep).After(deadline) {
+				return fmt.Errorf("no time to retry before the deadline: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%v: %v", ctx.Err(), err)
+			case <-time.After(sleep):
+			}
+			delay = time.Duration(float64(delay) * backoff.Factor)
+		}
+
+		err = fn()
+		if err == nil || !isRetriable(err) {
+			return err
+		}
+		log.Warningf("Attempt %d/%d failed due to %v", i+1, backoff.Steps, err)
+	}
+
+	return err
+}
+
+// retryPatch retries patching the pod or node within the client timeout
+func retryPatch(fn func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), clientTimeout)
+	defer cancel()
+	return retryOnTransientError(ctx, patchBackoff, fn)
+}
@@ -0,0 +1,154 @@
+package nvidia
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	testBackoff  = wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 4}
+	podsResource = schema.GroupResource{Resource: "pods"}
+)
+
+// fakePatch returns the errors in order, and then succeeds
+type fakePatch struct {
+	errs  []error
+	calls int
+}
+
+func (f *fakePatch) patch() error {
+	f.calls++
+	if f.calls <= len(f.errs) {
+		return f.errs[f.calls-1]
+	}
+	return nil
+}
+
+func TestRetryOnTransientError(t *testing.T) {
+	tests := []struct {
+		name          string
+		errs          []error
+		expectedCalls int
+		expectedErr   bool
+	}{
+		{
+			name:          "succeeds",
+			expectedCalls: 1,
+		},
+		{
+			name: "transient errors",
+			errs: []error{
+				apierrors.NewConflict(podsResource, "p", fmt.Errorf("the object has been modified")),
+				apierrors.NewServerTimeout(podsResource, "patch", 0),
+				apierrors.NewTooManyRequestsError("throttled"),
+			},
+			expectedCalls: 4,
+		},
+		{
+			name:          "permanent error",
+			errs:          []error{apierrors.NewNotFound(podsResource, "p")},
+			expectedCalls: 1,
+			expectedErr:   true,
+		},
+		{
+			name: "backoff exhausted",
+			errs: []error{
+				apierrors.NewTooManyRequestsError("throttled"),
+				apierrors.NewTooManyRequestsError("throttled"),
+				apierrors.NewTooManyRequestsError("throttled"),
+				apierrors.NewTooManyRequestsError("throttled"),
+			},
+			expectedCalls: 4,
+			expectedErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		f := &fakePatch{errs: test.errs}
+		err := retryOnTransientError(context.Background(), testBackoff, f.patch)
+		if (err != nil) != test.expectedErr {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if f.calls != test.expectedCalls {
+			t.Errorf("%s: expected %d calls, got %d", test.name, test.expectedCalls, f.calls)
+		}
+	}
+}
+
+func TestRetryOnTransientErrorHonoursDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// the server asks to retry after 1s, which is beyond the deadline
+	f := &fakePatch{errs: []error{apierrors.NewServerTimeout(podsResource, "patch", 1)}}
+	start := time.Now()
+	if err := retryOnTransientError(ctx, testBackoff, f.patch); err == nil {
+		t.Errorf("expected error when there is no time to retry")
+	}
+	if f.calls != 1 {
+		t.Errorf("expected 1 call, got %d", f.calls)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("expected to give up before the deadline, took %v", elapsed)
+	}
+}
+
+// TestPatchNodeAnnotationsRetry runs the patch against a fake kube-apiserver which rejects the first patches
+func TestPatchNodeAnnotationsRetry(t *testing.T) {
+	node := &v1.Node{
+		TypeMeta:   metav1.TypeMeta{Kind: "Node", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+	}
+	rejections := []*apierrors.StatusError{
+		apierrors.NewConflict(schema.GroupResource{Resource: "nodes"}, "node1", fmt.Errorf("the object has been modified")),
+		apierrors.NewTooManyRequestsError("throttled"),
+	}
+	gets, patches := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			gets++
+		case http.MethodPatch:
+			patches++
+			if patches <= len(rejections) {
+				status := rejections[patches-1].ErrStatus
+				status.Kind, status.APIVersion = "Status", "v1"
+				w.WriteHeader(int(status.Code))
+				json.NewEncoder(w).Encode(status)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(node)
+	}))
+	defer server.Close()
+
+	oldClientset, oldNodeName, oldBackoff := clientset, nodeName, patchBackoff
+	defer func() { clientset, nodeName, patchBackoff = oldClientset, oldNodeName, oldBackoff }()
+	clientset = kubernetes.NewForConfigOrDie(&rest.Config{Host: server.URL})
+	nodeName = "node1"
+	patchBackoff = testBackoff
+
+	if err := patchNodeAnnotations(map[string]string{NodeAnnotationMemoryUnit: "GiB"}); err != nil {
+		t.Fatalf("expected the patch to succeed after retries, got %v", err)
+	}
+	if patches != 3 {
+		t.Errorf("expected 3 patches, got %d", patches)
+	}
+	if gets != 3 {
+		t.Errorf("expected the node to be got again before each patch, got %d gets", gets)
+	}
+}
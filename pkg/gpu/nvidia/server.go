@@ -1,6 +1,7 @@
 package nvidia
 
 import (
+	"fmt"
 	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/kubelet/client"
 	"net"
 	"os"
@@ -12,31 +13,58 @@ import (
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/types"
 	pluginapi "k8s.io/kubernetes/pkg/kubelet/apis/deviceplugin/v1beta1"
 )
 
 // NvidiaDevicePlugin implements the Kubernetes device plugin API
 type NvidiaDevicePlugin struct {
-	devs                 []*pluginapi.Device
-	realDevNames         []string
-	devNameMap           map[string]uint
-	devIndxMap           map[uint]string
-	socket               string
-	mps                  bool
-	healthCheck          bool
-	disableCGPUIsolation bool
-	stop                 chan struct{}
-	health               chan *pluginapi.Device
-	queryKubelet         bool
-	kubeletClient        *client.KubeletClient
+	devs          []*pluginapi.Device
+	realDevNames  []string
+	devNameMap    map[string]uint
+	devIndxMap    map[uint]string
+	unhealthyDevs map[string]bool
+	// unit is the memory unit of gpu-mem, a new unit restarts the device plugin
+	unit          MemoryUnit
+	socket        string
+	mps           bool
+	cdi           bool
+	deviceSpecs   []*pluginapi.DeviceSpec
+	driverMounts  []*pluginapi.Mount
+	settings      nodeSettings
+	allocations   map[string]*allocation
+	claims        map[types.UID]*podClaim
+	assumedPodTTL time.Duration
+	store         assignmentStore
+	// storeStopped is closed when the store is flushed after stop
+	storeStopped chan struct{}
+	// usageInterval is the interval of publishing the GPU memory used by the pods in procRoot
+	usageInterval time.Duration
+	procRoot      string
+	enforcer      *enforcer
+	// gpuShareNode reports the GPUShareNode of the node on nodeStatusUpdated
+	gpuShareNode      bool
+	nodeStatusUpdated chan struct{}
+	stop              chan struct{}
+	health            chan *pluginapi.Device
+	devsUpdated       chan struct{}
+	healthStop        chan struct{}
+	queryKubelet      bool
+	kubeletClient     *client.KubeletClient
+	// podCache is the pods on the node for PreStartContainer, it's nil before Start
+	podCache *nodePodCache
 
 	server *grpc.Server
 	sync.RWMutex
 }
 
 // NewNvidiaDevicePlugin returns an initialized NvidiaDevicePlugin
-func NewNvidiaDevicePlugin(mps, healthCheck, queryKubelet bool, client *client.KubeletClient) (*NvidiaDevicePlugin, error) {
-	devs, devNameMap := getDevices()
+func NewNvidiaDevicePlugin(config Config, settings nodeSettings, client *client.KubeletClient) (*NvidiaDevicePlugin, error) {
+	unit := config.memoryUnit()
+	devs, devNameMap, err := getDevices(unit, settings.reservation)
+	if err != nil {
+		return nil, err
+	}
 	devList := []string{}
 
 	for dev, _ := range devNameMap {
@@ -46,26 +74,71 @@ func NewNvidiaDevicePlugin(mps, healthCheck, queryKubelet bool, client *client.K
 	log.Infof("Device Map: %v", devNameMap)
 	log.Infof("Device List: %v", devList)
 
-	err := patchGPUCount(len(devList))
+	err = patchGPUCount(len(devList))
+	if err != nil {
+		return nil, err
+	}
+	err = patchNodeAnnotations(map[string]string{
+		NodeAnnotationMemoryUnit:     string(unit),
+		NodeAnnotationReservedMemory: fmt.Sprintf("%d", reservedGPUMemory),
+	})
 	if err != nil {
 		return nil, err
 	}
-	disableCGPUIsolation, err := disableCGPUIsolationOrNot()
+	if err = syncGPULabels(config.MPS); err != nil {
+		log.Warningf("Failed to label node %s due to %v", nodeName, err)
+	}
+	if settings.disableCGPUIsolation {
+		log.Infof("enable gpusharing mode and disable cgpu mode")
+	}
+	if config.CDI {
+		if err = writeNodeCDISpec(config); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		deviceSpecs  []*pluginapi.DeviceSpec
+		driverMounts []*pluginapi.Mount
+	)
+	if config.DeviceSpecs {
+		deviceSpecs = discoverControlDeviceSpecs(config.Driver.HostRoot)
+		driverMounts, err = discoverDriverMounts(config.Driver)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Found control devices %v and driver files %v", deviceSpecs, driverMounts)
+	}
+	store, err := newAssignmentStore(config.AssignmentStore, config.AssignmentFile)
 	if err != nil {
 		return nil, err
 	}
 	return &NvidiaDevicePlugin{
-		devs:                 devs,
-		realDevNames:         devList,
-		devNameMap:           devNameMap,
-		socket:               serverSock,
-		mps:                  mps,
-		healthCheck:          healthCheck,
-		disableCGPUIsolation: disableCGPUIsolation,
-		stop:                 make(chan struct{}),
-		health:               make(chan *pluginapi.Device),
-		queryKubelet:         queryKubelet,
-		kubeletClient:        client,
+		devs:              devs,
+		realDevNames:      devList,
+		devNameMap:        devNameMap,
+		unhealthyDevs:     map[string]bool{},
+		unit:              unit,
+		socket:            serverSock,
+		mps:               config.MPS,
+		cdi:               config.CDI,
+		deviceSpecs:       deviceSpecs,
+		driverMounts:      driverMounts,
+		settings:          settings,
+		allocations:       map[string]*allocation{},
+		claims:            map[types.UID]*podClaim{},
+		assumedPodTTL:     time.Duration(config.AssumedPodTTL) * time.Second,
+		store:             store,
+		storeStopped:      make(chan struct{}),
+		gpuShareNode:      config.GPUShareNode,
+		usageInterval:     time.Duration(config.MemoryUsageInterval) * time.Second,
+		procRoot:          config.ProcRoot,
+		enforcer:          newEnforcer(config.Enforcer, config.ProcRoot),
+		nodeStatusUpdated: make(chan struct{}, 1),
+		stop:              make(chan struct{}),
+		health:            make(chan *pluginapi.Device),
+		devsUpdated:       make(chan struct{}, 1),
+		queryKubelet:      config.QueryKubelet,
+		kubeletClient:     client,
 	}, nil
 }
 
@@ -83,7 +156,7 @@ func (m *NvidiaDevicePlugin) GetDeviceNameByIndex(index uint) (name string, foun
 }
 
 func (m *NvidiaDevicePlugin) GetDevicePluginOptions(context.Context, *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
-	return &pluginapi.DevicePluginOptions{}, nil
+	return &pluginapi.DevicePluginOptions{PreStartRequired: true}, nil
 }
 
 // dial establishes the gRPC communication with the registered device plugin.
@@ -114,6 +187,7 @@ func (m *NvidiaDevicePlugin) Start() error {
 		return err
 	}
 
+	m.podCache = watchNodePods(m.stop)
 	m.server = grpc.NewServer([]grpc.ServerOption{}...)
 	pluginapi.RegisterDevicePluginServer(m.server, m)
 
@@ -126,7 +200,20 @@ func (m *NvidiaDevicePlugin) Start() error {
 	}
 	conn.Close()
 
-	go m.healthcheck()
+	m.healthStop = make(chan struct{})
+	go m.healthcheck(m.settings.healthCheck, m.healthStop)
+	go m.sweep(m.assumedPodTTL, m.stop)
+	go func() {
+		m.store.Run(m.stop)
+		close(m.storeStopped)
+	}()
+	if m.gpuShareNode {
+		go m.reportNodeStatus(m.stop)
+	}
+	go m.reportMemoryUsage(m.usageInterval, m.procRoot, m.stop)
+	if m.enforcer != nil {
+		go m.enforcer.run(m.memoryUnit, m.stop)
+	}
 
 	lastAllocateTime = time.Now()
 
@@ -142,6 +229,8 @@ func (m *NvidiaDevicePlugin) Stop() error {
 	m.server.Stop()
 	m.server = nil
 	close(m.stop)
+	// the records are saved before the store of the next device plugin loads them
+	<-m.storeStopped
 
 	return m.cleanup()
 }
@@ -159,6 +248,7 @@ func (m *NvidiaDevicePlugin) Register(kubeletEndpoint, resourceName string) erro
 		Version:      pluginapi.Version,
 		Endpoint:     path.Base(m.socket),
 		ResourceName: resourceName,
+		Options:      &pluginapi.DevicePluginOptions{PreStartRequired: true},
 	}
 
 	_, err = client.Register(context.Background(), reqt)
@@ -170,7 +260,7 @@ func (m *NvidiaDevicePlugin) Register(kubeletEndpoint, resourceName string) erro
 
 // ListAndWatch lists devices and update that list according to the health status
 func (m *NvidiaDevicePlugin) ListAndWatch(e *pluginapi.Empty, s pluginapi.DevicePlugin_ListAndWatchServer) error {
-	s.Send(&pluginapi.ListAndWatchResponse{Devices: m.devs})
+	s.Send(&pluginapi.ListAndWatchResponse{Devices: m.listDevices()})
 
 	for {
 		select {
@@ -178,18 +268,64 @@ func (m *NvidiaDevicePlugin) ListAndWatch(e *pluginapi.Empty, s pluginapi.Device
 			return nil
 		case d := <-m.health:
 			// FIXME: there is no way to recover from the Unhealthy state.
+			m.markUnhealthy(extractRealDeviceID(d.ID))
+			s.Send(&pluginapi.ListAndWatchResponse{Devices: m.listDevices()})
+		case <-m.devsUpdated:
+			s.Send(&pluginapi.ListAndWatchResponse{Devices: m.listDevices()})
+		}
+	}
+}
+
+// listDevices returns a copy of the devices, so they're sent without the lock while markUnhealthy
+// changes their health
+func (m *NvidiaDevicePlugin) listDevices() []*pluginapi.Device {
+	m.RLock()
+	defer m.RUnlock()
+	devs := make([]*pluginapi.Device, 0, len(m.devs))
+	for _, d := range m.devs {
+		dev := *d
+		devs = append(devs, &dev)
+	}
+	return devs
+}
+
+// markUnhealthy marks all the fake devices of the real device unhealthy
+func (m *NvidiaDevicePlugin) markUnhealthy(realID string) {
+	m.Lock()
+	defer m.Unlock()
+	m.unhealthyDevs[realID] = true
+	for _, d := range m.devs {
+		if extractRealDeviceID(d.ID) == realID {
 			d.Health = pluginapi.Unhealthy
-			s.Send(&pluginapi.ListAndWatchResponse{Devices: m.devs})
 		}
 	}
+	m.notifyNodeStatus()
 }
 
-func (m *NvidiaDevicePlugin) unhealthy(dev *pluginapi.Device) {
-	m.health <- dev
+// updateDevices regenerates the fake devices with the new reservation, and notifies kubelet.
+// The devices are kept if the GPUs can't be listed. It's called with the lock held.
+func (m *NvidiaDevicePlugin) updateDevices(reservation MemoryReservation) error {
+	devs, _, err := getDevices(m.unit, reservation)
+	if err != nil {
+		return err
+	}
+	for _, d := range devs {
+		if m.unhealthyDevs[extractRealDeviceID(d.ID)] {
+			d.Health = pluginapi.Unhealthy
+		}
+	}
+	m.devs = devs
+	m.notifyNodeStatus()
+
+	select {
+	case m.devsUpdated <- struct{}{}:
+	default:
+	}
+	return nil
 }
 
-func (m *NvidiaDevicePlugin) PreStartContainer(context.Context, *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
-	return &pluginapi.PreStartContainerResponse{}, nil
+func (m *NvidiaDevicePlugin) unhealthy(dev *pluginapi.Device) {
+	m.health <- dev
 }
 
 func (m *NvidiaDevicePlugin) cleanup() error {
@@ -200,13 +336,13 @@ func (m *NvidiaDevicePlugin) cleanup() error {
 	return nil
 }
 
-func (m *NvidiaDevicePlugin) healthcheck() {
+func (m *NvidiaDevicePlugin) healthcheck(enabled bool, stop <-chan struct{}) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	var xids chan *pluginapi.Device
-	if m.healthCheck {
+	if enabled {
 		xids = make(chan *pluginapi.Device)
-		go watchXIDs(ctx, m.devs, xids)
+		go watchXIDs(ctx, m.listDevices(), xids)
 	}
 
 	for {
@@ -214,12 +350,25 @@ func (m *NvidiaDevicePlugin) healthcheck() {
 		case <-m.stop:
 			cancel()
 			return
+		case <-stop:
+			cancel()
+			return
 		case dev := <-xids:
 			m.unhealthy(dev)
 		}
 	}
 }
 
+// restartHealthCheck restarts the health check with the new policy. It's called with the lock held.
+func (m *NvidiaDevicePlugin) restartHealthCheck(enabled bool) {
+	if m.healthStop == nil {
+		return
+	}
+	close(m.healthStop)
+	m.healthStop = make(chan struct{})
+	go m.healthcheck(enabled, m.healthStop)
+}
+
 // Serve starts the gRPC server and register the device plugin to Kubelet
 func (m *NvidiaDevicePlugin) Serve() error {
 	err := m.Start()
@@ -0,0 +1,126 @@
+package nvidia
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	log "github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// DefaultProcRoot is where the proc filesystem of the host is, the device plugin runs with hostPID
+const DefaultProcRoot = "/proc"
+
+// containerCgroupPattern matches the pod UID and the container ID in the cgroup path of the containers, e.g.
+// /kubepods/burstable/pod<uid>/<id> with the cgroupfs driver, or kubepods-burstable-pod<uid>.slice/docker-<id>.scope
+// with the systemd driver which replaces - with _ in the pod UID
+var containerCgroupPattern = regexp.MustCompile(`kubepods.*pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})` +
+	`(?:\.slice)?(?:/(?:[a-z]+(?:-[a-z]+)*-)?([0-9a-f]{12,64}))?`)
+
+// containerOfProcess returns the UID of the pod and the ID of the container of the process from its cgroups
+// in the proc root, they're empty if the process is not in a pod
+func containerOfProcess(procRoot string, pid uint) (types.UID, string, error) {
+	f, err := os.Open(filepath.Join(procRoot, fmt.Sprintf("%d", pid), "cgroup"))
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if match := containerCgroupPattern.FindStringSubmatch(scanner.Text()); match != nil {
+			return types.UID(strings.Replace(match[1], "_", "-", -1)), match[2], nil
+		}
+	}
+	return "", "", scanner.Err()
+}
+
+// podUIDOfProcess returns the UID of the pod of the process, it's empty if the process is not in a pod
+func podUIDOfProcess(procRoot string, pid uint) (types.UID, error) {
+	uid, _, err := containerOfProcess(procRoot, pid)
+	return uid, err
+}
+
+// buildMemoryUsage maps the processes on the GPUs to their pods, the memory is converted from MiB into the memory unit
+func buildMemoryUsage(usages []*gpuUsage, procRoot string, unit MemoryUnit) *gpushare.MemoryUsage {
+	inUnit := func(mib uint64) float64 {
+		// keep 2 decimals, so the annotation doesn't change with every MiB
+		return math.Round(float64(mib)/float64(unit.MiB())*100) / 100
+	}
+
+	memoryUsage := &gpushare.MemoryUsage{
+		MemoryUnit: string(unit),
+		GPUs:       []gpushare.GPUMemoryUsage{},
+	}
+	for _, usage := range usages {
+		gpu := gpushare.GPUMemoryUsage{
+			Index: int(usage.Index),
+			Used:  inUnit(usage.Used),
+		}
+		pods := map[types.UID]uint64{}
+		for _, p := range usage.Processes {
+			uid, err := podUIDOfProcess(procRoot, p.PID)
+			if err != nil {
+				// the process may have exited
+				log.V(4).Infof("Failed to get the pod of process %d due to %v", p.PID, err)
+				continue
+			}
+			if uid != "" {
+				pods[uid] += p.Memory
+			}
+		}
+		if len(pods) > 0 {
+			gpu.Pods = map[types.UID]float64{}
+			for uid, used := range pods {
+				gpu.Pods[uid] = inUnit(used)
+			}
+		}
+		memoryUsage.GPUs = append(memoryUsage.GPUs, gpu)
+	}
+	sort.Slice(memoryUsage.GPUs, func(i, j int) bool {
+		return memoryUsage.GPUs[i].Index < memoryUsage.GPUs[j].Index
+	})
+
+	return memoryUsage
+}
+
+// reportMemoryUsage publishes the GPU memory used by the pods in the node annotation periodically,
+// the node isn't patched if the usage isn't changed since every patch is sent to the node watchers
+func (m *NvidiaDevicePlugin) reportMemoryUsage(interval time.Duration, procRoot string, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+
+	published := ""
+	wait.Until(func() {
+		usages, err := backend.Usage()
+		if err != nil {
+			log.Warningf("Failed to get the GPU memory usage due to %v", err)
+			return
+		}
+		data, err := json.Marshal(buildMemoryUsage(usages, procRoot, m.memoryUnit()))
+		if err != nil {
+			log.Warningf("Failed to encode the GPU memory usage due to %v", err)
+			return
+		}
+		if string(data) == published {
+			return
+		}
+		// it's retried in the next interval
+		if err = patchNodeAnnotationsOnce(map[string]string{NodeAnnotationMemoryUsage: string(data)}); err != nil {
+			log.Warningf("Failed to publish the GPU memory usage of node %s due to %v", nodeName, err)
+			return
+		}
+		published = string(data)
+	}, interval, stop)
+}
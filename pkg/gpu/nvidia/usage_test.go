@@ -0,0 +1,76 @@
+package nvidia
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-device-plugin/pkg/apis/gpushare"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const testProcRoot = "testdata/proc"
+
+func TestPodUIDOfProcess(t *testing.T) {
+	tests := []struct {
+		pid         uint
+		expectedUID types.UID
+	}{
+		// cgroupfs driver
+		{pid: 1001, expectedUID: "6a2b1c3d-1111-4e5f-8a9b-0123456789ab"},
+		// systemd driver
+		{pid: 1002, expectedUID: "7b3c2d4e-2222-4f60-9bac-123456789abc"},
+		// cgroup v2 of a guaranteed pod
+		{pid: 1003, expectedUID: "8c4d3e5f-3333-4071-acbd-23456789abcd"},
+		// a process on the host
+		{pid: 1004, expectedUID: ""},
+	}
+
+	for _, test := range tests {
+		uid, err := podUIDOfProcess(testProcRoot, test.pid)
+		if err != nil {
+			t.Errorf("process %d: unexpected error %v", test.pid, err)
+		}
+		if uid != test.expectedUID {
+			t.Errorf("process %d: expected pod %q, got %q", test.pid, test.expectedUID, uid)
+		}
+	}
+
+	if _, err := podUIDOfProcess(testProcRoot, 9999); err == nil {
+		t.Errorf("expected an error for the exited process")
+	}
+}
+
+func TestBuildMemoryUsage(t *testing.T) {
+	usages := []*gpuUsage{
+		{Index: 1, Used: 2048, Processes: []gpuProcess{{PID: 1003, Memory: 1024}}},
+		{Index: 0, Used: 4096, Processes: []gpuProcess{
+			{PID: 1001, Memory: 1024},
+			{PID: 1005, Memory: 512},
+			{PID: 1002, Memory: 256},
+			{PID: 1004, Memory: 1024},
+			// exited
+			{PID: 9999, Memory: 1024},
+		}},
+	}
+
+	expected := &gpushare.MemoryUsage{
+		MemoryUnit: "GiB",
+		GPUs: []gpushare.GPUMemoryUsage{
+			{Index: 0, Used: 4, Pods: map[types.UID]float64{
+				"6a2b1c3d-1111-4e5f-8a9b-0123456789ab": 1.5,
+				"7b3c2d4e-2222-4f60-9bac-123456789abc": 0.25,
+			}},
+			{Index: 1, Used: 2, Pods: map[types.UID]float64{
+				"8c4d3e5f-3333-4071-acbd-23456789abcd": 1,
+			}},
+		},
+	}
+	if usage := buildMemoryUsage(usages, testProcRoot, GiBPrefix); !reflect.DeepEqual(usage, expected) {
+		t.Errorf("expected %+v, got %+v", expected, usage)
+	}
+
+	usage := buildMemoryUsage([]*gpuUsage{{Index: 0, Used: 300}}, testProcRoot, GiBPrefix)
+	if usage.GPUs[0].Used != 0.29 || usage.GPUs[0].Pods != nil {
+		t.Errorf("expected 0.29 GiB used without any pod, got %+v", usage.GPUs[0])
+	}
+}
@@ -1,17 +1,34 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
 	v1 "k8s.io/api/core/v1"
 	utilnet "k8s.io/apimachinery/pkg/util/net"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/transport"
-	"net/http"
-	"time"
 )
 
+// The paths served by kubelet
+const (
+	podsPath         = "/pods"
+	statsSummaryPath = "/stats/summary"
+	healthzPath      = "/healthz"
+	configzPath      = "/configz"
+)
+
+// maxErrorMessage is the length of the response body kept in the errors
+const maxErrorMessage = 1024
+
 // KubeletClientConfig defines config parameters for the kubelet client
 type KubeletClientConfig struct {
 	// Address specifies the kubelet address
@@ -20,16 +37,23 @@ type KubeletClientConfig struct {
 	// Port specifies the default port - used if no information about Kubelet port can be found in Node.NodeStatus.DaemonEndpoints.
 	Port uint
 
-	// TLSClientConfig contains settings to enable transport layer security
+	// TLSClientConfig contains settings to enable transport layer security. The certificate of kubelet
+	// is verified by CAFile or CAData, or by the system roots if neither is set, and its name is verified
+	// against ServerName or Address. Insecure skips the verification, it has to be set explicitly.
 	restclient.TLSClientConfig
 
 	// Server requires Bearer authentication
 	BearerToken string
 
+	// BearerTokenFile is the file of the bearer token, it's read again when it's changed or the token is
+	// rejected, so the rotated tokens such as the projected service account token keep working
+	BearerTokenFile string
+
 	// HTTPTimeout is used by the client to timeout http requests to Kubelet.
 	HTTPTimeout time.Duration
 }
 
+// KubeletClient calls the read-only APIs of kubelet, the errors are AuthError, StatusError or TransportError
 type KubeletClient struct {
 	defaultPort uint
 	host        string
@@ -37,7 +61,13 @@ type KubeletClient struct {
 }
 
 func NewKubeletClient(config *KubeletClientConfig) (*KubeletClient, error) {
-	trans, err := makeTransport(config, true)
+	if config.Insecure && (len(config.CAData) > 0 || config.CAFile != "") {
+		return nil, fmt.Errorf("the CA of kubelet can't be set with the insecure mode")
+	}
+	if config.BearerToken != "" && config.BearerTokenFile != "" {
+		return nil, fmt.Errorf("the bearer token and the bearer token file can't be both set")
+	}
+	trans, err := makeTransport(config)
 	if err != nil {
 		return nil, err
 	}
@@ -56,33 +86,23 @@ func NewKubeletClient(config *KubeletClientConfig) (*KubeletClient, error) {
 func (c *KubeletClientConfig) transportConfig() *transport.Config {
 	cfg := &transport.Config{
 		TLS: transport.TLSConfig{
-			CAFile:   c.CAFile,
-			CAData:   c.CAData,
-			CertFile: c.CertFile,
-			CertData: c.CertData,
-			KeyFile:  c.KeyFile,
-			KeyData:  c.KeyData,
+			CAFile:     c.CAFile,
+			CAData:     c.CAData,
+			CertFile:   c.CertFile,
+			CertData:   c.CertData,
+			KeyFile:    c.KeyFile,
+			KeyData:    c.KeyData,
+			Insecure:   c.Insecure,
+			ServerName: c.ServerName,
 		},
 		BearerToken: c.BearerToken,
 	}
-	if !cfg.HasCA() {
-		cfg.TLS.Insecure = true
-	}
 	return cfg
 }
 
 // makeTransport creates a RoundTripper for HTTP Transport.
-func makeTransport(config *KubeletClientConfig, insecureSkipTLSVerify bool) (http.RoundTripper, error) {
-	// do the insecureSkipTLSVerify on the pre-transport *before* we go get a potentially cached connection.
-	// transportConfig always produces a new struct pointer.
-	preTLSConfig := config.transportConfig()
-	if insecureSkipTLSVerify && preTLSConfig != nil {
-		preTLSConfig.TLS.Insecure = true
-		preTLSConfig.TLS.CAData = nil
-		preTLSConfig.TLS.CAFile = ""
-	}
-
-	tlsConfig, err := transport.TLSConfigFor(preTLSConfig)
+func makeTransport(config *KubeletClientConfig) (http.RoundTripper, error) {
+	tlsConfig, err := transport.TLSConfigFor(config.transportConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -95,40 +115,113 @@ func makeTransport(config *KubeletClientConfig, insecureSkipTLSVerify bool) (htt
 		})
 	}
 
+	if config.BearerTokenFile != "" {
+		if rt, err = newTokenFileRoundTripper(config.BearerTokenFile, rt); err != nil {
+			return nil, err
+		}
+	}
 	return transport.HTTPWrappersForConfig(config.transportConfig(), rt)
 }
 
-func ReadAll(r io.Reader) ([]byte, error) {
-	b := make([]byte, 0, 512)
-	for {
-		if len(b) == cap(b) {
-			// Add more capacity (let append pick how much).
-			b = append(b, 0)[:len(b)]
-		}
-		n, err := r.Read(b[len(b):cap(b)])
-		b = b[:len(b)+n]
-		if err != nil {
-			if err == io.EOF {
-				err = nil
-			}
-			return b, err
-		}
+// url returns the URL of the path on kubelet
+func (k *KubeletClient) url(path string) string {
+	u := url.URL{
+		Scheme: "https",
+		Host:   net.JoinHostPort(k.host, fmt.Sprintf("%d", k.defaultPort)),
+		Path:   path,
 	}
+	return u.String()
 }
 
-func (k *KubeletClient) GetNodeRunningPods() (*v1.PodList, error) {
-	resp, err := k.client.Get(fmt.Sprintf("https://%v:%d/pods/", k.host, k.defaultPort))
+// get calls the path on kubelet and returns the body of the 200 response, the body is closed
+func (k *KubeletClient) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, k.url(path), nil)
 	if err != nil {
 		return nil, err
 	}
+	resp, err := k.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, &TransportError{Path: path, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// drain the body, so the connection is reused
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorMessage))
+		io.Copy(ioutil.Discard, resp.Body)
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return nil, &AuthError{Path: path, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+		default:
+			return nil, &StatusError{Path: path, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+		}
+	}
 
-	body, err := ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
+		return nil, &TransportError{Path: path, Err: err}
+	}
+	return body, nil
+}
+
+// getJSON calls the path on kubelet and decodes the response into the object
+func (k *KubeletClient) getJSON(ctx context.Context, path string, obj interface{}) error {
+	body, err := k.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(body, obj); err != nil {
+		return fmt.Errorf("kubelet %s: failed to decode the response: %v", path, err)
+	}
+	return nil
+}
+
+// GetPods returns the pods on the node known by kubelet, including the ones not in kube-apiserver yet
+func (k *KubeletClient) GetPods(ctx context.Context) (*v1.PodList, error) {
+	pods := &v1.PodList{}
+	if err := k.getJSON(ctx, podsPath, pods); err != nil {
 		return nil, err
 	}
-	podLists := &v1.PodList{}
-	if err = json.Unmarshal(body, &podLists); err != nil {
+	return pods, nil
+}
+
+// GetNodeRunningPods returns the pods on the node, it's GetPods without a context
+func (k *KubeletClient) GetNodeRunningPods() (*v1.PodList, error) {
+	return k.GetPods(context.Background())
+}
+
+// GetStatsSummary returns the stats summary of the node and its pods
+func (k *KubeletClient) GetStatsSummary(ctx context.Context) (*Summary, error) {
+	summary := &Summary{}
+	if err := k.getJSON(ctx, statsSummaryPath, summary); err != nil {
 		return nil, err
 	}
-	return podLists, err
+	return summary, nil
+}
+
+// Healthz returns nil if kubelet is healthy
+func (k *KubeletClient) Healthz(ctx context.Context) error {
+	body, err := k.get(ctx, healthzPath)
+	if err != nil {
+		return err
+	}
+	if status := strings.TrimSpace(string(body)); status != "ok" {
+		return &StatusError{Path: healthzPath, StatusCode: http.StatusOK, Message: status}
+	}
+	return nil
+}
+
+// GetConfigz returns the running config of kubelet, i.e. the KubeletConfiguration of kubelet.config.k8s.io
+// decoded into a map since the type is not vendored
+func (k *KubeletClient) GetConfigz(ctx context.Context) (map[string]interface{}, error) {
+	configz := struct {
+		KubeletConfig map[string]interface{} `json:"kubeletconfig"`
+	}{}
+	if err := k.getJSON(ctx, configzPath, &configz); err != nil {
+		return nil, err
+	}
+	if configz.KubeletConfig == nil {
+		return nil, fmt.Errorf("kubelet %s: no kubeletconfig in the response", configzPath)
+	}
+	return configz.KubeletConfig, nil
 }
@@ -1,57 +1,284 @@
 package client
 
 import (
-	"flag"
+	"context"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"k8s.io/client-go/rest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
-)
 
-var (
-	clientCert string
-	clientKey  string
-	token      string
-	timeout    int
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	restclient "k8s.io/client-go/rest"
 )
 
-func TestNewKubeletClient(t *testing.T) {
-	flag.StringVar(&clientCert, "client-cert", "", "")
-	flag.StringVar(&clientKey, "client-key", "", "")
-	flag.StringVar(&token, "token", "", "")
-	flag.IntVar(&timeout, "timeout", 10, "")
+const testToken = "token"
 
-	flag.Parse()
+// fakeKubelet serves the read-only APIs of kubelet to the requests with testToken
+type fakeKubelet struct {
+	sync.Mutex
+	// status overrides the status of the responses if it's set
+	status int
+	// bodies records the bodies of the responses, so the test can tell if they're closed
+	bodies []*trackedBody
+}
 
-	if clientCert == "" && clientKey == "" && token == "" {
-		tokenByte, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
-		if err != nil {
-			panic(fmt.Errorf("in cluster mode, find token failed, error: %v", err))
-		}
-		token = string(tokenByte)
+func (k *fakeKubelet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.Lock()
+	status := k.status
+	k.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
+	if status != 0 {
+		http.Error(w, fmt.Sprintf("%s %s", http.StatusText(status), r.URL.Path), status)
+		return
+	}
+
+	switch r.URL.Path {
+	case podsPath:
+		json.NewEncoder(w).Encode(&v1.PodList{Items: []v1.Pod{
+			{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default"}},
+		}})
+	case statsSummaryPath:
+		io.WriteString(w, `{"node":{"nodeName":"node1"},"pods":[{"podRef":{"name":"pod1","namespace":"default","uid":"uid1"},
+"containers":[{"name":"c1","accelerators":[{"make":"nvidia","model":"Tesla P100","id":"GPU-1","memoryTotal":17071734784,"memoryUsed":1073741824,"dutyCycle":20}]}]}]}`)
+	case healthzPath:
+		io.WriteString(w, "ok")
+	case configzPath:
+		io.WriteString(w, `{"kubeletconfig":{"kind":"KubeletConfiguration","maxPods":110}}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
 
+// trackedBody records if the response body is closed
+type trackedBody struct {
+	io.ReadCloser
+	mu     sync.Mutex
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	return b.ReadCloser.Close()
+}
+
+// trackingRoundTripper wraps the response bodies into trackedBody
+type trackingRoundTripper struct {
+	rt      http.RoundTripper
+	kubelet *fakeKubelet
+}
+
+func (t *trackingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	body := &trackedBody{ReadCloser: resp.Body}
+	resp.Body = body
+	t.kubelet.Lock()
+	t.kubelet.bodies = append(t.kubelet.bodies, body)
+	t.kubelet.Unlock()
+	return resp, nil
+}
+
+func newFakeKubelet(t *testing.T, token string) (*fakeKubelet, *httptest.Server, *KubeletClient) {
+	kubelet := &fakeKubelet{}
+	server := httptest.NewTLSServer(kubelet)
+	host, port := serverAddress(t, server)
 	c, err := NewKubeletClient(&KubeletClientConfig{
-		Address: "127.0.0.1",
-		Port:    10250,
-		TLSClientConfig: rest.TLSClientConfig{
-			Insecure:   true,
-			ServerName: "kubelet",
-			CertFile:   clientCert,
-			KeyFile:    clientKey,
-		},
-		BearerToken: token,
-		HTTPTimeout: time.Duration(timeout) * time.Second,
+		Address:         host,
+		Port:            port,
+		TLSClientConfig: restclient.TLSClientConfig{Insecure: true},
+		BearerToken:     token,
+		HTTPTimeout:     5 * time.Second,
 	})
 	if err != nil {
-		fmt.Println(err)
-		return
+		server.Close()
+		t.Fatal(err)
 	}
-	podsList, err := c.GetNodeRunningPods()
+	c.client.Transport = &trackingRoundTripper{rt: c.client.Transport, kubelet: kubelet}
+	return kubelet, server, c
+}
+
+func TestKubeletClientAPIs(t *testing.T) {
+	kubelet, server, c := newFakeKubelet(t, testToken)
+	defer server.Close()
+	ctx := context.Background()
+
+	pods, err := c.GetPods(ctx)
 	if err != nil {
-		fmt.Println(err)
-		return
+		t.Fatalf("failed to get the pods: %v", err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Name != "pod1" {
+		t.Errorf("expected pod1, got %v", pods.Items)
+	}
+	if pods, err = c.GetNodeRunningPods(); err != nil || len(pods.Items) != 1 {
+		t.Errorf("expected pod1 without a context, got %v, %v", pods, err)
+	}
+
+	summary, err := c.GetStatsSummary(ctx)
+	if err != nil {
+		t.Fatalf("failed to get the stats summary: %v", err)
+	}
+	if summary.Node.NodeName != "node1" || len(summary.Pods) != 1 {
+		t.Fatalf("unexpected stats summary %+v", summary)
+	}
+	pod := summary.Pods[0]
+	if pod.PodRef.UID != "uid1" || len(pod.Containers) != 1 || len(pod.Containers[0].Accelerators) != 1 {
+		t.Fatalf("unexpected pod stats %+v", pod)
+	}
+	if gpu := pod.Containers[0].Accelerators[0]; gpu.ID != "GPU-1" || gpu.MemoryUsed != 1073741824 || gpu.DutyCycle != 20 {
+		t.Errorf("unexpected accelerator stats %+v", gpu)
+	}
+
+	if err = c.Healthz(ctx); err != nil {
+		t.Errorf("expected kubelet to be healthy, got %v", err)
+	}
+
+	config, err := c.GetConfigz(ctx)
+	if err != nil {
+		t.Fatalf("failed to get the config: %v", err)
+	}
+	if config["kind"] != "KubeletConfiguration" || config["maxPods"] != float64(110) {
+		t.Errorf("unexpected config %v", config)
+	}
+
+	assertBodiesClosed(t, kubelet, 5)
+}
+
+func TestKubeletClientErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		token  string
+		status int
+		check  func(error) bool
+	}{
+		{
+			name:  "unauthorized",
+			token: "invalid",
+			check: func(err error) bool {
+				e, ok := err.(*AuthError)
+				return ok && e.StatusCode == http.StatusUnauthorized && e.Path == podsPath
+			},
+		},
+		{
+			name:   "forbidden",
+			token:  testToken,
+			status: http.StatusForbidden,
+			check: func(err error) bool {
+				e, ok := err.(*AuthError)
+				return ok && e.StatusCode == http.StatusForbidden && strings.Contains(e.Message, "Forbidden")
+			},
+		},
+		{
+			name:   "internal error",
+			token:  testToken,
+			status: http.StatusInternalServerError,
+			check: func(err error) bool {
+				e, ok := err.(*StatusError)
+				return ok && e.StatusCode == http.StatusInternalServerError && !IsAuthError(err) && !IsTransportError(err)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kubelet, server, c := newFakeKubelet(t, tc.token)
+			defer server.Close()
+			kubelet.Lock()
+			kubelet.status = tc.status
+			kubelet.Unlock()
+
+			pods, err := c.GetPods(context.Background())
+			if err == nil {
+				t.Fatalf("expected an error, got the pods %v", pods)
+			}
+			if !tc.check(err) {
+				t.Errorf("unexpected error %T: %v", err, err)
+			}
+			assertBodiesClosed(t, kubelet, 1)
+		})
+	}
+}
+
+func TestKubeletClientTransportError(t *testing.T) {
+	_, server, c := newFakeKubelet(t, testToken)
+	server.Close()
+
+	err := c.Healthz(context.Background())
+	if !IsTransportError(err) {
+		t.Errorf("expected a transport error from the closed kubelet, got %T: %v", err, err)
+	}
+}
+
+func TestKubeletClientContext(t *testing.T) {
+	blocked := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-blocked
+	}))
+	defer server.Close()
+	// unblock the handler before closing the server
+	defer close(blocked)
+	host, port := serverAddress(t, server)
+
+	c, err := NewKubeletClient(&KubeletClientConfig{
+		Address:         host,
+		Port:            port,
+		TLSClientConfig: restclient.TLSClientConfig{Insecure: true},
+		BearerToken:     testToken,
+		HTTPTimeout:     time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.GetStatsSummary(ctx)
+	if !IsTransportError(err) {
+		t.Fatalf("expected a transport error when the context is done, got %T: %v", err, err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the request to be cancelled with the context, took %v", elapsed)
+	}
+}
+
+func TestKubeletClientURL(t *testing.T) {
+	c := &KubeletClient{host: "fd00::1", defaultPort: 10250}
+	if u := c.url(podsPath); u != "https://[fd00::1]:10250/pods" {
+		t.Errorf("unexpected URL %s", u)
+	}
+	c = &KubeletClient{host: "node1", defaultPort: 10250}
+	if u := c.url(statsSummaryPath); u != "https://node1:10250/stats/summary" {
+		t.Errorf("unexpected URL %s", u)
+	}
+}
+
+func assertBodiesClosed(t *testing.T, kubelet *fakeKubelet, expected int) {
+	t.Helper()
+	kubelet.Lock()
+	defer kubelet.Unlock()
+	if len(kubelet.bodies) != expected {
+		t.Fatalf("expected %d responses, got %d", expected, len(kubelet.bodies))
+	}
+	for i, body := range kubelet.bodies {
+		body.mu.Lock()
+		closed := body.closed
+		body.mu.Unlock()
+		if !closed {
+			t.Errorf("expected the body of the response %d to be closed", i)
+		}
 	}
-	fmt.Println(podsList)
 }
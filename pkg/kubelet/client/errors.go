@@ -0,0 +1,51 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// AuthError is returned when kubelet rejects the credential (401) or the permission (403) of the client
+type AuthError struct {
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("kubelet %s: %s: %s", e.Path, http.StatusText(e.StatusCode), e.Message)
+}
+
+// StatusError is returned when kubelet responds with an unexpected status code other than 401 and 403
+type StatusError struct {
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("kubelet %s: unexpected status %d: %s", e.Path, e.StatusCode, e.Message)
+}
+
+// TransportError is returned when kubelet can't be reached, e.g. the connection is refused, the TLS
+// handshake fails, the request times out or the context is done
+type TransportError struct {
+	Path string
+	Err  error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("kubelet %s: %v", e.Path, e.Err)
+}
+
+// IsAuthError tells if kubelet rejects the credential or the permission of the client
+func IsAuthError(err error) bool {
+	_, ok := err.(*AuthError)
+	return ok
+}
+
+// IsTransportError tells if kubelet can't be reached
+func IsTransportError(err error) bool {
+	_, ok := err.(*TransportError)
+	return ok
+}
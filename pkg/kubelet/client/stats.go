@@ -0,0 +1,70 @@
+package client
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The types below are the subset of the stats summary of kubelet (stats/v1alpha1) used by the device
+// plugin and the tools, the stats API is not vendored.
+
+// Summary is the stats summary of the node and its pods served at /stats/summary
+type Summary struct {
+	Node NodeStats  `json:"node"`
+	Pods []PodStats `json:"pods"`
+}
+
+// NodeStats is the stats of the node
+type NodeStats struct {
+	NodeName  string       `json:"nodeName"`
+	StartTime metav1.Time  `json:"startTime"`
+	CPU       *CPUStats    `json:"cpu,omitempty"`
+	Memory    *MemoryStats `json:"memory,omitempty"`
+}
+
+// PodStats is the stats of a pod
+type PodStats struct {
+	PodRef     PodReference     `json:"podRef"`
+	StartTime  metav1.Time      `json:"startTime"`
+	Containers []ContainerStats `json:"containers"`
+}
+
+// PodReference identifies the pod of the stats
+type PodReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	UID       string `json:"uid"`
+}
+
+// ContainerStats is the stats of a container
+type ContainerStats struct {
+	Name         string             `json:"name"`
+	StartTime    metav1.Time        `json:"startTime"`
+	CPU          *CPUStats          `json:"cpu,omitempty"`
+	Memory       *MemoryStats       `json:"memory,omitempty"`
+	Accelerators []AcceleratorStats `json:"accelerators,omitempty"`
+}
+
+// CPUStats is the CPU usage
+type CPUStats struct {
+	Time                 metav1.Time `json:"time"`
+	UsageNanoCores       *uint64     `json:"usageNanoCores,omitempty"`
+	UsageCoreNanoSeconds *uint64     `json:"usageCoreNanoSeconds,omitempty"`
+}
+
+// MemoryStats is the memory usage
+type MemoryStats struct {
+	Time            metav1.Time `json:"time"`
+	UsageBytes      *uint64     `json:"usageBytes,omitempty"`
+	WorkingSetBytes *uint64     `json:"workingSetBytes,omitempty"`
+}
+
+// AcceleratorStats is the usage of a GPU attached to a container, it's reported by kubelet only for
+// the GPUs it knows the container uses, e.g. nvidia.com/gpu
+type AcceleratorStats struct {
+	Make        string `json:"make"`
+	Model       string `json:"model"`
+	ID          string `json:"id"`
+	MemoryTotal uint64 `json:"memoryTotal"`
+	MemoryUsed  uint64 `json:"memoryUsed"`
+	DutyCycle   uint64 `json:"dutyCycle"`
+}
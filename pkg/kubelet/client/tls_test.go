@@ -0,0 +1,179 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	restclient "k8s.io/client-go/rest"
+)
+
+// testCA is a CA which signs the serving certificates of the test kubelets
+type testCA struct {
+	cert    *x509.Certificate
+	key     *rsa.PrivateKey
+	certPEM []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubelet-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// serving signs a serving certificate for the DNS name and 127.0.0.1
+func (ca *testCA) serving(t *testing.T, dnsName string) tls.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{dnsName},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newTLSKubelet starts a kubelet serving the pods with the certificate
+func newTLSKubelet(t *testing.T, cert tls.Certificate) (*httptest.Server, string, uint) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&v1.PodList{Items: []v1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "pod1"}}}})
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	// the rejected handshakes are expected
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+
+	host, port := serverAddress(t, server)
+	return server, host, port
+}
+
+// serverAddress returns the host and the port of the test server
+func serverAddress(t *testing.T, server *httptest.Server) (string, uint) {
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, uint(p)
+}
+
+func TestKubeletClientTLS(t *testing.T) {
+	ca := newTestCA(t)
+	server, host, port := newTLSKubelet(t, ca.serving(t, "node1"))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "kubelet-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.crt")
+	if err = ioutil.WriteFile(caFile, ca.certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	otherCA := newTestCA(t)
+
+	tests := []struct {
+		name  string
+		tls   restclient.TLSClientConfig
+		valid bool
+	}{
+		{name: "CA file", tls: restclient.TLSClientConfig{CAFile: caFile}, valid: true},
+		{name: "CA data", tls: restclient.TLSClientConfig{CAData: ca.certPEM}, valid: true},
+		{name: "server name", tls: restclient.TLSClientConfig{CAData: ca.certPEM, ServerName: "node1"}, valid: true},
+		{name: "wrong server name", tls: restclient.TLSClientConfig{CAData: ca.certPEM, ServerName: "node2"}, valid: false},
+		{name: "other CA", tls: restclient.TLSClientConfig{CAData: otherCA.certPEM}, valid: false},
+		{name: "system roots", tls: restclient.TLSClientConfig{}, valid: false},
+		{name: "insecure", tls: restclient.TLSClientConfig{Insecure: true}, valid: true},
+	}
+
+	for _, test := range tests {
+		c, err := NewKubeletClient(&KubeletClientConfig{
+			Address:         host,
+			Port:            port,
+			TLSClientConfig: test.tls,
+			HTTPTimeout:     5 * time.Second,
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		pods, err := c.GetNodeRunningPods()
+		if test.valid && (err != nil || len(pods.Items) != 1) {
+			t.Errorf("%s: expected the pods, got %v and %v", test.name, pods, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected the certificate to be rejected", test.name)
+		}
+	}
+}
+
+func TestKubeletClientInsecureWithCA(t *testing.T) {
+	_, err := NewKubeletClient(&KubeletClientConfig{
+		Address:         "127.0.0.1",
+		Port:            10250,
+		TLSClientConfig: restclient.TLSClientConfig{Insecure: true, CAData: newTestCA(t).certPEM},
+	})
+	if err == nil {
+		t.Errorf("expected the CA to be rejected in the insecure mode")
+	}
+}
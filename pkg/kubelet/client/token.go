@@ -0,0 +1,97 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// tokenFileRoundTripper authenticates the requests with the bearer token in the file. The token is
+// read again when the file changes, e.g. the projected service account token is rotated by kubelet,
+// or when kubelet rejects it with 401.
+type tokenFileRoundTripper struct {
+	path string
+	rt   http.RoundTripper
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+}
+
+func newTokenFileRoundTripper(path string, rt http.RoundTripper) (*tokenFileRoundTripper, error) {
+	t := &tokenFileRoundTripper{path: path, rt: rt}
+	if _, err := t.refresh(false); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// refresh reads the token file if it's changed since the last read or force is set, and returns the token
+func (t *tokenFileRoundTripper) refresh(force bool) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return t.token, fmt.Errorf("failed to read the token file %s: %v", t.path, err)
+	}
+	if !force && t.token != "" && info.ModTime().Equal(t.modTime) {
+		return t.token, nil
+	}
+
+	data, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return t.token, fmt.Errorf("failed to read the token file %s: %v", t.path, err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return t.token, fmt.Errorf("the token file %s is empty", t.path)
+	}
+	if t.token != "" && token != t.token {
+		log.Infof("Reloaded the kubelet token from %s", t.path)
+	}
+	t.token, t.modTime = token, info.ModTime()
+	return token, nil
+}
+
+func (t *tokenFileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("Authorization")) != 0 {
+		return t.rt.RoundTrip(req)
+	}
+
+	token, err := t.refresh(false)
+	if err != nil {
+		// keep using the last token, the file may be being replaced
+		log.Warningf("Failed to refresh the kubelet token due to %v", err)
+	}
+	resp, err := t.rt.RoundTrip(withToken(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || req.Body != nil {
+		return resp, err
+	}
+
+	// the token may be rotated without changing the modification time of the file
+	newToken, err := t.refresh(true)
+	if err != nil || newToken == token {
+		return resp, nil
+	}
+	resp.Body.Close()
+	return t.rt.RoundTrip(withToken(req, newToken))
+}
+
+// withToken returns a copy of the request with the bearer token, the request can't be modified by the round tripper
+func withToken(req *http.Request, token string) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
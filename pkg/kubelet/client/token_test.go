@@ -0,0 +1,122 @@
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	restclient "k8s.io/client-go/rest"
+)
+
+// rotatingKubelet accepts only the current token, and records the tokens it's called with
+type rotatingKubelet struct {
+	sync.Mutex
+	token string
+	seen  []string
+}
+
+func (k *rotatingKubelet) rotate(token string) {
+	k.Lock()
+	defer k.Unlock()
+	k.token = token
+}
+
+func (k *rotatingKubelet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.Lock()
+	defer k.Unlock()
+	auth := r.Header.Get("Authorization")
+	k.seen = append(k.seen, auth)
+	if auth != "Bearer "+k.token {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(&v1.PodList{})
+}
+
+func writeToken(t *testing.T, path, token string, modTime time.Time) {
+	if err := ioutil.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKubeletClientTokenRotation(t *testing.T) {
+	kubelet := &rotatingKubelet{token: "token1"}
+	server := httptest.NewTLSServer(kubelet)
+	defer server.Close()
+	host, port := serverAddress(t, server)
+
+	dir, err := ioutil.TempDir("", "kubelet-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	start := time.Now().Add(-time.Hour)
+	writeToken(t, tokenFile, "token1", start)
+
+	c, err := NewKubeletClient(&KubeletClientConfig{
+		Address:         host,
+		Port:            port,
+		TLSClientConfig: restclient.TLSClientConfig{Insecure: true},
+		BearerTokenFile: tokenFile,
+		HTTPTimeout:     5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetNodeRunningPods(); err != nil {
+		t.Fatalf("expected token1 to be accepted, got %v", err)
+	}
+
+	// the file is changed, the new token is used without being rejected first
+	kubelet.rotate("token2")
+	writeToken(t, tokenFile, "token2", start.Add(time.Minute))
+	if _, err = c.GetNodeRunningPods(); err != nil {
+		t.Fatalf("expected token2 to be accepted, got %v", err)
+	}
+
+	// the token is rotated without changing the modification time, it's read again on 401
+	kubelet.rotate("token3")
+	writeToken(t, tokenFile, "token3", start.Add(time.Minute))
+	if _, err = c.GetNodeRunningPods(); err != nil {
+		t.Fatalf("expected token3 to be accepted after 401, got %v", err)
+	}
+
+	// the file is not rotated yet, the request fails without retrying the same token
+	kubelet.rotate("token4")
+	if _, err = c.GetNodeRunningPods(); err == nil {
+		t.Fatalf("expected the stale token to be rejected")
+	}
+
+	expected := []string{"Bearer token1", "Bearer token2", "Bearer token2", "Bearer token3", "Bearer token3"}
+	kubelet.Lock()
+	defer kubelet.Unlock()
+	if len(kubelet.seen) != len(expected) {
+		t.Fatalf("expected the tokens %v, got %v", expected, kubelet.seen)
+	}
+	for i := range expected {
+		if kubelet.seen[i] != expected[i] {
+			t.Errorf("expected the tokens %v, got %v", expected, kubelet.seen)
+			break
+		}
+	}
+}
+
+func TestKubeletClientTokenFile(t *testing.T) {
+	if _, err := NewKubeletClient(&KubeletClientConfig{BearerTokenFile: "/nonexistent/token"}); err == nil {
+		t.Errorf("expected the missing token file to fail")
+	}
+	if _, err := NewKubeletClient(&KubeletClientConfig{BearerToken: "token", BearerTokenFile: "/nonexistent/token"}); err == nil {
+		t.Errorf("expected the token and the token file to conflict")
+	}
+}